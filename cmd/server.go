@@ -8,6 +8,12 @@
 // @license.name Apache 2.0
 // @license.url http://www.apache.org/licenses/LICENSE-2.0.html
 // @BasePath /
+// @securityDefinitions.apikey BearerToken
+// @in header
+// @name Authorization
+// @securityDefinitions.apikey AdminKey
+// @in header
+// @name Authorization
 
 package main
 
@@ -15,7 +21,9 @@ import (
 	"auth/internal/database"
 	"auth/internal/transport"
 	"auth/internal/user"
+	"context"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 func Run() error {
@@ -34,7 +42,21 @@ func Run() error {
 	}
 	log.Info("database migrated")
 	log.Info("creating new user service")
-	userService := user.NewService(store)
+	userService, err := user.NewService(store, user.NewConfig())
+	if err != nil {
+		log.WithError(err).Error("could not create user service")
+		return err
+	}
+	if err := userService.Keys.Load(context.Background()); err != nil {
+		log.WithError(err).Error("could not load signing keys")
+		return err
+	}
+	if interval := userService.Config.KeyRotationInterval; interval > 0 {
+		log.Infof("rotating signing keys every %s", interval)
+		go userService.Keys.RunRotation(context.Background(), interval)
+	}
+	go userService.RunTokenCleanup(context.Background(), time.Hour)
+	go userService.RunPasswordResets(context.Background())
 	log.Info("creating new transport handler")
 	handler := transport.NewHandler(userService)
 	log.Info("starting server")
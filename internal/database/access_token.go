@@ -0,0 +1,71 @@
+package database
+
+import (
+	"auth/internal/user"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type AccessTokenRow struct {
+	TokenHash string    `db:"token_hash"`
+	JTI       string    `db:"jti"`
+	Subject   string    `db:"subject"`
+	Claims    []byte    `db:"claims"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+func convertAccessTokenRowToAccessToken(row AccessTokenRow) (user.AccessToken, error) {
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(row.Claims, &claims); err != nil {
+		return user.AccessToken{}, err
+	}
+	return user.AccessToken{
+		TokenHash: row.TokenHash,
+		JTI:       row.JTI,
+		Subject:   row.Subject,
+		Claims:    claims,
+		ExpiresAt: row.ExpiresAt,
+	}, nil
+}
+
+func (d *Database) CreateAccessToken(ctx context.Context, token user.AccessToken) error {
+	claims, err := json.Marshal(token.Claims)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO access_tokens (token_hash, jti, subject, claims, expires_at) VALUES ($1, $2, $3, $4, $5)"
+	_, err = d.Client.ExecContext(ctx, query, token.TokenHash, token.JTI, token.Subject, claims, token.ExpiresAt)
+	return err
+}
+
+func (d *Database) GetAccessToken(ctx context.Context, tokenHash string) (user.AccessToken, error) {
+	var row AccessTokenRow
+	query := "SELECT token_hash, jti, subject, claims, expires_at FROM access_tokens WHERE token_hash = $1"
+	err := d.Client.GetContext(ctx, &row, query, tokenHash)
+	if err != nil {
+		return user.AccessToken{}, err
+	}
+	return convertAccessTokenRowToAccessToken(row)
+}
+
+func (d *Database) DeleteAccessToken(ctx context.Context, tokenHash string) error {
+	query := "DELETE FROM access_tokens WHERE token_hash = $1"
+	_, err := d.Client.ExecContext(ctx, query, tokenHash)
+	return err
+}
+
+func (d *Database) DeleteUserAccessTokens(ctx context.Context, userID string) error {
+	query := "DELETE FROM access_tokens WHERE subject = $1"
+	_, err := d.Client.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (d *Database) DeleteExpiredAccessTokens(ctx context.Context) (int64, error) {
+	query := "DELETE FROM access_tokens WHERE expires_at <= NOW()"
+	result, err := d.Client.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
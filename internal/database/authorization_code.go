@@ -0,0 +1,99 @@
+package database
+
+import (
+	"auth/internal/user"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type AuthorizationCodeRow struct {
+	CodeHash            string       `db:"code_hash"`
+	ClientID            string       `db:"client_id"`
+	UserID              string       `db:"user_id"`
+	RedirectURI         string       `db:"redirect_uri"`
+	Scope               string       `db:"scope"`
+	CodeChallenge       string       `db:"code_challenge"`
+	CodeChallengeMethod string       `db:"code_challenge_method"`
+	Nonce               string       `db:"nonce"`
+	AuthTime            time.Time    `db:"auth_time"`
+	ExpiresAt           time.Time    `db:"expires_at"`
+	UsedAt              sql.NullTime `db:"used_at"`
+	RefreshFamilyID     string       `db:"refresh_family_id"`
+	AccessTokenJTI      string       `db:"access_token_jti"`
+}
+
+func convertAuthorizationCodeRowToAuthorizationCode(row AuthorizationCodeRow) user.AuthorizationCode {
+	return user.AuthorizationCode{
+		CodeHash:            row.CodeHash,
+		ClientID:            row.ClientID,
+		UserID:              row.UserID,
+		RedirectURI:         row.RedirectURI,
+		Scope:               row.Scope,
+		CodeChallenge:       row.CodeChallenge,
+		CodeChallengeMethod: row.CodeChallengeMethod,
+		Nonce:               row.Nonce,
+		AuthTime:            row.AuthTime,
+		ExpiresAt:           row.ExpiresAt,
+		RefreshFamilyID:     row.RefreshFamilyID,
+		AccessTokenJTI:      row.AccessTokenJTI,
+	}
+}
+
+func (d *Database) CreateAuthorizationCode(ctx context.Context, code user.AuthorizationCode) error {
+	query := `INSERT INTO authorization_codes
+		(code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, nonce, auth_time, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := d.Client.ExecContext(ctx, query, code.CodeHash, code.ClientID, code.UserID, code.RedirectURI,
+		code.Scope, code.CodeChallenge, code.CodeChallengeMethod, code.Nonce, code.AuthTime, code.ExpiresAt)
+	return err
+}
+
+func (d *Database) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (user.AuthorizationCode, error) {
+	var row AuthorizationCodeRow
+	query := `UPDATE authorization_codes SET used_at = NOW()
+		WHERE code_hash = $1 AND used_at IS NULL
+		RETURNING code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, nonce,
+		auth_time, expires_at, used_at, refresh_family_id, access_token_jti`
+	err := d.Client.GetContext(ctx, &row, query, codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return d.usedAuthorizationCode(ctx, codeHash)
+	}
+	if err != nil {
+		return user.AuthorizationCode{}, err
+	}
+	return convertAuthorizationCodeRowToAuthorizationCode(row), nil
+}
+
+// usedAuthorizationCode - the code that could not be consumed, along with the
+// tokens it was redeemed for when it was used before
+func (d *Database) usedAuthorizationCode(ctx context.Context, codeHash string) (user.AuthorizationCode, error) {
+	var row AuthorizationCodeRow
+	query := `SELECT code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, nonce,
+		auth_time, expires_at, used_at, refresh_family_id, access_token_jti
+		FROM authorization_codes WHERE code_hash = $1`
+	err := d.Client.GetContext(ctx, &row, query, codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user.AuthorizationCode{}, user.ErrorAuthorizationCodeUsed
+	}
+	if err != nil {
+		return user.AuthorizationCode{}, err
+	}
+	return convertAuthorizationCodeRowToAuthorizationCode(row), user.ErrorAuthorizationCodeUsed
+}
+
+func (d *Database) SetAuthorizationCodeTokens(ctx context.Context, codeHash string, familyID string, jti string) error {
+	query := "UPDATE authorization_codes SET refresh_family_id = $1, access_token_jti = $2 WHERE code_hash = $3"
+	_, err := d.Client.ExecContext(ctx, query, familyID, jti, codeHash)
+	return err
+}
+
+func (d *Database) DeleteExpiredAuthorizationCodes(ctx context.Context, before time.Time) (int64, error) {
+	query := "DELETE FROM authorization_codes WHERE expires_at <= $1"
+	result, err := d.Client.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
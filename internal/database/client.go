@@ -0,0 +1,81 @@
+package database
+
+import (
+	"auth/internal/user"
+	"context"
+	"strings"
+	"time"
+)
+
+type ClientRow struct {
+	ID                      string    `db:"id"`
+	Name                    string    `db:"name"`
+	RedirectURIs            string    `db:"redirect_uris"`
+	Scopes                  string    `db:"scopes"`
+	GrantTypes              string    `db:"grant_types"`
+	TokenEndpointAuthMethod string    `db:"token_endpoint_auth_method"`
+	SecretHash              string    `db:"secret_hash"`
+	RegistrationTokenHash   string    `db:"registration_token_hash"`
+	FirstParty              bool      `db:"first_party"`
+	CreatedAt               time.Time `db:"created_at"`
+}
+
+func convertClientRowToClient(row ClientRow) user.Client {
+	return user.Client{
+		ID:                      row.ID,
+		Name:                    row.Name,
+		RedirectURIs:            strings.Fields(row.RedirectURIs),
+		Scopes:                  strings.Fields(row.Scopes),
+		GrantTypes:              strings.Fields(row.GrantTypes),
+		TokenEndpointAuthMethod: row.TokenEndpointAuthMethod,
+		SecretHash:              row.SecretHash,
+		RegistrationTokenHash:   row.RegistrationTokenHash,
+		FirstParty:              row.FirstParty,
+		CreatedAt:               row.CreatedAt,
+	}
+}
+
+func (d *Database) GetClient(ctx context.Context, id string) (user.Client, error) {
+	var row ClientRow
+	query := `SELECT id, name, redirect_uris, scopes, grant_types, token_endpoint_auth_method, secret_hash,
+		registration_token_hash, first_party, created_at FROM clients WHERE id = $1`
+	err := d.Client.GetContext(ctx, &row, query, id)
+	if err != nil {
+		return user.Client{}, err
+	}
+	return convertClientRowToClient(row), nil
+}
+
+func (d *Database) CreateClient(ctx context.Context, client user.Client) error {
+	query := `INSERT INTO clients
+		(id, name, redirect_uris, scopes, grant_types, token_endpoint_auth_method, secret_hash, registration_token_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := d.Client.ExecContext(ctx, query, client.ID, client.Name, strings.Join(client.RedirectURIs, " "),
+		strings.Join(client.Scopes, " "), strings.Join(client.GrantTypes, " "), client.TokenEndpointAuthMethod,
+		client.SecretHash, client.RegistrationTokenHash, client.CreatedAt)
+	return err
+}
+
+func (d *Database) UpdateClient(ctx context.Context, client user.Client) error {
+	query := `UPDATE clients SET name = $2, redirect_uris = $3, scopes = $4, grant_types = $5,
+		token_endpoint_auth_method = $6, secret_hash = $7 WHERE id = $1`
+	_, err := d.Client.ExecContext(ctx, query, client.ID, client.Name, strings.Join(client.RedirectURIs, " "),
+		strings.Join(client.Scopes, " "), strings.Join(client.GrantTypes, " "), client.TokenEndpointAuthMethod,
+		client.SecretHash)
+	return err
+}
+
+func (d *Database) DeleteClient(ctx context.Context, id string) error {
+	query := "DELETE FROM clients WHERE id = $1"
+	_, err := d.Client.ExecContext(ctx, query, id)
+	return err
+}
+
+func (d *Database) UpdateClientSecret(ctx context.Context, id string, secretHash string) error {
+	// a client that gets a secret is no longer public
+	query := `UPDATE clients SET secret_hash = $2, token_endpoint_auth_method = CASE
+		WHEN token_endpoint_auth_method = 'none' THEN 'client_secret_basic' ELSE token_endpoint_auth_method END
+		WHERE id = $1`
+	_, err := d.Client.ExecContext(ctx, query, id, secretHash)
+	return err
+}
@@ -1,5 +0,0 @@
-package database
-
-const (
-	saltLength = 16
-)
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"auth/internal/user"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type ConsentRow struct {
+	UserID     string    `db:"user_id"`
+	ClientID   string    `db:"client_id"`
+	ClientName string    `db:"client_name"`
+	Scopes     string    `db:"scopes"`
+	CreatedAt  time.Time `db:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at"`
+}
+
+type ConsentChallengeRow struct {
+	ChallengeHash        string    `db:"challenge_hash"`
+	UserID               string    `db:"user_id"`
+	AuthorizationRequest []byte    `db:"authorization_request"`
+	ExpiresAt            time.Time `db:"expires_at"`
+}
+
+func convertConsentRowToConsent(row ConsentRow) user.Consent {
+	return user.Consent{
+		UserID:     row.UserID,
+		ClientID:   row.ClientID,
+		ClientName: row.ClientName,
+		Scopes:     strings.Fields(row.Scopes),
+		CreatedAt:  row.CreatedAt,
+		UpdatedAt:  row.UpdatedAt,
+	}
+}
+
+func (d *Database) GetConsent(ctx context.Context, userID string, clientID string) (user.Consent, error) {
+	var row ConsentRow
+	query := `SELECT co.user_id, co.client_id, COALESCE(cl.name, '') AS client_name, co.scopes, co.created_at, co.updated_at
+		FROM consents co LEFT JOIN clients cl ON cl.id = co.client_id
+		WHERE co.user_id = $1 AND co.client_id = $2`
+	err := d.Client.GetContext(ctx, &row, query, userID, clientID)
+	if err != nil {
+		return user.Consent{}, err
+	}
+	return convertConsentRowToConsent(row), nil
+}
+
+func (d *Database) SaveConsent(ctx context.Context, consent user.Consent) error {
+	query := `INSERT INTO consents (user_id, client_id, scopes) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = EXCLUDED.scopes, updated_at = NOW()`
+	_, err := d.Client.ExecContext(ctx, query, consent.UserID, consent.ClientID, strings.Join(consent.Scopes, " "))
+	return err
+}
+
+func (d *Database) ListConsents(ctx context.Context, userID string) ([]user.Consent, error) {
+	var rows []ConsentRow
+	query := `SELECT co.user_id, co.client_id, COALESCE(cl.name, '') AS client_name, co.scopes, co.created_at, co.updated_at
+		FROM consents co LEFT JOIN clients cl ON cl.id = co.client_id
+		WHERE co.user_id = $1 ORDER BY co.updated_at DESC`
+	if err := d.Client.SelectContext(ctx, &rows, query, userID); err != nil {
+		return nil, err
+	}
+	consents := make([]user.Consent, 0, len(rows))
+	for _, row := range rows {
+		consents = append(consents, convertConsentRowToConsent(row))
+	}
+	return consents, nil
+}
+
+func (d *Database) DeleteConsent(ctx context.Context, userID string, clientID string) (bool, error) {
+	query := "DELETE FROM consents WHERE user_id = $1 AND client_id = $2"
+	result, err := d.Client.ExecContext(ctx, query, userID, clientID)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+func (d *Database) CreateConsentChallenge(ctx context.Context, challenge user.ConsentChallenge) error {
+	req, err := json.Marshal(challenge.Request)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO consent_challenges (challenge_hash, user_id, authorization_request, expires_at)
+		VALUES ($1, $2, $3, $4)`
+	_, err = d.Client.ExecContext(ctx, query, challenge.ChallengeHash, challenge.UserID, req, challenge.ExpiresAt)
+	return err
+}
+
+func (d *Database) ConsumeConsentChallenge(ctx context.Context, challengeHash string) (user.ConsentChallenge, error) {
+	var row ConsentChallengeRow
+	query := `DELETE FROM consent_challenges WHERE challenge_hash = $1
+		RETURNING challenge_hash, user_id, authorization_request, expires_at`
+	if err := d.Client.GetContext(ctx, &row, query, challengeHash); err != nil {
+		return user.ConsentChallenge{}, err
+	}
+	var req user.AuthorizationRequest
+	if err := json.Unmarshal(row.AuthorizationRequest, &req); err != nil {
+		return user.ConsentChallenge{}, err
+	}
+	return user.ConsentChallenge{
+		ChallengeHash: row.ChallengeHash,
+		UserID:        row.UserID,
+		Request:       req,
+		ExpiresAt:     row.ExpiresAt,
+	}, nil
+}
+
+func (d *Database) DeleteExpiredConsentChallenges(ctx context.Context) (int64, error) {
+	query := "DELETE FROM consent_challenges WHERE expires_at <= NOW()"
+	result, err := d.Client.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
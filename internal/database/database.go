@@ -3,12 +3,14 @@ package database
 import (
 	"auth/internal/user"
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -22,7 +24,7 @@ type Database struct {
 
 func (d *Database) GetUser(ctx context.Context, s string) (user.User, error) {
 	var userRow UserRow
-	query := "SELECT id, email,password FROM users WHERE id = $1"
+	query := "SELECT id, email,password,email_verified,roles FROM users WHERE id = $1"
 	err := d.Client.GetContext(ctx, &userRow, query, s)
 	user := convertUserRowToUser(userRow)
 	if err != nil {
@@ -33,8 +35,11 @@ func (d *Database) GetUser(ctx context.Context, s string) (user.User, error) {
 
 func (d *Database) GetUserByEmail(ctx context.Context, email string) (user.User, error) {
 	var userRow UserRow
-	query := "SELECT id, email,password FROM users WHERE email = $1"
+	query := "SELECT id, email,password,email_verified,roles FROM users WHERE email = $1"
 	err := d.Client.GetContext(ctx, &userRow, query, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user.User{}, user.ErrorUserNotFound
+	}
 	user := convertUserRowToUser(userRow)
 	if err != nil {
 		return user, err
@@ -43,8 +48,11 @@ func (d *Database) GetUserByEmail(ctx context.Context, email string) (user.User,
 }
 func (d *Database) GetUserAndSaltByEmail(ctx context.Context, email string) (user.User, string, error) {
 	var userRow UserRow
-	query := "SELECT id, email,password,salt FROM users WHERE email = $1"
+	query := "SELECT id, email,password,salt,roles FROM users WHERE email = $1"
 	err := d.Client.GetContext(ctx, &userRow, query, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user.User{}, "", user.ErrorUserNotFound
+	}
 	user := convertUserRowToUser(userRow)
 	if err != nil {
 		return user, "", err
@@ -55,22 +63,15 @@ func (d *Database) GetUserAndSaltByEmail(ctx context.Context, email string) (use
 func (d *Database) PostUser(ctx context.Context, u user.User) (user.User, error) {
 	userFound, err := d.GetUserByEmail(ctx, u.Email)
 
-	if userFound != (user.User{}) {
+	if userFound.ID != "" {
 		return u, ErrorUserExists
 	}
 	var userRow UserRow
-	salt, err := generateRandomSalt(saltLength)
-	if err != nil {
-		return u, err
-	}
-	saltedPassword := u.Password + salt
-	encryptedPassword, err := encryptPassword(saltedPassword)
-	if err != nil {
-		return u, err
-	}
-
-	query := "INSERT INTO users (email, password, salt) VALUES ($1, $2, $3) RETURNING id, email, password"
-	err = d.Client.GetContext(ctx, &userRow, query, u.Email, encryptedPassword, salt)
+	// the password is already hashed by the service, argon2id hashes carry
+	// their own salt
+	query := `INSERT INTO users (email, password, salt, email_verified, roles) VALUES ($1, $2, '', $3, $4)
+		RETURNING id, email, password, email_verified, roles`
+	err = d.Client.GetContext(ctx, &userRow, query, u.Email, u.Password, u.EmailVerified, strings.Join(u.Roles, " "))
 
 	u = convertUserRowToUser(userRow)
 	if err != nil {
@@ -81,8 +82,11 @@ func (d *Database) PostUser(ctx context.Context, u user.User) (user.User, error)
 
 func (d *Database) UpdateUser(ctx context.Context, usr user.User) (user.User, error) {
 	var userRow UserRow
-	query := "UPDATE users SET email = $1, password = $2 WHERE id = $3 RETURNING id, email, password"
-	err := d.Client.GetContext(ctx, &userRow, query, usr.Email, usr.Password, usr.ID)
+	// passwords are changed with UpdateUserPassword, a new email has not
+	// been verified
+	query := `UPDATE users SET email = $1, email_verified = (email_verified AND email = $1)
+		WHERE id = $2 RETURNING id, email, email_verified, roles`
+	err := d.Client.GetContext(ctx, &userRow, query, usr.Email, usr.ID)
 	usr = convertUserRowToUser(userRow)
 	if err != nil {
 		return user.User{}, errors.New("could not update user")
@@ -90,6 +94,18 @@ func (d *Database) UpdateUser(ctx context.Context, usr user.User) (user.User, er
 	return usr, nil
 }
 
+func (d *Database) UpdateUserPassword(ctx context.Context, id string, passwordHash string) error {
+	query := "UPDATE users SET password = $1, salt = '' WHERE id = $2"
+	_, err := d.Client.ExecContext(ctx, query, passwordHash, id)
+	return err
+}
+
+func (d *Database) UpdateUserRoles(ctx context.Context, id string, roles []string) error {
+	query := "UPDATE users SET roles = $1 WHERE id = $2"
+	_, err := d.Client.ExecContext(ctx, query, strings.Join(roles, " "), id)
+	return err
+}
+
 func (d *Database) DeleteUser(ctx context.Context, s string) error {
 	query := "DELETE FROM users WHERE id = $1"
 	_, err := d.Client.ExecContext(ctx, query, s)
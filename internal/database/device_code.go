@@ -0,0 +1,110 @@
+package database
+
+import (
+	"auth/internal/user"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type DeviceCodeRow struct {
+	DeviceCodeHash string       `db:"device_code_hash"`
+	UserCode       string       `db:"user_code"`
+	ClientID       string       `db:"client_id"`
+	Scope          string       `db:"scope"`
+	UserID         string       `db:"user_id"`
+	Status         string       `db:"status"`
+	PollInterval   int64        `db:"poll_interval"`
+	LastPolledAt   sql.NullTime `db:"last_polled_at"`
+	ExpiresAt      time.Time    `db:"expires_at"`
+}
+
+const deviceCodeColumns = "device_code_hash, user_code, client_id, scope, user_id, status, poll_interval, last_polled_at, expires_at"
+
+func convertDeviceCodeRowToDeviceCode(row DeviceCodeRow) user.DeviceCode {
+	return user.DeviceCode{
+		DeviceCodeHash: row.DeviceCodeHash,
+		UserCode:       row.UserCode,
+		ClientID:       row.ClientID,
+		Scope:          row.Scope,
+		UserID:         row.UserID,
+		Status:         row.Status,
+		Interval:       time.Duration(row.PollInterval) * time.Second,
+		LastPolledAt:   nullTimeToPointer(row.LastPolledAt),
+		ExpiresAt:      row.ExpiresAt,
+	}
+}
+
+func (d *Database) CreateDeviceCode(ctx context.Context, code user.DeviceCode) error {
+	query := `INSERT INTO device_codes (device_code_hash, user_code, client_id, scope, status, poll_interval, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := d.Client.ExecContext(ctx, query, code.DeviceCodeHash, code.UserCode, code.ClientID, code.Scope,
+		code.Status, int64(code.Interval.Seconds()), code.ExpiresAt)
+	return err
+}
+
+func (d *Database) GetDeviceCodeByUserCode(ctx context.Context, userCode string) (user.DeviceCode, error) {
+	var row DeviceCodeRow
+	query := "SELECT " + deviceCodeColumns + " FROM device_codes WHERE user_code = $1"
+	err := d.Client.GetContext(ctx, &row, query, userCode)
+	if err != nil {
+		return user.DeviceCode{}, err
+	}
+	return convertDeviceCodeRowToDeviceCode(row), nil
+}
+
+func (d *Database) DecideDeviceCode(ctx context.Context, userCode string, status string, userID string) (bool, error) {
+	query := "UPDATE device_codes SET status = $2, user_id = $3 WHERE user_code = $1 AND status = 'pending'"
+	result, err := d.Client.ExecContext(ctx, query, userCode, status, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// PollDeviceCode - the self join reads the row as it was before the update
+func (d *Database) PollDeviceCode(ctx context.Context, deviceCodeHash string) (user.DeviceCode, error) {
+	var row DeviceCodeRow
+	query := `UPDATE device_codes AS dc SET last_polled_at = NOW()
+		FROM device_codes AS prev
+		WHERE dc.device_code_hash = $1 AND prev.device_code_hash = dc.device_code_hash
+		RETURNING prev.device_code_hash, prev.user_code, prev.client_id, prev.scope, prev.user_id,
+			prev.status, prev.poll_interval, prev.last_polled_at, prev.expires_at`
+	err := d.Client.GetContext(ctx, &row, query, deviceCodeHash)
+	if err != nil {
+		return user.DeviceCode{}, err
+	}
+	return convertDeviceCodeRowToDeviceCode(row), nil
+}
+
+func (d *Database) SlowDownDeviceCode(ctx context.Context, deviceCodeHash string, by time.Duration) error {
+	query := "UPDATE device_codes SET poll_interval = poll_interval + $2 WHERE device_code_hash = $1"
+	_, err := d.Client.ExecContext(ctx, query, deviceCodeHash, int64(by.Seconds()))
+	return err
+}
+
+func (d *Database) RedeemDeviceCode(ctx context.Context, deviceCodeHash string) (bool, error) {
+	query := "UPDATE device_codes SET status = 'redeemed' WHERE device_code_hash = $1 AND status = 'approved'"
+	result, err := d.Client.ExecContext(ctx, query, deviceCodeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (d *Database) DeleteExpiredDeviceCodes(ctx context.Context) (int64, error) {
+	query := "DELETE FROM device_codes WHERE expires_at <= NOW()"
+	result, err := d.Client.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
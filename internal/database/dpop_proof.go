@@ -0,0 +1,28 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+func (d *Database) RecordDPoPProof(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	query := "INSERT INTO dpop_proofs (id, expires_at) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING"
+	result, err := d.Client.ExecContext(ctx, query, id, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (d *Database) DeleteExpiredDPoPProofs(ctx context.Context) (int64, error) {
+	query := "DELETE FROM dpop_proofs WHERE expires_at <= NOW()"
+	result, err := d.Client.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"auth/internal/user"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+type FederatedIdentityRow struct {
+	Provider string `db:"provider"`
+	Subject  string `db:"subject"`
+	UserID   string `db:"user_id"`
+}
+
+type FederatedLoginRow struct {
+	StateHash            string    `db:"state_hash"`
+	Provider             string    `db:"provider"`
+	Nonce                string    `db:"nonce"`
+	CodeVerifier         string    `db:"code_verifier"`
+	AuthorizationRequest []byte    `db:"authorization_request"`
+	ExpiresAt            time.Time `db:"expires_at"`
+}
+
+func convertFederatedLoginRowToFederatedLogin(row FederatedLoginRow) (user.FederatedLogin, error) {
+	var req user.AuthorizationRequest
+	if err := json.Unmarshal(row.AuthorizationRequest, &req); err != nil {
+		return user.FederatedLogin{}, err
+	}
+	return user.FederatedLogin{
+		StateHash:    row.StateHash,
+		Provider:     row.Provider,
+		Nonce:        row.Nonce,
+		CodeVerifier: row.CodeVerifier,
+		Request:      req,
+		ExpiresAt:    row.ExpiresAt,
+	}, nil
+}
+
+func (d *Database) GetFederatedIdentity(ctx context.Context, provider string, subject string) (user.FederatedIdentity, error) {
+	var row FederatedIdentityRow
+	query := "SELECT provider, subject, user_id FROM federated_identities WHERE provider = $1 AND subject = $2"
+	err := d.Client.GetContext(ctx, &row, query, provider, subject)
+	if err != nil {
+		return user.FederatedIdentity{}, err
+	}
+	return user.FederatedIdentity{
+		Provider: row.Provider,
+		Subject:  row.Subject,
+		UserID:   row.UserID,
+	}, nil
+}
+
+func (d *Database) HasFederatedIdentity(ctx context.Context, userID string, provider string) (bool, error) {
+	var linked bool
+	query := "SELECT EXISTS (SELECT 1 FROM federated_identities WHERE user_id = $1 AND provider = $2)"
+	if err := d.Client.GetContext(ctx, &linked, query, userID, provider); err != nil {
+		return false, err
+	}
+	return linked, nil
+}
+
+func (d *Database) CreateFederatedIdentity(ctx context.Context, identity user.FederatedIdentity) error {
+	query := "INSERT INTO federated_identities (provider, subject, user_id) VALUES ($1, $2, $3)"
+	_, err := d.Client.ExecContext(ctx, query, identity.Provider, identity.Subject, identity.UserID)
+	return err
+}
+
+func (d *Database) CreateFederatedLogin(ctx context.Context, login user.FederatedLogin) error {
+	req, err := json.Marshal(login.Request)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO federated_logins (state_hash, provider, nonce, code_verifier, authorization_request, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = d.Client.ExecContext(ctx, query, login.StateHash, login.Provider, login.Nonce, login.CodeVerifier, req, login.ExpiresAt)
+	return err
+}
+
+func (d *Database) ConsumeFederatedLogin(ctx context.Context, stateHash string) (user.FederatedLogin, error) {
+	var row FederatedLoginRow
+	query := `DELETE FROM federated_logins WHERE state_hash = $1
+		RETURNING state_hash, provider, nonce, code_verifier, authorization_request, expires_at`
+	err := d.Client.GetContext(ctx, &row, query, stateHash)
+	if err != nil {
+		return user.FederatedLogin{}, err
+	}
+	return convertFederatedLoginRowToFederatedLogin(row)
+}
+
+func (d *Database) DeleteExpiredFederatedLogins(ctx context.Context) (int64, error) {
+	query := "DELETE FROM federated_logins WHERE expires_at <= NOW()"
+	result, err := d.Client.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
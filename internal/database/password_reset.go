@@ -0,0 +1,94 @@
+package database
+
+import (
+	"auth/internal/user"
+	"context"
+	"time"
+)
+
+type PasswordResetRow struct {
+	TokenHash string    `db:"token_hash"`
+	UserID    string    `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+func convertPasswordResetRowToPasswordReset(row PasswordResetRow) user.PasswordReset {
+	return user.PasswordReset{
+		TokenHash: row.TokenHash,
+		UserID:    row.UserID,
+		CreatedAt: row.CreatedAt,
+		ExpiresAt: row.ExpiresAt,
+	}
+}
+
+func (d *Database) CreatePasswordReset(ctx context.Context, reset user.PasswordReset) error {
+	query := "INSERT INTO password_resets (token_hash, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)"
+	_, err := d.Client.ExecContext(ctx, query, reset.TokenHash, reset.UserID, reset.CreatedAt, reset.ExpiresAt)
+	return err
+}
+
+func (d *Database) GetPasswordReset(ctx context.Context, tokenHash string) (user.PasswordReset, error) {
+	var row PasswordResetRow
+	query := "SELECT token_hash, user_id, created_at, expires_at FROM password_resets WHERE token_hash = $1"
+	if err := d.Client.GetContext(ctx, &row, query, tokenHash); err != nil {
+		return user.PasswordReset{}, err
+	}
+	return convertPasswordResetRowToPasswordReset(row), nil
+}
+
+func (d *Database) PasswordResetRequestedSince(ctx context.Context, userID string, since time.Time) (bool, error) {
+	var recent bool
+	query := "SELECT EXISTS (SELECT 1 FROM password_resets WHERE user_id = $1 AND created_at > $2)"
+	if err := d.Client.GetContext(ctx, &recent, query, userID, since); err != nil {
+		return false, err
+	}
+	return recent, nil
+}
+
+func (d *Database) ResetUserPassword(ctx context.Context, tokenHash string, userID string, passwordHash string, revokedBefore time.Time) (bool, error) {
+	tx, err := d.Client.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := "DELETE FROM password_resets WHERE token_hash = $1 AND user_id = $2 AND expires_at > NOW()"
+	result, err := tx.ExecContext(ctx, query, tokenHash, userID)
+	if err != nil {
+		return false, err
+	}
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		return false, err
+	}
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"UPDATE users SET password = $1, salt = '', tokens_revoked_before = $2 WHERE id = $3", []interface{}{passwordHash, revokedBefore, userID}},
+		{"DELETE FROM password_resets WHERE user_id = $1", []interface{}{userID}},
+		{"UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", []interface{}{userID}},
+		{"DELETE FROM access_tokens WHERE subject = $1", []interface{}{userID}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+func (d *Database) DeleteUserPasswordResets(ctx context.Context, userID string) error {
+	query := "DELETE FROM password_resets WHERE user_id = $1"
+	_, err := d.Client.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (d *Database) DeleteExpiredPasswordResets(ctx context.Context) (int64, error) {
+	query := "DELETE FROM password_resets WHERE expires_at <= NOW()"
+	result, err := d.Client.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
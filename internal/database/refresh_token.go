@@ -0,0 +1,99 @@
+package database
+
+import (
+	"auth/internal/user"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RefreshTokenRow struct {
+	ID        string       `db:"id"`
+	UserID    string       `db:"user_id"`
+	FamilyID  string       `db:"family_id"`
+	TokenHash string       `db:"token_hash"`
+	ClientID  string       `db:"client_id"`
+	Scope     string       `db:"scope"`
+	JKT       string       `db:"jkt"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+}
+
+func convertRefreshTokenRowToRefreshToken(row RefreshTokenRow) user.RefreshToken {
+	return user.RefreshToken{
+		ID:        row.ID,
+		UserID:    row.UserID,
+		FamilyID:  row.FamilyID,
+		TokenHash: row.TokenHash,
+		ClientID:  row.ClientID,
+		Scope:     row.Scope,
+		JKT:       row.JKT,
+		ExpiresAt: row.ExpiresAt,
+		UsedAt:    nullTimeToPointer(row.UsedAt),
+		RevokedAt: nullTimeToPointer(row.RevokedAt),
+	}
+}
+
+func (d *Database) CreateRefreshToken(ctx context.Context, token user.RefreshToken) error {
+	query := "INSERT INTO refresh_tokens (user_id, family_id, token_hash, client_id, scope, jkt, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err := d.Client.ExecContext(ctx, query, token.UserID, token.FamilyID, token.TokenHash, token.ClientID, token.Scope, token.JKT, token.ExpiresAt)
+	return err
+}
+
+func (d *Database) GetRefreshToken(ctx context.Context, tokenHash string) (user.RefreshToken, error) {
+	var row RefreshTokenRow
+	query := "SELECT id, user_id, family_id, token_hash, client_id, scope, jkt, expires_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash = $1"
+	err := d.Client.GetContext(ctx, &row, query, tokenHash)
+	if err != nil {
+		return user.RefreshToken{}, err
+	}
+	return convertRefreshTokenRowToRefreshToken(row), nil
+}
+
+func (d *Database) MarkRefreshTokenUsed(ctx context.Context, id string) (bool, error) {
+	query := "UPDATE refresh_tokens SET used_at = NOW() WHERE id = $1 AND used_at IS NULL"
+	result, err := d.Client.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (d *Database) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	query := "UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL"
+	_, err := d.Client.ExecContext(ctx, query, familyID)
+	return err
+}
+
+func (d *Database) RevokeClientRefreshTokens(ctx context.Context, userID string, clientID string) error {
+	query := "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND client_id = $2 AND revoked_at IS NULL"
+	_, err := d.Client.ExecContext(ctx, query, userID, clientID)
+	return err
+}
+
+func (d *Database) RevokeUserRefreshTokens(ctx context.Context, userID string) error {
+	query := "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL"
+	_, err := d.Client.ExecContext(ctx, query, userID)
+	return err
+}
+
+func nullTimeToPointer(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (d *Database) DeleteExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	query := "DELETE FROM refresh_tokens WHERE expires_at <= NOW()"
+	result, err := d.Client.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+func (d *Database) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	query := "INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING"
+	_, err := d.Client.ExecContext(ctx, query, jti, expiresAt)
+	return err
+}
+
+func (d *Database) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	query := "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1 AND expires_at > NOW())"
+	err := d.Client.GetContext(ctx, &revoked, query, jti)
+	return revoked, err
+}
+
+func (d *Database) RevokeUserTokens(ctx context.Context, userID string, before time.Time) error {
+	query := "UPDATE users SET tokens_revoked_before = $1 WHERE id = $2"
+	_, err := d.Client.ExecContext(ctx, query, before, userID)
+	return err
+}
+
+func (d *Database) UserTokensRevokedBefore(ctx context.Context, userID string) (time.Time, error) {
+	// subjects that are not user ids can not have been revoked this way
+	if _, err := strconv.Atoi(userID); err != nil {
+		return time.Time{}, nil
+	}
+	var before sql.NullTime
+	query := "SELECT tokens_revoked_before FROM users WHERE id = $1"
+	err := d.Client.GetContext(ctx, &before, query, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return before.Time, err
+}
+
+func (d *Database) DeleteExpiredRevokedTokens(ctx context.Context) (int64, error) {
+	query := "DELETE FROM revoked_tokens WHERE expires_at <= NOW()"
+	result, err := d.Client.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"auth/internal/user"
+	"context"
+	"time"
+)
+
+// signingKeyRotationLock identifies the advisory lock replicas take to rotate
+// signing keys one at a time
+const signingKeyRotationLock = 0x6a776b73
+
+type SigningKeyRow struct {
+	ID           string    `db:"id"`
+	Algorithm    string    `db:"algorithm"`
+	EncryptedKey []byte    `db:"encrypted_key"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+func convertSigningKeyRowToStoredSigningKey(row SigningKeyRow) user.StoredSigningKey {
+	return user.StoredSigningKey{
+		ID:           row.ID,
+		Algorithm:    row.Algorithm,
+		EncryptedKey: row.EncryptedKey,
+		CreatedAt:    row.CreatedAt,
+	}
+}
+
+func (d *Database) CreateSigningKey(ctx context.Context, key user.StoredSigningKey) error {
+	query := "INSERT INTO signing_keys (id, algorithm, encrypted_key, created_at) VALUES ($1, $2, $3, $4)"
+	_, err := d.Client.ExecContext(ctx, query, key.ID, key.Algorithm, key.EncryptedKey, key.CreatedAt)
+	return err
+}
+
+func (d *Database) GetSigningKeys(ctx context.Context, algorithm string, after time.Time) ([]user.StoredSigningKey, error) {
+	var rows []SigningKeyRow
+	// keys created before the last one preceding after were retired too long ago
+	query := `SELECT id, algorithm, encrypted_key, created_at FROM signing_keys
+		WHERE algorithm = $1 AND created_at >= COALESCE(
+			(SELECT MAX(created_at) FROM signing_keys WHERE algorithm = $1 AND created_at <= $2), '-infinity')
+		ORDER BY created_at`
+	if err := d.Client.SelectContext(ctx, &rows, query, algorithm, after); err != nil {
+		return nil, err
+	}
+	keys := make([]user.StoredSigningKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, convertSigningKeyRowToStoredSigningKey(row))
+	}
+	return keys, nil
+}
+
+// LockSigningKeyRotation - takes a transaction scoped advisory lock, ending
+// the transaction releases it even when the connection is lost
+func (d *Database) LockSigningKeyRotation(ctx context.Context) (func(), bool, error) {
+	tx, err := d.Client.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	var locked bool
+	if err := tx.GetContext(ctx, &locked, "SELECT pg_try_advisory_xact_lock($1)", signingKeyRotationLock); err != nil {
+		tx.Rollback()
+		return nil, false, err
+	}
+	if !locked {
+		tx.Rollback()
+		return nil, false, nil
+	}
+	return func() { tx.Rollback() }, true, nil
+}
@@ -2,39 +2,24 @@ package database
 
 import (
 	"auth/internal/user"
-	"crypto/rand"
-	"encoding/base64"
-	"golang.org/x/crypto/bcrypt"
+	"strings"
 )
 
 type UserRow struct {
-	ID       string
-	Email    string
-	Password string
-	Salt     string
+	ID            string
+	Email         string
+	Password      string
+	Salt          string
+	EmailVerified bool   `db:"email_verified"`
+	Roles         string `db:"roles"`
 }
 
 func convertUserRowToUser(userRow UserRow) user.User {
 	return user.User{
-		ID:       userRow.ID,
-		Email:    userRow.Email,
-		Password: userRow.Password,
+		ID:            userRow.ID,
+		Email:         userRow.Email,
+		Password:      userRow.Password,
+		EmailVerified: userRow.EmailVerified,
+		Roles:         strings.Fields(userRow.Roles),
 	}
 }
-func generateRandomSalt(length int) (string, error) {
-	salt := make([]byte, length)
-	_, err := rand.Read(salt)
-	if err != nil {
-		return "", err
-	}
-
-	return base64.URLEncoding.EncodeToString(salt), nil
-}
-func encryptPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-
-	return string(hashedPassword), nil
-}
@@ -0,0 +1,190 @@
+package transport
+
+import (
+	"auth/internal/user"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<h1>Sign in to {{.ClientID}}</h1>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+  <input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+  <input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+  <input type="hidden" name="redirect_uri" value="{{.Request.SentRedirectURI}}">
+  <input type="hidden" name="scope" value="{{.Request.Scope}}">
+  <input type="hidden" name="state" value="{{.Request.State}}">
+  <input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+  <input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+  <input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+  <label>Email <input type="email" name="email" required></label>
+  <label>Password <input type="password" name="password" required></label>
+  <button type="submit">Sign in</button>
+</form>
+{{range .FederatedLogins}}<p><a href="{{.URL}}">Sign in with {{.Name}}</a></p>
+{{end}}</body>
+</html>`))
+
+type loginPageData struct {
+	ClientID        string
+	Request         user.AuthorizationRequest
+	Error           string
+	FederatedLogins []federatedLoginLink
+}
+
+type federatedLoginLink struct {
+	Name string
+	URL  string
+}
+
+// Authorize godoc
+// @Summary OAuth 2.0 authorization endpoint
+// @Description Start the authorization code flow, a S256 PKCE code challenge is required. GET shows the sign in form, POST signs the user in and redirects back with a code
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Produce  html
+// @Param response_type query string true "code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string false "Registered redirect URI"
+// @Param scope query string false "Space separated scopes"
+// @Param state query string false "Opaque value returned with the code"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "S256"
+// @Param nonce query string false "OpenID Connect nonce copied into the ID token"
+// @Success 200
+// @Success 302
+// @Router /oauth/authorize [get]
+func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.authorizationRequest(w, r)
+	if !ok {
+		return
+	}
+	h.renderLoginPage(w, http.StatusOK, req, "")
+}
+
+// AuthorizeLogin - handles the sign in form of the authorization endpoint
+func (h *Handler) AuthorizeLogin(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.authorizationRequest(w, r)
+	if !ok {
+		return
+	}
+	usr, err := h.Service.Login(r.Context(), r.PostFormValue("email"), r.PostFormValue("password"))
+	if err != nil {
+		log.WithError(err).Error("invalid login or password")
+		h.renderLoginPage(w, http.StatusUnauthorized, req, "Invalid login or password")
+		return
+	}
+	h.authorizeOrConsent(w, r, req, usr)
+}
+
+// authorizationRequest - validates the authorization request, writing the
+// error response when it is not valid
+func (h *Handler) authorizationRequest(w http.ResponseWriter, r *http.Request) (user.AuthorizationRequest, bool) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return user.AuthorizationRequest{}, false
+	}
+	req := user.AuthorizationRequest{
+		ResponseType:        r.FormValue("response_type"),
+		ClientID:            r.FormValue("client_id"),
+		RedirectURI:         r.FormValue("redirect_uri"),
+		Scope:               r.FormValue("scope"),
+		State:               r.FormValue("state"),
+		CodeChallenge:       r.FormValue("code_challenge"),
+		CodeChallengeMethod: r.FormValue("code_challenge_method"),
+		Nonce:               r.FormValue("nonce"),
+	}
+
+	// an unknown client or redirect uri must not be redirected to
+	req, err := h.Service.ValidateAuthorizationRequest(r.Context(), req)
+	if err != nil {
+		log.WithError(err).Error("invalid authorization request")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return req, false
+	}
+
+	req, err = h.Service.CheckAuthorizationRequest(r.Context(), req)
+	if err != nil {
+		log.WithError(err).Error("invalid authorization request")
+		redirectWithParams(w, r, req.RedirectURI, url.Values{
+			"error":             {authorizationErrorCode(err)},
+			"error_description": {err.Error()},
+			"state":             {req.State},
+		})
+		return req, false
+	}
+	return req, true
+}
+
+func authorizationErrorCode(err error) string {
+	switch {
+	case errors.Is(err, user.ErrorUnsupportedResponse):
+		return "unsupported_response_type"
+	case errors.Is(err, user.ErrorInvalidScope):
+		return "invalid_scope"
+	}
+	return "invalid_request"
+}
+
+func (h *Handler) renderLoginPage(w http.ResponseWriter, status int, req user.AuthorizationRequest, message string) {
+	data := loginPageData{ClientID: req.ClientID, Request: req, Error: message}
+	// the federated sign in resumes the authorization request once the user is back
+	for _, provider := range h.Service.FederatedProviders() {
+		data.FederatedLogins = append(data.FederatedLogins, federatedLoginLink{
+			Name: provider,
+			URL:  "/auth/federated/" + url.PathEscape(provider) + "/login?" + authorizationRequestQuery(req).Encode(),
+		})
+	}
+	for _, provider := range h.Service.SAMLProviders() {
+		data.FederatedLogins = append(data.FederatedLogins, federatedLoginLink{
+			Name: provider,
+			URL:  "/auth/saml/" + url.PathEscape(provider) + "/login?" + authorizationRequestQuery(req).Encode(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := loginPage.Execute(w, data); err != nil {
+		log.Errorf("Error rendering login page: %v", err)
+	}
+}
+
+func authorizationRequestQuery(req user.AuthorizationRequest) url.Values {
+	return url.Values{
+		"response_type":         {req.ResponseType},
+		"client_id":             {req.ClientID},
+		"redirect_uri":          {req.SentRedirectURI()},
+		"scope":                 {req.Scope},
+		"state":                 {req.State},
+		"code_challenge":        {req.CodeChallenge},
+		"code_challenge_method": {req.CodeChallengeMethod},
+		"nonce":                 {req.Nonce},
+	}
+}
+
+// redirectWithParams - redirects to the uri with the non empty params added to its query
+func redirectWithParams(w http.ResponseWriter, r *http.Request, uri string, params url.Values) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
@@ -0,0 +1,195 @@
+package transport
+
+import (
+	"auth/internal/user"
+	"errors"
+	chi "github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Allow access</title></head>
+<body>
+<h1>{{.ClientName}} wants to access your account</h1>
+<p>It asks for:</p>
+<ul>
+{{range .Scopes}}  <li>{{.}}</li>
+{{end}}</ul>
+<form method="post" action="/oauth/consent">
+  <input type="hidden" name="challenge" value="{{.Challenge}}">
+  <button type="submit" name="action" value="approve">Allow</button>
+  <button type="submit" name="action" value="deny">Deny</button>
+</form>
+</body>
+</html>`))
+
+type consentPageData struct {
+	Challenge  string
+	ClientName string
+	Scopes     []string
+}
+
+// authorizeOrConsent - issues the authorization code, or asks the user to
+// approve the request first when the client needs their consent
+func (h *Handler) authorizeOrConsent(w http.ResponseWriter, r *http.Request, req user.AuthorizationRequest, usr user.User) {
+	prompt, err := h.Service.PromptConsent(r.Context(), req, usr)
+	if err != nil {
+		log.WithError(err).Error("error checking consent")
+		redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {"server_error"}, "state": {req.State}})
+		return
+	}
+	if prompt != nil {
+		renderConsentPage(w, *prompt)
+		return
+	}
+	h.issueAuthorizationCode(w, r, req, usr)
+}
+
+func (h *Handler) issueAuthorizationCode(w http.ResponseWriter, r *http.Request, req user.AuthorizationRequest, usr user.User) {
+	code, err := h.Service.Authorize(r.Context(), req, usr)
+	if err != nil {
+		log.WithError(err).Error("error issuing authorization code")
+		redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {"server_error"}, "state": {req.State}})
+		return
+	}
+	redirectWithParams(w, r, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+func renderConsentPage(w http.ResponseWriter, prompt user.ConsentPrompt) {
+	name := prompt.Client.Name
+	if name == "" {
+		name = prompt.Client.ID
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(http.StatusOK)
+	if err := consentPage.Execute(w, consentPageData{
+		Challenge:  prompt.Challenge,
+		ClientName: name,
+		Scopes:     prompt.Scopes,
+	}); err != nil {
+		log.Errorf("Error rendering consent page: %v", err)
+	}
+}
+
+// ConsentDecision godoc
+// @Summary Approve or deny a consent prompt
+// @Description Handles the consent form shown after sign in, redirects back to the client with a code or with access_denied
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Param challenge formData string true "Challenge of the consent prompt"
+// @Param action formData string true "approve or deny"
+// @Success 302
+// @Router /oauth/consent [post]
+func (h *Handler) ConsentDecision(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	approved := r.PostFormValue("action") == "approve"
+	usr, req, err := h.Service.DecideConsent(r.Context(), r.PostFormValue("challenge"), approved)
+	if err != nil && errors.Is(err, user.ErrorConsentDenied) {
+		redirectWithParams(w, r, req.RedirectURI, url.Values{"error": {"access_denied"}, "state": {req.State}})
+		return
+	}
+	if err != nil && errors.Is(err, user.ErrorConsentChallenge) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error recording consent")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.issueAuthorizationCode(w, r, req, usr)
+}
+
+// ListConsents godoc
+// @Summary List consents
+// @Description Clients the signed in user shared scopes with
+// @Tags users
+// @Produce  json
+// @Security BearerToken
+// @Success 200 {array} ConsentResponse
+// @Failure 401
+// @Failure 403
+// @Router /auth/consents [get]
+func (h *Handler) ListConsents(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.consentOwner(w, r)
+	if !ok {
+		return
+	}
+	consents, err := h.Service.Consents(r.Context(), userID)
+	if err != nil {
+		log.WithError(err).Error("error listing consents")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response := make([]ConsentResponse, 0, len(consents))
+	for _, consent := range consents {
+		response = append(response, ConsentResponse{
+			ClientID:   consent.ClientID,
+			ClientName: consent.ClientName,
+			Scope:      strings.Join(consent.Scopes, " "),
+			CreatedAt:  consent.CreatedAt.Unix(),
+			UpdatedAt:  consent.UpdatedAt.Unix(),
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// RevokeConsent godoc
+// @Summary Revoke a consent
+// @Description Withdraw the consent given to a client, its refresh tokens for the user are revoked and it has to ask again
+// @Tags users
+// @Security BearerToken
+// @Param client_id path string true "Client ID"
+// @Success 204
+// @Failure 401
+// @Failure 403
+// @Failure 404
+// @Router /auth/consents/{client_id} [delete]
+func (h *Handler) RevokeConsent(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.consentOwner(w, r)
+	if !ok {
+		return
+	}
+	err := h.Service.RevokeConsent(r.Context(), userID, chi.URLParam(r, "client_id"))
+	if err != nil && errors.Is(err, user.ErrorConsentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error revoking consent")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// consentOwner - authenticates the request and returns the user whose
+// consents it may manage, writing the error response otherwise
+func (h *Handler) consentOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	claims, err := h.authenticate(r)
+	if err != nil {
+		log.WithError(err).Error("error validating token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid auth token"))
+		return "", false
+	}
+	userID, err := h.Service.ConsentOwner(r.Context(), claims)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("this token can not manage consents"))
+		return "", false
+	}
+	return userID, true
+}
@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"auth/internal/user"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"html/template"
+	"net/http"
+)
+
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+<h1>Connect a device</h1>
+{{if .Message}}<p>{{.Message}}</p>{{else}}
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+{{if .ClientID}}<p>{{.ClientID}} asks for access to: {{.Scope}}</p>{{end}}
+<form method="post" action="/oauth/device">
+  <label>Code <input type="text" name="user_code" value="{{.UserCode}}" autocomplete="off" required></label>
+  <label>Email <input type="email" name="email" required></label>
+  <label>Password <input type="password" name="password" required></label>
+  <button type="submit" name="action" value="approve">Approve</button>
+  <button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>`))
+
+type devicePageData struct {
+	UserCode string
+	ClientID string
+	Scope    string
+	Error    string
+	Message  string
+}
+
+// DeviceAuthorization godoc
+// @Summary OAuth 2.0 device authorization endpoint
+// @Description Start the RFC 8628 device flow, the device shows the user code and polls the token endpoint with the device code
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Produce  json
+// @Param client_id formData string true "Client ID"
+// @Param scope formData string false "Space separated scopes"
+// @Success 200 {object} DeviceAuthorizationResponse
+// @Failure 400 {object} OAuthError
+// @Failure 401 {object} OAuthError
+// @Router /oauth/device_authorization [post]
+func (h *Handler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "could not parse form")
+		return
+	}
+	clientID, secret, _ := clientCredentials(r)
+	client, err := h.Service.AuthenticateClient(r.Context(), clientID, secret)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	authorization, err := h.Service.StartDeviceAuthorization(r.Context(), client, r.PostFormValue("scope"))
+	if err != nil {
+		log.WithError(err).Error("error starting device authorization")
+		writeTokenError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, DeviceAuthorizationResponse{
+		DeviceCode:              authorization.DeviceCode,
+		UserCode:                authorization.UserCode,
+		VerificationURI:         authorization.VerificationURI,
+		VerificationURIComplete: authorization.VerificationURIComplete,
+		ExpiresIn:               authorization.ExpiresIn,
+		Interval:                authorization.Interval,
+	})
+}
+
+// Device godoc
+// @Summary Device verification page
+// @Description Page where a user enters the code shown on a device, signs in and approves or denies the device
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Produce  html
+// @Param user_code query string false "Code shown on the device"
+// @Success 200
+// @Router /oauth/device [get]
+func (h *Handler) Device(w http.ResponseWriter, r *http.Request) {
+	data := devicePageData{UserCode: r.URL.Query().Get("user_code")}
+	if data.UserCode != "" {
+		code, err := h.Service.LookupUserCode(r.Context(), data.UserCode)
+		if err != nil {
+			data.Error = "This code is not valid anymore, check the code on your device"
+		} else {
+			data.ClientID = code.ClientID
+			data.Scope = code.Scope
+		}
+	}
+	renderDevicePage(w, http.StatusOK, data)
+}
+
+// DeviceApproval - handles the form of the device verification page
+func (h *Handler) DeviceApproval(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	data := devicePageData{UserCode: r.PostFormValue("user_code")}
+	code, err := h.Service.LookupUserCode(r.Context(), data.UserCode)
+	if err != nil {
+		data.Error = "This code is not valid anymore, check the code on your device"
+		renderDevicePage(w, http.StatusBadRequest, data)
+		return
+	}
+	data.ClientID = code.ClientID
+	data.Scope = code.Scope
+
+	usr, err := h.Service.Login(r.Context(), r.PostFormValue("email"), r.PostFormValue("password"))
+	if err != nil {
+		log.WithError(err).Error("invalid login or password")
+		data.Error = "Invalid login or password"
+		renderDevicePage(w, http.StatusUnauthorized, data)
+		return
+	}
+
+	if r.PostFormValue("action") == "approve" {
+		err = h.Service.ApproveDevice(r.Context(), data.UserCode, usr)
+		data.Message = "Your device is connected, you can go back to it now."
+	} else {
+		err = h.Service.DenyDevice(r.Context(), data.UserCode, usr)
+		data.Message = "The device was not connected."
+	}
+	if err != nil && errors.Is(err, user.ErrorUserCodeNotFound) {
+		data.Message = ""
+		data.Error = "This code is not valid anymore, check the code on your device"
+		renderDevicePage(w, http.StatusBadRequest, data)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error deciding device authorization")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	renderDevicePage(w, http.StatusOK, data)
+}
+
+func renderDevicePage(w http.ResponseWriter, status int, data devicePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := devicePage.Execute(w, data); err != nil {
+		log.Errorf("Error rendering device page: %v", err)
+	}
+}
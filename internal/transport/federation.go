@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"auth/internal/user"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	chi "github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// federatedStateCookie binds a federated sign in to the browser that started it
+const federatedStateCookie = "federated_state"
+
+// FederatedLogin godoc
+// @Summary Sign in with an upstream identity provider
+// @Description Redirect to the OpenID Connect provider. With the /oauth/authorize parameters the authorization request is resumed after the sign in, without them the callback answers with tokens
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Param client_id query string false "Client ID of the authorization request to resume"
+// @Success 302
+// @Router /auth/federated/{provider}/login [get]
+func (h *Handler) FederatedLogin(w http.ResponseWriter, r *http.Request) {
+	var req user.AuthorizationRequest
+	if r.URL.Query().Get("client_id") != "" {
+		var ok bool
+		if req, ok = h.authorizationRequest(w, r); !ok {
+			return
+		}
+	}
+	target, state, err := h.Service.StartFederatedLogin(r.Context(), chi.URLParam(r, "provider"), req)
+	if err != nil && errors.Is(err, user.ErrorUnknownProvider) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error starting federated login")
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     federatedStateCookie,
+		Value:    state,
+		Path:     "/auth/federated/",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.Service.Discovery().Issuer, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// FederatedCallback godoc
+// @Summary Callback of an upstream identity provider
+// @Description Finish the sign in at the provider, the user is created on their first sign in. Resumes the authorization request or answers with tokens
+// @Tags auth
+// @Produce  json
+// @Param provider path string true "Provider name"
+// @Param state query string true "State sent to the provider"
+// @Param code query string true "Authorization code of the provider"
+// @Success 200 {object} LoginResponse
+// @Success 302
+// @Router /auth/federated/{provider}/callback [get]
+func (h *Handler) FederatedCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if upstreamError := query.Get("error"); upstreamError != "" {
+		log.Errorf("identity provider returned %s: %s", upstreamError, query.Get("error_description"))
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("sign in at the identity provider failed"))
+		return
+	}
+	state := query.Get("state")
+	cookie, err := r.Cookie(federatedStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("sign in was not started from this browser"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: federatedStateCookie, Path: "/auth/federated/", MaxAge: -1})
+
+	usr, req, err := h.Service.CompleteFederatedLogin(r.Context(), chi.URLParam(r, "provider"), state, query.Get("code"))
+	if err != nil {
+		log.WithError(err).Error("error completing federated login")
+		writeFederationError(w, err)
+		return
+	}
+
+	h.completeSignIn(w, r, usr, req)
+}
+
+// completeSignIn - resumes the authorization request an upstream sign in
+// started from, or answers with tokens when the user signed in directly
+func (h *Handler) completeSignIn(w http.ResponseWriter, r *http.Request, usr user.User, req user.AuthorizationRequest) {
+	if req.ClientID != "" {
+		h.authorizeOrConsent(w, r, req, usr)
+		return
+	}
+
+	accessToken, err := h.Service.GenerateToken(r.Context(), usr, "")
+	if err != nil {
+		log.WithError(err).Error("error generating token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	refreshToken, err := h.Service.GenerateRefreshToken(r.Context(), usr, "")
+	if err != nil {
+		log.WithError(err).Error("error generating refresh token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    user.TokenTypeBearer,
+		User:         usr,
+	}); err != nil {
+		log.Errorf("Error encoding login response: %v", err)
+	}
+}
+
+func writeFederationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, user.ErrorFederatedLoginState),
+		errors.Is(err, user.ErrorInvalidIDToken):
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+	case errors.Is(err, user.ErrorInvalidSAMLResponse):
+		// the details of a rejected assertion are only logged
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(user.ErrorInvalidSAMLResponse.Error()))
+	case errors.Is(err, user.ErrorEmailDomainNotAllowed),
+		errors.Is(err, user.ErrorEmailNotVerified),
+		errors.Is(err, user.ErrorFederatedEmailExists):
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+	case errors.Is(err, user.ErrorUnknownProvider):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, user.ErrorUpstreamRequest):
+		w.WriteHeader(http.StatusBadGateway)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
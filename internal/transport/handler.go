@@ -17,6 +17,11 @@ type Handler struct {
 	Router  *chi.Mux
 	Service UserService
 	Server  *http.Server
+	// AdminKey guards the admin endpoints, they are disabled when it is empty
+	AdminKey string
+	// InitialAccessToken guards client registration, it is disabled when
+	// the token is empty
+	InitialAccessToken string
 }
 
 type Response struct {
@@ -25,15 +30,17 @@ type Response struct {
 
 func NewHandler(service UserService) *Handler {
 	h := &Handler{
-		Service: service,
-		Router:  chi.NewRouter(),
+		Service:            service,
+		Router:             chi.NewRouter(),
+		AdminKey:           os.Getenv("ADMIN_API_KEY"),
+		InitialAccessToken: os.Getenv("INITIAL_ACCESS_TOKEN"),
 	}
 
 	// Configure CORS
 	cors := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"}, // Change "*" to the appropriate origin URL(s)
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "DPoP"},
 		AllowCredentials: true,
 	})
 	h.Router.Use(cors.Handler)
@@ -55,9 +62,40 @@ func (h *Handler) mapRoutes() {
 	h.Router.Get("/auth/{id}", h.GetUser)
 	h.Router.Post("/auth/register", h.RegisterUser)
 	h.Router.Put("/auth/{id}", h.UpdateUser)
+	h.Router.Post("/auth/password", h.ChangePassword)
+	h.Router.Post("/auth/password/forgot", h.ForgotPassword)
+	h.Router.Post("/auth/password/reset", h.ResetPassword)
 	h.Router.Delete("/auth/{id}", h.DeleteUser)
 	h.Router.Post("/auth/login", h.LoginUser)
-	h.Router.Get("/auth/refresh/{refreshToken}", h.RefreshToken)
+	h.Router.Post("/auth/refresh", h.RefreshToken)
+	h.Router.Post("/auth/logout", h.Logout)
+	h.Router.Get("/auth/tokeninfo", h.TokenInfo)
+	h.Router.With(h.adminOnly).Post("/auth/revoke", h.RevokeToken)
+	h.Router.Get("/oauth/authorize", h.Authorize)
+	h.Router.Post("/oauth/authorize", h.AuthorizeLogin)
+	h.Router.Post("/oauth/consent", h.ConsentDecision)
+	h.Router.Get("/auth/consents", h.ListConsents)
+	h.Router.Delete("/auth/consents/{client_id}", h.RevokeConsent)
+	h.Router.Post("/oauth/token", h.Token)
+	h.Router.Post("/oauth/device_authorization", h.DeviceAuthorization)
+	h.Router.Get("/oauth/device", h.Device)
+	h.Router.Post("/oauth/device", h.DeviceApproval)
+	h.Router.Get("/auth/federated/{provider}/login", h.FederatedLogin)
+	h.Router.Get("/auth/federated/{provider}/callback", h.FederatedCallback)
+	h.Router.Get("/auth/saml/{provider}/metadata", h.SAMLMetadata)
+	h.Router.Get("/auth/saml/{provider}/login", h.SAMLLogin)
+	h.Router.Post("/auth/saml/{provider}/acs", h.SAMLAssertionConsumer)
+	h.Router.Post("/oauth/introspect", h.Introspect)
+	h.Router.With(h.adminOnly).Post("/auth/clients/{id}/secret", h.RotateClientSecret)
+	h.Router.With(h.initialAccessTokenOnly).Post("/oauth/register", h.RegisterClient)
+	h.Router.Get("/oauth/register/{id}", h.GetClientRegistration)
+	h.Router.Put("/oauth/register/{id}", h.UpdateClientRegistration)
+	h.Router.Delete("/oauth/register/{id}", h.DeleteClientRegistration)
+	h.Router.Get("/.well-known/jwks.json", h.JWKS)
+	h.Router.Get("/.well-known/openid-configuration", h.OpenIDConfiguration)
+	h.Router.Get("/userinfo", h.UserInfo)
+	h.Router.Post("/userinfo", h.UserInfo)
+	h.Router.With(h.adminOnly).Post("/auth/keys/rotate", h.RotateKeys)
 	h.Router.Get("/swagger.json", h.ServeSwagger) // added this line
 	/*h.Router.Get("/swagger/*", httpSwagger.Handler(
 	httpSwagger.URL("http://localhost:8080/swagger/doc.json")))*/
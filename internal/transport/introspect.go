@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"auth/internal/user"
+	"net/http"
+)
+
+// Introspect godoc
+// @Summary Introspect a token
+// @Description RFC 7662 token introspection, callers authenticate with their client credentials
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Produce  json
+// @Param token formData string true "Token to introspect"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200 {object} IntrospectionResponse
+// @Failure 401 {object} OAuthError
+// @Router /oauth/introspect [post]
+func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "could not parse form")
+		return
+	}
+	clientID, secret, ok := clientCredentials(r)
+	if !ok || h.Service.AuthenticateIntrospectionClient(r.Context(), clientID, secret) != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	// token_type_hint is only an optimisation hint, both kinds are easy to tell apart
+	if claims, err := h.validateToken(r.Context(), token); err == nil {
+		writeJSON(w, http.StatusOK, convertClaimsToIntrospectionResponse(claims))
+		return
+	}
+	if stored, err := h.Service.LookupRefreshToken(r.Context(), token); err == nil {
+		writeJSON(w, http.StatusOK, IntrospectionResponse{
+			Active:    true,
+			TokenType: "refresh_token",
+			Sub:       stored.UserID,
+			Exp:       stored.ExpiresAt.Unix(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, IntrospectionResponse{Active: false})
+}
+
+func convertClaimsToIntrospectionResponse(claims map[string]interface{}) IntrospectionResponse {
+	stringClaim := func(name string) string {
+		v, _ := claims[name].(string)
+		return v
+	}
+	timeClaim := func(name string) int64 {
+		if v, ok := claims[name].(float64); ok {
+			return int64(v)
+		}
+		return 0
+	}
+	return IntrospectionResponse{
+		Active:    true,
+		Scope:     stringClaim("scope"),
+		ClientID:  stringClaim("client_id"),
+		Username:  stringClaim("email"),
+		TokenType: user.TokenType(user.ConfirmationThumbprint(claims)),
+		Exp:       timeClaim("exp"),
+		Iat:       timeClaim("iat"),
+		Nbf:       timeClaim("nbf"),
+		Sub:       stringClaim("sub"),
+		Aud:       claims["aud"],
+		Iss:       stringClaim("iss"),
+		Jti:       stringClaim("jti"),
+		Cnf:       claims["cnf"],
+		Act:       claims["act"],
+	}
+}
@@ -0,0 +1,45 @@
+package transport
+
+import (
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// JWKS godoc
+// @Summary Get the token signing keys
+// @Description Get the public keys other services can use to verify our tokens
+// @Tags auth
+// @Produce  json
+// @Success 200 {object} user.JWKS
+// @Router /.well-known/jwks.json [get]
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(h.Service.JWKS()); err != nil {
+		log.Errorf("Error encoding jwks: %v", err)
+	}
+}
+
+// RotateKeys godoc
+// @Summary Rotate the token signing key
+// @Description Generate a new signing key, the previous key keeps verifying tokens during the grace period
+// @Tags auth
+// @Produce  json
+// @Security AdminKey
+// @Success 200 {object} user.JWK
+// @Router /auth/keys/rotate [post]
+func (h *Handler) RotateKeys(w http.ResponseWriter, r *http.Request) {
+	jwk, err := h.Service.RotateSigningKey(r.Context())
+	if err != nil {
+		log.WithError(err).Error("error rotating signing key")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(jwk); err != nil {
+		log.Errorf("Error encoding jwk: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// adminOnly - only lets requests through that carry the admin API key as a bearer token
+func (h *Handler) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.AdminKey == "" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("admin endpoints are disabled"))
+			return
+		}
+		key := bearerToken(r)
+		if subtle.ConstantTimeCompare([]byte(key), []byte(h.AdminKey)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid admin key"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// initialAccessTokenOnly - only lets client registrations through that carry
+// the initial access token as a bearer token
+func (h *Handler) initialAccessTokenOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.InitialAccessToken == "" {
+			writeOAuthError(w, http.StatusForbidden, "access_denied", "client registration is disabled")
+			return
+		}
+		token := bearerToken(r)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.InitialAccessToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeJSON(w, http.StatusUnauthorized, OAuthError{Error: "invalid_token", ErrorDescription: "invalid initial access token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken - extracts the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	scheme, token := authorizationToken(r)
+	if !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return token
+}
+
+// authorizationToken - splits the Authorization header into its scheme and token
+func authorizationToken(r *http.Request) (string, string) {
+	header := r.Header.Get("Authorization")
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+// authenticate - validates the access token of the request, DPoP bound
+// tokens must come with a proof for this request
+func (h *Handler) authenticate(r *http.Request) (map[string]interface{}, error) {
+	scheme, token := authorizationToken(r)
+	return h.Service.ValidateBoundToken(r.Context(), scheme, token, r.Header.Get("DPoP"), r.Method, r.URL.Path)
+}
+
+// dpopThumbprint - verifies the DPoP proof of a token request and returns
+// the thumbprint of its key, requests without a proof get bearer tokens
+func (h *Handler) dpopThumbprint(r *http.Request) (string, error) {
+	proof := r.Header.Get("DPoP")
+	if proof == "" {
+		return "", nil
+	}
+	return h.Service.ValidateDPoPProof(r.Context(), proof, r.Method, r.URL.Path, "")
+}
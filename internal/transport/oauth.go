@@ -0,0 +1,200 @@
+package transport
+
+import (
+	"auth/internal/user"
+	"encoding/json"
+	"errors"
+	chi "github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// OAuthError - error response body defined by RFC 6749 section 5.2
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code string, description string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+	}
+	writeJSON(w, status, OAuthError{Error: code, ErrorDescription: description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Errorf("Error encoding response: %v", err)
+	}
+}
+
+// clientCredentials - reads the client id and secret from HTTP basic auth or,
+// as RFC 6749 allows, from the form body
+func clientCredentials(r *http.Request) (string, string, bool) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		return id, secret, true
+	}
+	id := r.PostFormValue("client_id")
+	secret := r.PostFormValue("client_secret")
+	return id, secret, id != ""
+}
+
+// Token godoc
+// @Summary OAuth 2.0 token endpoint
+// @Description Redeem an authorization code, with its PKCE code verifier, or a refresh token for tokens.
+// @Description Confidential clients authenticate with HTTP basic auth or client_secret and may use the client_credentials grant.
+// @Tags oauth
+// @Accept  x-www-form-urlencoded
+// @Produce  json
+// @Param grant_type formData string true "authorization_code, refresh_token, client_credentials, urn:ietf:params:oauth:grant-type:device_code or urn:ietf:params:oauth:grant-type:token-exchange"
+// @Param client_id formData string true "Client ID"
+// @Param client_secret formData string false "Client secret of confidential clients"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI used to get the code"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Param scope formData string false "Scope requested with client_credentials"
+// @Param device_code formData string false "Device code being polled for"
+// @Param subject_token formData string false "User access token issued to or addressed to the client"
+// @Param subject_token_type formData string false "urn:ietf:params:oauth:token-type:access_token"
+// @Param actor_token formData string false "Access token of the party acting for the subject"
+// @Param actor_token_type formData string false "urn:ietf:params:oauth:token-type:access_token"
+// @Param audience formData string false "Audience of the exchanged token"
+// @Param requested_token_type formData string false "urn:ietf:params:oauth:token-type:access_token"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} OAuthError
+// @Failure 401 {object} OAuthError
+// @Router /oauth/token [post]
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "could not parse form")
+		return
+	}
+	jkt, err := h.dpopThumbprint(r)
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_dpop_proof", err.Error())
+		return
+	}
+	clientID, secret, _ := clientCredentials(r)
+	if clientID == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "client_id is required")
+		return
+	}
+	client, err := h.Service.AuthenticateClient(r.Context(), clientID, secret)
+	if err != nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	var tokens user.TokenSet
+	switch grantType := r.PostFormValue("grant_type"); grantType {
+	case user.GrantTypeAuthorizationCode:
+		tokens, err = h.Service.ExchangeAuthorizationCode(r.Context(), client.ID, r.PostFormValue("code"),
+			r.PostFormValue("redirect_uri"), r.PostFormValue("code_verifier"), jkt)
+	case user.GrantTypeRefreshToken:
+		tokens, err = h.Service.RefreshTokenGrant(r.Context(), r.PostFormValue("refresh_token"), client.ID, jkt)
+	case user.GrantTypeClientCredentials:
+		tokens, err = h.Service.ClientCredentialsGrant(r.Context(), client, r.PostFormValue("scope"), jkt)
+	case user.GrantTypeDeviceCode:
+		tokens, err = h.Service.DeviceCodeGrant(r.Context(), client, r.PostFormValue("device_code"), jkt)
+	case user.GrantTypeTokenExchange:
+		tokens, err = h.Service.TokenExchangeGrant(r.Context(), client, user.TokenExchangeRequest{
+			SubjectToken:       r.PostFormValue("subject_token"),
+			SubjectTokenType:   r.PostFormValue("subject_token_type"),
+			ActorToken:         r.PostFormValue("actor_token"),
+			ActorTokenType:     r.PostFormValue("actor_token_type"),
+			Audience:           r.PostFormValue("audience"),
+			Scope:              r.PostFormValue("scope"),
+			RequestedTokenType: r.PostFormValue("requested_token_type"),
+		}, jkt)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", grantType)
+		return
+	}
+	if err != nil && (errors.Is(err, user.ErrorAuthorizationPending) || errors.Is(err, user.ErrorSlowDown)) {
+		// devices are expected to poll, this is not worth an error log
+		writeTokenError(w, err)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error issuing tokens")
+		writeTokenError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, convertTokenSetToTokenResponse(tokens))
+}
+
+// writeTokenError - maps service errors to RFC 6749 token endpoint errors
+func writeTokenError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, user.ErrorInvalidGrant),
+		errors.Is(err, user.ErrorInvalidCodeVerifier),
+		errors.Is(err, user.ErrorAuthorizationCodeUsed),
+		errors.Is(err, user.ErrorInvalidRefreshToken),
+		errors.Is(err, user.ErrorRefreshTokenReused),
+		errors.Is(err, user.ErrorWrongTokenType):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", err.Error())
+	case errors.Is(err, user.ErrorDPoPBindingMismatch):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_dpop_proof", err.Error())
+	case errors.Is(err, user.ErrorInvalidScope):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+	case errors.Is(err, user.ErrorUnauthorizedClient):
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", err.Error())
+	case errors.Is(err, user.ErrorAuthorizationPending):
+		writeOAuthError(w, http.StatusBadRequest, "authorization_pending", err.Error())
+	case errors.Is(err, user.ErrorSlowDown):
+		writeOAuthError(w, http.StatusBadRequest, "slow_down", err.Error())
+	case errors.Is(err, user.ErrorExpiredToken):
+		writeOAuthError(w, http.StatusBadRequest, "expired_token", err.Error())
+	case errors.Is(err, user.ErrorAccessDenied):
+		writeOAuthError(w, http.StatusBadRequest, "access_denied", err.Error())
+	case errors.Is(err, user.ErrorInvalidSubjectToken),
+		errors.Is(err, user.ErrorInvalidActorToken),
+		errors.Is(err, user.ErrorUnsupportedTokenType):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+	case errors.Is(err, user.ErrorInvalidTarget):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_target", err.Error())
+	default:
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+	}
+}
+
+func convertTokenSetToTokenResponse(tokens user.TokenSet) TokenResponse {
+	return TokenResponse{
+		AccessToken:     tokens.AccessToken,
+		TokenType:       tokens.TokenType,
+		ExpiresIn:       tokens.ExpiresIn,
+		RefreshToken:    tokens.RefreshToken,
+		Scope:           tokens.Scope,
+		IDToken:         tokens.IDToken,
+		IssuedTokenType: tokens.IssuedTokenType,
+	}
+}
+
+// RotateClientSecret godoc
+// @Summary Issue a new client secret
+// @Description Generate a new secret for a client, the secret is only shown once and the previous one stops working
+// @Tags oauth
+// @Produce  json
+// @Security AdminKey
+// @Param id path string true "Client ID"
+// @Success 200 {object} ClientSecretResponse
+// @Router /auth/clients/{id}/secret [post]
+func (h *Handler) RotateClientSecret(w http.ResponseWriter, r *http.Request) {
+	clientID := chi.URLParam(r, "id")
+	secret, err := h.Service.RotateClientSecret(r.Context(), clientID)
+	if err != nil && errors.Is(err, user.ErrorClientNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("client not found"))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error rotating client secret")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, ClientSecretResponse{ClientID: clientID, ClientSecret: secret})
+}
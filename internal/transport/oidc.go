@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"auth/internal/user"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// OpenIDConfiguration godoc
+// @Summary OpenID Connect discovery
+// @Description Provider metadata OpenID Connect clients configure themselves with
+// @Tags oidc
+// @Produce  json
+// @Success 200 {object} user.ProviderMetadata
+// @Router /.well-known/openid-configuration [get]
+func (h *Handler) OpenIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(h.Service.Discovery()); err != nil {
+		log.Errorf("Error encoding provider metadata: %v", err)
+	}
+}
+
+// UserInfo godoc
+// @Summary OpenID Connect userinfo
+// @Description Claims about the user behind an access token issued with the openid scope
+// @Tags oidc
+// @Produce  json
+// @Security BearerToken
+// @Success 200 {object} map[string]interface{}
+// @Failure 401
+// @Failure 403
+// @Router /userinfo [get]
+func (h *Handler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.authenticate(r)
+	if err != nil {
+		log.WithError(err).Error("error validating userinfo token")
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	info, err := h.Service.UserInfo(r.Context(), claims)
+	if err != nil && errors.Is(err, user.ErrorInsufficientScope) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error getting userinfo")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
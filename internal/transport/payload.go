@@ -5,6 +5,7 @@ import "auth/internal/user"
 type RegisterResponse struct {
 	AccessToken  string    `json:"authToken"`
 	RefreshToken string    `json:"refreshToken"`
+	TokenType    string    `json:"tokenType"`
 	User         user.User `json:"user"`
 }
 
@@ -12,6 +13,101 @@ type LoginResponse struct {
 	User         user.User `json:"user"`
 	AccessToken  string    `json:"authToken"`
 	RefreshToken string    `json:"refreshToken"`
+	TokenType    string    `json:"tokenType"`
+}
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+type RefreshResponse struct {
+	AccessToken  string `json:"authToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+}
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+type RevokeRequest struct {
+	Token string `json:"token"`
+	JTI   string `json:"jti"`
+}
+
+// TokenResponse - RFC 6749 section 5.1 access token response
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+// DeviceAuthorizationResponse - RFC 8628 section 3.2 device authorization response
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// ClientMetadataRequest - RFC 7591 client metadata
+type ClientMetadataRequest struct {
+	ClientName              string   `json:"client_name,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+}
+
+// ClientInformationResponse - RFC 7591 client information response, the
+// secret and registration access token are only present when issued
+type ClientInformationResponse struct {
+	ClientID                string   `json:"client_id"`
+	ClientSecret            string   `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64    `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   *int64   `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string   `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string   `json:"registration_client_uri"`
+	ClientName              string   `json:"client_name,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	Scope                   string   `json:"scope"`
+}
+
+// ConsentResponse - scopes the user shared with a client
+type ConsentResponse struct {
+	ClientID   string `json:"client_id"`
+	ClientName string `json:"client_name"`
+	Scope      string `json:"scope"`
+	CreatedAt  int64  `json:"created_at"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+// ClientSecretResponse - a freshly generated client secret
+type ClientSecretResponse struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// IntrospectionResponse - RFC 7662 token introspection response
+type IntrospectionResponse struct {
+	Active    bool        `json:"active"`
+	Scope     string      `json:"scope,omitempty"`
+	ClientID  string      `json:"client_id,omitempty"`
+	Username  string      `json:"username,omitempty"`
+	TokenType string      `json:"token_type,omitempty"`
+	Exp       int64       `json:"exp,omitempty"`
+	Iat       int64       `json:"iat,omitempty"`
+	Nbf       int64       `json:"nbf,omitempty"`
+	Sub       string      `json:"sub,omitempty"`
+	Aud       interface{} `json:"aud,omitempty"`
+	Iss       string      `json:"iss,omitempty"`
+	Jti       string      `json:"jti,omitempty"`
+	Cnf       interface{} `json:"cnf,omitempty"`
+	Act       interface{} `json:"act,omitempty"`
 }
 type LoginRequest struct {
 	Email    string `json:"email"`
@@ -21,6 +117,23 @@ type RegisterRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// PasswordPolicyErrorResponse - the rules a rejected password broke
+type PasswordPolicyErrorResponse struct {
+	Error      string                   `json:"error"`
+	Violations []user.PasswordViolation `json:"violations"`
+}
 
 func convertRegisterRequestToUser(r RegisterRequest) user.User {
 	return user.User{
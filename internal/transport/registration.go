@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"auth/internal/user"
+	"encoding/json"
+	"errors"
+	chi "github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// RegisterClient godoc
+// @Summary Register an OAuth client
+// @Description RFC 7591 dynamic client registration, the response holds the client secret and registration access token which are not shown again
+// @Tags oauth
+// @Accept  json
+// @Produce  json
+// @Security BearerToken
+// @Param client body ClientMetadataRequest true "Client metadata"
+// @Success 201 {object} ClientInformationResponse
+// @Failure 400 {object} OAuthError
+// @Router /oauth/register [post]
+func (h *Handler) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	var cr ClientMetadataRequest
+	if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client_metadata", "could not decode client metadata")
+		return
+	}
+	registration, err := h.Service.RegisterClient(r.Context(), convertClientMetadataRequestToClientMetadata(cr))
+	if err != nil {
+		writeRegistrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, h.clientInformationResponse(registration))
+}
+
+// GetClientRegistration godoc
+// @Summary Read a client registration
+// @Description RFC 7592 client configuration endpoint, authenticated with the registration access token
+// @Tags oauth
+// @Produce  json
+// @Security BearerToken
+// @Param id path string true "Client ID"
+// @Success 200 {object} ClientInformationResponse
+// @Failure 401
+// @Router /oauth/register/{id} [get]
+func (h *Handler) GetClientRegistration(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.registeredClient(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.clientInformationResponse(user.ClientRegistration{Client: client}))
+}
+
+// UpdateClientRegistration godoc
+// @Summary Update a client registration
+// @Description RFC 7592 client update, the metadata replaces the registered metadata
+// @Tags oauth
+// @Accept  json
+// @Produce  json
+// @Security BearerToken
+// @Param id path string true "Client ID"
+// @Param client body ClientMetadataRequest true "Client metadata"
+// @Success 200 {object} ClientInformationResponse
+// @Failure 400 {object} OAuthError
+// @Failure 401
+// @Router /oauth/register/{id} [put]
+func (h *Handler) UpdateClientRegistration(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.registeredClient(w, r)
+	if !ok {
+		return
+	}
+	var cr ClientMetadataRequest
+	if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client_metadata", "could not decode client metadata")
+		return
+	}
+	registration, err := h.Service.UpdateClientRegistration(r.Context(), client, convertClientMetadataRequestToClientMetadata(cr))
+	if err != nil {
+		writeRegistrationError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.clientInformationResponse(registration))
+}
+
+// DeleteClientRegistration godoc
+// @Summary Delete a client registration
+// @Description RFC 7592 client delete, the client and its registration access token stop working
+// @Tags oauth
+// @Security BearerToken
+// @Param id path string true "Client ID"
+// @Success 204
+// @Failure 401
+// @Router /oauth/register/{id} [delete]
+func (h *Handler) DeleteClientRegistration(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.registeredClient(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Service.DeleteClientRegistration(r.Context(), client); err != nil {
+		log.WithError(err).Error("error deleting client")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// registeredClient - authenticates the registration access token of the
+// request, writing the error response when it is not valid
+func (h *Handler) registeredClient(w http.ResponseWriter, r *http.Request) (user.Client, bool) {
+	client, err := h.Service.AuthenticateRegistration(r.Context(), chi.URLParam(r, "id"), bearerToken(r))
+	if err != nil {
+		// RFC 7592 answers an unknown client the same way as a bad token
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return user.Client{}, false
+	}
+	return client, true
+}
+
+func writeRegistrationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, user.ErrorInvalidRedirectURIs):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_redirect_uri", err.Error())
+	case errors.Is(err, user.ErrorInvalidClientMetadata):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client_metadata", err.Error())
+	default:
+		log.WithError(err).Error("error registering client")
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "")
+	}
+}
+
+func convertClientMetadataRequestToClientMetadata(cr ClientMetadataRequest) user.ClientMetadata {
+	return user.ClientMetadata{
+		ClientName:              cr.ClientName,
+		RedirectURIs:            cr.RedirectURIs,
+		GrantTypes:              cr.GrantTypes,
+		TokenEndpointAuthMethod: cr.TokenEndpointAuthMethod,
+		Scopes:                  strings.Fields(cr.Scope),
+	}
+}
+
+func (h *Handler) clientInformationResponse(registration user.ClientRegistration) ClientInformationResponse {
+	client := registration.Client
+	response := ClientInformationResponse{
+		ClientID:                client.ID,
+		ClientSecret:            registration.ClientSecret,
+		ClientIDIssuedAt:        client.CreatedAt.Unix(),
+		RegistrationAccessToken: registration.RegistrationAccessToken,
+		RegistrationClientURI:   h.Service.Discovery().RegistrationEndpoint + "/" + client.ID,
+		ClientName:              client.Name,
+		RedirectURIs:            client.RedirectURIs,
+		GrantTypes:              client.GrantTypes,
+		TokenEndpointAuthMethod: client.TokenEndpointAuthMethod,
+		Scope:                   strings.Join(client.Scopes, " "),
+	}
+	if registration.ClientSecret != "" {
+		// secrets do not expire
+		var never int64
+		response.ClientSecretExpiresAt = &never
+	}
+	return response
+}
@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"auth/internal/user"
+	"errors"
+	chi "github.com/go-chi/chi/v5"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// SAMLMetadata godoc
+// @Summary SAML service provider metadata
+// @Description Metadata to register this service with the SAML identity provider
+// @Tags auth
+// @Produce  xml
+// @Param provider path string true "Provider name"
+// @Success 200
+// @Router /auth/saml/{provider}/metadata [get]
+func (h *Handler) SAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	metadata, err := h.Service.SAMLMetadata(chi.URLParam(r, "provider"))
+	if err != nil {
+		log.WithError(err).Error("error building saml metadata")
+		writeFederationError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(metadata)
+}
+
+// SAMLLogin godoc
+// @Summary Sign in with a SAML identity provider
+// @Description Redirect to the SAML identity provider. With the /oauth/authorize parameters the authorization request is resumed after the sign in, without them the assertion consumer answers with tokens
+// @Tags auth
+// @Param provider path string true "Provider name"
+// @Param client_id query string false "Client ID of the authorization request to resume"
+// @Success 302
+// @Router /auth/saml/{provider}/login [get]
+func (h *Handler) SAMLLogin(w http.ResponseWriter, r *http.Request) {
+	var req user.AuthorizationRequest
+	if r.URL.Query().Get("client_id") != "" {
+		var ok bool
+		if req, ok = h.authorizationRequest(w, r); !ok {
+			return
+		}
+	}
+	target, err := h.Service.StartSAMLLogin(r.Context(), chi.URLParam(r, "provider"), req)
+	if err != nil && errors.Is(err, user.ErrorUnknownProvider) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error starting saml login")
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// SAMLAssertionConsumer godoc
+// @Summary SAML assertion consumer service
+// @Description Receives the signed assertion the identity provider posts with the HTTP-POST binding, the user is created on their first sign in. Resumes the authorization request or answers with tokens
+// @Tags auth
+// @Accept  x-www-form-urlencoded
+// @Produce  json
+// @Param provider path string true "Provider name"
+// @Param SAMLResponse formData string true "Base64 encoded SAML response"
+// @Param RelayState formData string true "Relay state sent to the identity provider"
+// @Success 200 {object} LoginResponse
+// @Success 302
+// @Router /auth/saml/{provider}/acs [post]
+func (h *Handler) SAMLAssertionConsumer(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	usr, req, err := h.Service.CompleteSAMLLogin(r.Context(), chi.URLParam(r, "provider"), r.PostFormValue("SAMLResponse"), r.PostFormValue("RelayState"))
+	if err != nil {
+		log.WithError(err).Error("error completing saml login")
+		writeFederationError(w, err)
+		return
+	}
+	h.completeSignIn(w, r, usr, req)
+}
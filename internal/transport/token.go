@@ -1,77 +1,161 @@
 package transport
 
 import (
+	"auth/internal/user"
+	"context"
 	"encoding/json"
 	"errors"
-	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 )
 
 // RefreshToken godoc
-// @Summary Get refresh token for user
-// @Description Get refresh token for user
-// @Tags users
+// @Summary Exchange a refresh token for a new token pair
+// @Description Rotate a refresh token, presenting an already used token revokes every token of its family
+// @Tags auth
 // @Accept  json
 // @Produce  json
-// @Param refreshToken path string true "Refresh token"
-// @Success 200 {object} string
-// @Router /auth/refresh/{refreshToken} [get]
+// @Param refreshToken body RefreshRequest true "Refresh token"
+// @Success 200 {object} RefreshResponse
+// @Router /auth/refresh [post]
 func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
-	refreshToken := chi.URLParam(r, "refreshToken")
-	token, err := validateToken(refreshToken)
-	if err != nil {
-		log.WithError(err).Error("error validating refresh token")
+	var rr RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&rr); err != nil || rr.RefreshToken == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("invalid refresh token"))
 		return
 	}
 
-	userID, ok := token["sub"].(string)
-	if !ok {
-		log.WithError(err).Error("error validating refresh token")
+	jkt, err := h.dpopThumbprint(r)
+	if err != nil {
+		log.WithError(err).Error("invalid dpop proof")
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("invalid refresh token"))
+		w.Write([]byte("invalid dpop proof"))
 		return
 	}
 
-	usr, err := h.Service.GetUser(r.Context(), userID)
-	if err != nil {
-		log.WithError(err).Error("error getting user")
+	tokens, err := h.Service.RefreshTokenGrant(r.Context(), rr.RefreshToken, "", jkt)
+	if err != nil && errors.Is(err, user.ErrorWrongTokenType) {
+		log.WithError(err).Error("error validating refresh token")
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("user not found"))
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil && (errors.Is(err, user.ErrorInvalidRefreshToken) || errors.Is(err, user.ErrorRefreshTokenReused) || errors.Is(err, user.ErrorDPoPBindingMismatch)) {
+		log.WithError(err).Error("error validating refresh token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid refresh token"))
 		return
 	}
-	accessToken, err := h.Service.GenerateToken(usr)
 	if err != nil {
+		log.WithError(err).Error("error rotating refresh token")
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("error generating refresh token"))
 		return
 	}
+
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(map[string]string{"accessToken": accessToken}); err != nil {
+	if err := json.NewEncoder(w).Encode(RefreshResponse{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    tokens.TokenType,
+	}); err != nil {
 		log.Errorf("Error getting profile: %v", err)
 	}
 }
 
-// validateToken - validates an incoming JWT token
-func validateToken(accessToken string) (map[string]interface{}, error) {
-	var mySigningKey = []byte("missionimpossible")
-	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("could not validate auth token")
+// Logout godoc
+// @Summary Log out a user
+// @Description Revoke the access token and, when given, the refresh token family
+// @Tags auth
+// @Accept  json
+// @Security BearerToken
+// @Param logout body LogoutRequest false "Refresh token to revoke"
+// @Success 200
+// @Router /auth/logout [post]
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	var lr LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&lr); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
-		return mySigningKey, nil
-	})
+	}
+	_, accessToken := authorizationToken(r)
+	claims, err := h.authenticate(r)
+	if err != nil {
+		log.WithError(err).Error("error logging out")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid auth token"))
+		return
+	}
+	if user.IsClientToken(claims) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("client tokens have no session to log out of"))
+		return
+	}
+	if err := h.Service.Logout(r.Context(), accessToken, lr.RefreshToken); err != nil {
+		log.WithError(err).Error("error logging out")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid auth token"))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
 
+// RevokeToken godoc
+// @Summary Revoke an access token
+// @Description Add a token, or just its jti, to the denylist until the token expires
+// @Tags auth
+// @Accept  json
+// @Security AdminKey
+// @Param revoke body RevokeRequest true "Token or jti to revoke"
+// @Success 200
+// @Router /auth/revoke [post]
+func (h *Handler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	var rr RevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var err error
+	if rr.Token != "" {
+		err = h.Service.RevokeToken(r.Context(), rr.Token)
+	} else {
+		err = h.Service.RevokeTokenID(r.Context(), rr.JTI)
+	}
 	if err != nil {
-		return nil, err
+		log.WithError(err).Error("error revoking token")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("could not revoke token"))
+		return
 	}
+	w.WriteHeader(http.StatusOK)
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims, nil
+// TokenInfo godoc
+// @Summary Resolve an access token
+// @Description Return the claims of the bearer access token, works for signed and opaque tokens
+// @Tags auth
+// @Produce  json
+// @Security BearerToken
+// @Success 200 {object} map[string]interface{}
+// @Router /auth/tokeninfo [get]
+func (h *Handler) TokenInfo(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.authenticate(r)
+	if err != nil {
+		log.WithError(err).Error("error resolving access token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid auth token"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(claims); err != nil {
+		log.Errorf("Error encoding token info: %v", err)
 	}
+}
 
-	return nil, errors.New("could not validate auth token")
+// validateToken - validates an incoming JWT token
+func (h *Handler) validateToken(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	return h.Service.ValidateToken(ctx, accessToken)
 }
@@ -17,10 +17,55 @@ type UserService interface {
 	Login(ctx context.Context, email string, password string) (user.User, error)
 	PostUser(ctx context.Context, user user.User) (user.User, error)
 	UpdateUser(ctx context.Context, user user.User) (user.User, error)
+	ChangePassword(ctx context.Context, userID string, currentPassword string, newPassword string) error
+	ForgotPassword(email string)
+	ResetPassword(ctx context.Context, token string, newPassword string) error
 	DeleteUser(ctx context.Context, id string) error
 	ReadyCheck(ctx context.Context) error
-	GenerateToken(user user.User) (string, error)
-	GenerateRefreshToken(user user.User) (string, error)
+	GenerateToken(ctx context.Context, user user.User, jkt string) (string, error)
+	GenerateRefreshToken(ctx context.Context, user user.User, jkt string) (string, error)
+	RefreshTokenGrant(ctx context.Context, token string, clientID string, jkt string) (user.TokenSet, error)
+	ValidateAuthorizationRequest(ctx context.Context, req user.AuthorizationRequest) (user.AuthorizationRequest, error)
+	CheckAuthorizationRequest(ctx context.Context, req user.AuthorizationRequest) (user.AuthorizationRequest, error)
+	Authorize(ctx context.Context, req user.AuthorizationRequest, usr user.User) (string, error)
+	AuthenticateClient(ctx context.Context, clientID string, secret string) (user.Client, error)
+	ClientCredentialsGrant(ctx context.Context, client user.Client, scope string, jkt string) (user.TokenSet, error)
+	RotateClientSecret(ctx context.Context, clientID string) (string, error)
+	StartDeviceAuthorization(ctx context.Context, client user.Client, scope string) (user.DeviceAuthorization, error)
+	LookupUserCode(ctx context.Context, userCode string) (user.DeviceCode, error)
+	ApproveDevice(ctx context.Context, userCode string, usr user.User) error
+	DenyDevice(ctx context.Context, userCode string, usr user.User) error
+	DeviceCodeGrant(ctx context.Context, client user.Client, deviceCode string, jkt string) (user.TokenSet, error)
+	TokenExchangeGrant(ctx context.Context, client user.Client, req user.TokenExchangeRequest, jkt string) (user.TokenSet, error)
+	RegisterClient(ctx context.Context, metadata user.ClientMetadata) (user.ClientRegistration, error)
+	AuthenticateRegistration(ctx context.Context, clientID string, token string) (user.Client, error)
+	UpdateClientRegistration(ctx context.Context, client user.Client, metadata user.ClientMetadata) (user.ClientRegistration, error)
+	DeleteClientRegistration(ctx context.Context, client user.Client) error
+	FederatedProviders() []string
+	StartFederatedLogin(ctx context.Context, provider string, req user.AuthorizationRequest) (string, string, error)
+	CompleteFederatedLogin(ctx context.Context, provider string, state string, code string) (user.User, user.AuthorizationRequest, error)
+	SAMLProviders() []string
+	SAMLMetadata(provider string) ([]byte, error)
+	StartSAMLLogin(ctx context.Context, provider string, req user.AuthorizationRequest) (string, error)
+	CompleteSAMLLogin(ctx context.Context, provider string, samlResponse string, relayState string) (user.User, user.AuthorizationRequest, error)
+	PromptConsent(ctx context.Context, req user.AuthorizationRequest, usr user.User) (*user.ConsentPrompt, error)
+	DecideConsent(ctx context.Context, challenge string, approved bool) (user.User, user.AuthorizationRequest, error)
+	Consents(ctx context.Context, userID string) ([]user.Consent, error)
+	RevokeConsent(ctx context.Context, userID string, clientID string) error
+	ConsentOwner(ctx context.Context, claims map[string]interface{}) (string, error)
+	ExchangeAuthorizationCode(ctx context.Context, clientID string, code string, redirectURI string, codeVerifier string, jkt string) (user.TokenSet, error)
+	ValidateDPoPProof(ctx context.Context, proof string, method string, path string, accessToken string) (string, error)
+	ValidateBoundToken(ctx context.Context, scheme string, token string, proof string, method string, path string) (map[string]interface{}, error)
+	ValidateToken(ctx context.Context, token string) (map[string]interface{}, error)
+	RevokeToken(ctx context.Context, token string) error
+	RevokeTokenID(ctx context.Context, jti string) error
+	Logout(ctx context.Context, accessToken string, refreshToken string) error
+	AuthenticateIntrospectionClient(ctx context.Context, clientID string, secret string) error
+	LookupRefreshToken(ctx context.Context, token string) (user.RefreshToken, error)
+	JWKS() user.JWKS
+	Discovery() user.ProviderMetadata
+	UserInfo(ctx context.Context, claims map[string]interface{}) (map[string]interface{}, error)
+	RotateSigningKey(ctx context.Context) (user.JWK, error)
 }
 
 // GetUser godoc
@@ -53,6 +98,7 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 // @Produce  json
 // @Param user body RegisterRequest true "User info"
 // @Success 200 {object} RegisterResponse
+// @Failure 400 {object} PasswordPolicyErrorResponse
 // @Router /auth/register [post]
 func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var rr RegisterRequest
@@ -60,7 +106,17 @@ func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	jkt, err := h.dpopThumbprint(r)
+	if err != nil {
+		log.WithError(err).Error("invalid dpop proof")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid dpop proof"))
+		return
+	}
 	usr, err := h.Service.PostUser(r.Context(), convertRegisterRequestToUser(rr))
+	if writePasswordPolicyError(w, err) {
+		return
+	}
 	if err != nil && errors.Is(err, user.ErrorUserExists) {
 		log.WithError(err).Error("error creating user")
 		errMsg := "User already exists"
@@ -76,13 +132,13 @@ func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accessToken, err := h.Service.GenerateToken(usr)
+	accessToken, err := h.Service.GenerateToken(r.Context(), usr, jkt)
 	if err != nil {
 		log.WithError(err).Error("error creating user")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	refreshToken, err := h.Service.GenerateRefreshToken(usr)
+	refreshToken, err := h.Service.GenerateRefreshToken(r.Context(), usr, jkt)
 	if err != nil {
 		log.WithError(err).Error("error creating user")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -92,6 +148,7 @@ func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewEncoder(w).Encode(RegisterResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+		TokenType:    user.TokenType(jkt),
 		User:         usr,
 	}); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -105,7 +162,7 @@ func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 // @Tags users
 // @Accept  json
 // @Produce  json
-// @Param user body user.User true "Login user"
+// @Param user body LoginRequest true "Login user"
 // @Success 200 {object} LoginResponse
 // @Router /auth/login [post]
 func (h *Handler) LoginUser(w http.ResponseWriter, r *http.Request) {
@@ -115,21 +172,28 @@ func (h *Handler) LoginUser(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	jkt, err := h.dpopThumbprint(r)
+	if err != nil {
+		log.WithError(err).Error("invalid dpop proof")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid dpop proof"))
+		return
+	}
 	usr := convertLoginRequestToUser(lr)
-	usr, err := h.Service.Login(r.Context(), usr.Email, usr.Password)
+	usr, err = h.Service.Login(r.Context(), usr.Email, usr.Password)
 	if err != nil {
 		log.WithError(err).Error("invalid login or password")
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("invalid login or password"))
 		return
 	}
-	accessToken, err := h.Service.GenerateToken(usr)
+	accessToken, err := h.Service.GenerateToken(r.Context(), usr, jkt)
 	if err != nil {
 		log.WithError(err).Error("error generating token")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	refreshToken, err := h.Service.GenerateToken(usr)
+	refreshToken, err := h.Service.GenerateRefreshToken(r.Context(), usr, jkt)
 	if err != nil {
 		log.WithError(err).Error("error generating refresh token")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -139,6 +203,7 @@ func (h *Handler) LoginUser(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewEncoder(w).Encode(LoginResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+		TokenType:    user.TokenType(jkt),
 		User:         usr,
 	}); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -147,22 +212,139 @@ func (h *Handler) LoginUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	var user user.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	var usr user.User
+	if err := json.NewDecoder(r.Body).Decode(&usr); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	usr, err := h.Service.UpdateUser(r.Context(), usr)
+	if err != nil && errors.Is(err, user.ErrorUserExists) {
 		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("User already exists"))
 		return
 	}
-	user, err := h.Service.UpdateUser(r.Context(), user)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(user); err != nil {
+	if err := json.NewEncoder(w).Encode(usr); err != nil {
 		log.Errorf("Error getting profile: %v", err)
 	}
 }
 
+// ChangePassword godoc
+// @Summary Change password
+// @Description Replace the password of the signed in user, the new password has to pass the password policy
+// @Tags users
+// @Accept  json
+// @Security BearerToken
+// @Param request body ChangePasswordRequest true "Current and new password"
+// @Success 204
+// @Failure 400 {object} PasswordPolicyErrorResponse
+// @Failure 401
+// @Failure 403
+// @Router /auth/password [post]
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var cr ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	claims, err := h.authenticate(r)
+	if err != nil {
+		log.WithError(err).Error("error validating token")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid auth token"))
+		return
+	}
+	if user.IsClientToken(claims) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("client tokens have no password to change"))
+		return
+	}
+	sub, _ := claims["sub"].(string)
+	err = h.Service.ChangePassword(r.Context(), sub, cr.CurrentPassword, cr.NewPassword)
+	if writePasswordPolicyError(w, err) {
+		return
+	}
+	if err != nil && errors.Is(err, user.ErrorIncorrectPassword) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error changing password")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ForgotPassword godoc
+// @Summary Request a password reset
+// @Description Email a single use reset link to the user, the response is the same whether the email is registered or not
+// @Tags users
+// @Accept  json
+// @Param request body ForgotPasswordRequest true "Email of the account"
+// @Success 202
+// @Router /auth/password/forgot [post]
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var fr ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&fr); err != nil || fr.Email == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.Service.ForgotPassword(fr.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword godoc
+// @Summary Reset a password
+// @Description Set a new password with the token of a reset link, every session of the user is signed out
+// @Tags users
+// @Accept  json
+// @Param request body ResetPasswordRequest true "Reset token and new password"
+// @Success 204
+// @Failure 400 {object} PasswordPolicyErrorResponse
+// @Router /auth/password/reset [post]
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var rr ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err := h.Service.ResetPassword(r.Context(), rr.Token, rr.NewPassword)
+	if writePasswordPolicyError(w, err) {
+		return
+	}
+	if err != nil && errors.Is(err, user.ErrorInvalidResetToken) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error resetting password")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writePasswordPolicyError - answers a password the policy rejected with the
+// rules it broke, it returns false for any other error
+func writePasswordPolicyError(w http.ResponseWriter, err error) bool {
+	var policyErr *user.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	writeJSON(w, http.StatusBadRequest, PasswordPolicyErrorResponse{
+		Error:      "weak_password",
+		Violations: policyErr.Violations,
+	})
+	return true
+}
+
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	err := h.Service.DeleteUser(r.Context(), chi.URLParam(r, "id"))
 	if err != nil {
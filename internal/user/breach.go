@@ -0,0 +1,191 @@
+package user
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	PasswordBreached = "breached"
+
+	// hibpPrefixLength is the length of the hash prefixes the range files
+	// of the k-anonymity API are named after
+	hibpPrefixLength = 5
+	// hibpMaxLineLength fits a 40 character hash, a count and a CRLF
+	hibpMaxLineLength = 64
+)
+
+// BreachCorpus - counts how often a password appears in known breaches, it
+// works on local copies of the Have I Been Pwned SHA-1 corpus
+type BreachCorpus interface {
+	Occurrences(password string) (int, error)
+}
+
+// NewBreachCorpus - opens the corpus at path, a directory is read as range
+// files named after the first 5 characters of the hash (21BD1.txt holding
+// SUFFIX:COUNT lines as downloaded by the PwnedPasswordsDownloader) and a file
+// as the full HASH:COUNT list ordered by hash
+func NewBreachCorpus(path string) (BreachCorpus, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breach corpus: %w", err)
+	}
+	if info.IsDir() {
+		return hibpRangeDirectory(path), nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breach corpus: %w", err)
+	}
+	return &hibpOrderedFile{file: file, size: info.Size()}, nil
+}
+
+// hibpHash - the upper case hex SHA-1 of the password as used by the corpus
+func hibpHash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// parseHIBPLine - splits a HASH:COUNT line, the hash is upper cased
+func parseHIBPLine(line string) (string, int, bool) {
+	hash, count, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.ToUpper(hash), n, true
+}
+
+// hibpRangeDirectory - a k-anonymity prefix index, only the range file of the
+// prefix is read
+type hibpRangeDirectory string
+
+func (d hibpRangeDirectory) Occurrences(password string) (int, error) {
+	hash := hibpHash(password)
+	prefix, suffix := hash[:hibpPrefixLength], hash[hibpPrefixLength:]
+	file, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(filepath.Join(string(d), prefix))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not open range %s: %w", prefix, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rangeSuffix, count, ok := parseHIBPLine(scanner.Text()); ok && rangeSuffix == suffix {
+			return count, nil
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// hibpOrderedFile - the full corpus ordered by hash, it is binary searched in
+// place so the file never has to fit in memory
+type hibpOrderedFile struct {
+	file *os.File
+	size int64
+}
+
+func (f *hibpOrderedFile) Occurrences(password string) (int, error) {
+	hash := hibpHash(password)
+	// the line of the hash, when there is one, starts in [lo, hi)
+	lo, hi := int64(0), f.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := f.lineStart(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		line, err := f.readLine(start)
+		if err != nil {
+			return 0, err
+		}
+		lineHash, count, ok := parseHIBPLine(line)
+		if !ok {
+			return 0, fmt.Errorf("invalid line at offset %d of the breach corpus", start)
+		}
+		switch {
+		case lineHash == hash:
+			return count, nil
+		case lineHash < hash:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0, nil
+}
+
+// lineStart - the offset of the first line starting at or after offset
+func (f *hibpOrderedFile) lineStart(offset int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+	buf := make([]byte, hibpMaxLineLength)
+	n, err := f.file.ReadAt(buf, offset-1)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	i := bytes.IndexByte(buf[:n], '\n')
+	if i < 0 {
+		return f.size, nil
+	}
+	return offset + int64(i), nil
+}
+
+// readLine - the line starting at offset without its newline
+func (f *hibpOrderedFile) readLine(offset int64) (string, error) {
+	buf := make([]byte, hibpMaxLineLength)
+	n, err := f.file.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+		n = i
+	}
+	return string(buf[:n]), nil
+}
+
+// checkPassword - runs the password a user picked through the password policy
+// and the breach corpus, breaches are reported as one more violation. The
+// password is let through when the corpus can not be read so a damaged copy
+// does not block every registration.
+func (s *Service) checkPassword(password string, email string) error {
+	err := s.PasswordPolicy.Check(password, email)
+	if s.Breaches == nil {
+		return err
+	}
+	count, breachErr := s.Breaches.Occurrences(password)
+	if breachErr != nil {
+		log.WithError(breachErr).Error("could not check the password against the breach corpus")
+		return err
+	}
+	if count == 0 || count < s.Config.PasswordBreachThreshold {
+		return err
+	}
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		policyErr = &PasswordPolicyError{}
+	}
+	policyErr.Violations = append(policyErr.Violations, PasswordViolation{Code: PasswordBreached})
+	return policyErr
+}
@@ -0,0 +1,141 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// breachedPasswords - passwords with the number of times they were seen,
+// enough of them that the binary search takes several steps
+func breachedPasswords() map[string]int {
+	passwords := map[string]int{"password": 9545824, "brisket42": 1}
+	for i := 0; i < 500; i++ {
+		passwords[fmt.Sprintf("leaked-%d", i)] = i + 1
+	}
+	return passwords
+}
+
+// writeOrderedCorpus - writes the passwords as the HASH:COUNT file ordered by hash
+func writeOrderedCorpus(t *testing.T, passwords map[string]int, newline string) string {
+	t.Helper()
+	var lines []string
+	for password, count := range passwords {
+		lines = append(lines, fmt.Sprintf("%s:%d", hibpHash(password), count))
+	}
+	sort.Strings(lines)
+	path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, newline)+newline), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeRangeCorpus - writes the passwords as range files named after the prefix
+func writeRangeCorpus(t *testing.T, passwords map[string]int) string {
+	t.Helper()
+	ranges := map[string][]string{}
+	for password, count := range passwords {
+		hash := hibpHash(password)
+		prefix := hash[:hibpPrefixLength]
+		ranges[prefix] = append(ranges[prefix], fmt.Sprintf("%s:%d", hash[hibpPrefixLength:], count))
+	}
+	dir := t.TempDir()
+	for prefix, lines := range ranges {
+		sort.Strings(lines)
+		if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\r\n")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBreachCorpusOccurrences(t *testing.T) {
+	passwords := breachedPasswords()
+	corpora := map[string]string{
+		"ordered file":      writeOrderedCorpus(t, passwords, "\n"),
+		"ordered file crlf": writeOrderedCorpus(t, passwords, "\r\n"),
+		"range directory":   writeRangeCorpus(t, passwords),
+	}
+	for name, path := range corpora {
+		t.Run(name, func(t *testing.T) {
+			corpus, err := NewBreachCorpus(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for password, want := range passwords {
+				if got, err := corpus.Occurrences(password); err != nil || got != want {
+					t.Fatalf("%s: got %d, %v, want %d", password, got, err, want)
+				}
+			}
+			for _, password := range []string{"not leaked", "", "leaked-500"} {
+				got, err := corpus.Occurrences(password)
+				if name == "range directory" && errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				if err != nil || got != 0 {
+					t.Errorf("%q: got %d, %v, want 0", password, got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestOrderedCorpusFirstAndLastLine(t *testing.T) {
+	// a corpus of two lines, the search has to find both ends of the file
+	path := writeOrderedCorpus(t, map[string]int{"first": 3, "last": 4}, "\n")
+	corpus, err := NewBreachCorpus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for password, want := range map[string]int{"first": 3, "last": 4, "middle": 0} {
+		if got, err := corpus.Occurrences(password); err != nil || got != want {
+			t.Errorf("%s: got %d, %v, want %d", password, got, err, want)
+		}
+	}
+}
+
+func TestCheckPasswordAgainstBreaches(t *testing.T) {
+	corpus := writeOrderedCorpus(t, breachedPasswords(), "\n")
+	tests := []struct {
+		name      string
+		password  string
+		threshold int
+		want      []string
+	}{
+		{name: "breached", password: "leaked-9", threshold: 1, want: []string{PasswordBreached}},
+		{name: "seen less than the threshold", password: "leaked-9", threshold: 11},
+		{name: "seen as often as the threshold", password: "leaked-9", threshold: 10, want: []string{PasswordBreached}},
+		{name: "not breached", password: "grill season", threshold: 1},
+		{name: "denied and breached", password: "password", threshold: 1, want: []string{PasswordDenied, PasswordBreached}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _ := newTestService(t, func(config *Config) {
+				config.PasswordMinLength = 8
+				config.PasswordBreachCorpusPath = corpus
+				config.PasswordBreachThreshold = tt.threshold
+			})
+			if got := violationCodes(svc.checkPassword(tt.password, "")); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPasswordWithUnreadableCorpus(t *testing.T) {
+	dir := writeRangeCorpus(t, map[string]int{"leaked-1": 1})
+	svc, _ := newTestService(t, func(config *Config) {
+		config.PasswordBreachCorpusPath = dir
+		config.PasswordBreachThreshold = 1
+	})
+	// the range of the password is missing from the copy
+	if err := svc.checkPassword("grill season", ""); err != nil {
+		t.Errorf("a password was refused because the corpus could not be read: %v", err)
+	}
+}
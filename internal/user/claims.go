@@ -0,0 +1,78 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrorMissingClaim     = errors.New("token is missing a required claim")
+	ErrorTokenExpired     = errors.New("token is expired")
+	ErrorTokenNotYetValid = errors.New("token is not valid yet")
+	ErrorInvalidIssuer    = errors.New("token has an invalid issuer")
+	ErrorInvalidAudience  = errors.New("token has an invalid audience")
+)
+
+// validateClaims - checks the registered claims of a token against the
+// config, allowing for the configured clock skew on the time based ones
+func (c Config) validateClaims(claims map[string]interface{}, now time.Time) error {
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("%w: exp", ErrorMissingClaim)
+	}
+	if now.After(exp.Add(c.ClockSkew)) {
+		return ErrorTokenExpired
+	}
+
+	nbf, ok := numericClaim(claims, "nbf")
+	if !ok {
+		return fmt.Errorf("%w: nbf", ErrorMissingClaim)
+	}
+	if now.Before(nbf.Add(-c.ClockSkew)) {
+		return ErrorTokenNotYetValid
+	}
+	if iat, ok := numericClaim(claims, "iat"); ok && now.Before(iat.Add(-c.ClockSkew)) {
+		return ErrorTokenNotYetValid
+	}
+
+	if iss, _ := claims["iss"].(string); iss != c.Issuer {
+		return ErrorInvalidIssuer
+	}
+
+	for _, aud := range audienceClaim(claims) {
+		if c.AllowsAudience(aud) {
+			return nil
+		}
+	}
+	return ErrorInvalidAudience
+}
+
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	switch v := claims[name].(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case int64:
+		return time.Unix(v, 0), true
+	}
+	return time.Time{}, false
+}
+
+// audienceClaim - aud may either be a single string or a list of strings
+func audienceClaim(claims map[string]interface{}) []string {
+	switch v := claims["aud"].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		var audiences []string
+		for _, aud := range v {
+			if s, ok := aud.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+		return audiences
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+)
+
+const clientSecretLength = 32
+
+// AuthenticateClient - checks the credentials a client sent to the token
+// endpoint. Confidential clients must send their secret, public clients
+// must not send one.
+func (s *Service) AuthenticateClient(ctx context.Context, clientID string, secret string) (Client, error) {
+	client, err := s.Store.GetClient(ctx, clientID)
+	if err != nil {
+		return Client{}, ErrorInvalidClient
+	}
+	if !client.Confidential() {
+		if secret != "" {
+			return Client{}, ErrorInvalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return Client{}, ErrorInvalidClient
+	}
+	return client, nil
+}
+
+// ClientCredentialsGrant - the client_credentials grant, issues an access
+// token whose subject is the client itself. No refresh token is issued,
+// the client can always ask for a new access token.
+func (s *Service) ClientCredentialsGrant(ctx context.Context, client Client, scope string, jkt string) (TokenSet, error) {
+	if !client.Confidential() || !client.AllowsGrant(GrantTypeClientCredentials) {
+		return TokenSet{}, ErrorUnauthorizedClient
+	}
+	scope, err := allowedScope(client.Scopes, scope)
+	if err != nil {
+		return TokenSet{}, err
+	}
+
+	claims, err := s.accessTokenClaims(client.ID, s.Config.DefaultAudience())
+	if err != nil {
+		return TokenSet{}, err
+	}
+	claims["client_id"] = client.ID
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	if jkt != "" {
+		claims["cnf"] = map[string]interface{}{"jkt": jkt}
+	}
+	accessToken, err := s.issueAccessToken(ctx, claims)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	return s.tokenSet(accessToken, claims, "", scope, jkt), nil
+}
+
+// RotateClientSecret - generates a new secret for the client, which makes
+// it confidential. The secret is only returned here, just its hash is stored.
+func (s *Service) RotateClientSecret(ctx context.Context, clientID string) (string, error) {
+	if _, err := s.Store.GetClient(ctx, clientID); err != nil {
+		return "", ErrorClientNotFound
+	}
+	secret, err := randomToken(clientSecretLength)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Store.UpdateClientSecret(ctx, clientID, hashToken(secret)); err != nil {
+		return "", fmt.Errorf("could not store client secret: %w", err)
+	}
+	return secret, nil
+}
+
+// IsClientToken - reports whether the claims belong to a token issued to a
+// client for itself rather than to a user, RFC 9068 uses the client id as
+// the subject of those tokens
+func IsClientToken(claims map[string]interface{}) bool {
+	sub, _ := claims["sub"].(string)
+	clientID, _ := claims["client_id"].(string)
+	return sub != "" && sub == clientID
+}
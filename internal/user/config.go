@@ -0,0 +1,408 @@
+package user
+
+import (
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config - settings used by the user service when issuing tokens
+type Config struct {
+	// DevMode allows shortcuts that are only safe on a developer machine,
+	// such as signing with a key generated on start up
+	DevMode bool
+	// SigningAlgorithm is one of RS256, ES256 or EdDSA
+	SigningAlgorithm string
+	// TokenFormat is either jwt or paseto, paseto v4.public needs EdDSA keys
+	TokenFormat string
+	// AccessTokenMode is either signed or opaque
+	AccessTokenMode string
+	// SigningKeyPath points to a PEM encoded private key, it is required
+	// outside of DevMode where a key is generated on start up when it is empty
+	SigningKeyPath string
+	// KeyRotationInterval is how often a new signing key is generated,
+	// zero disables scheduled rotation
+	KeyRotationInterval time.Duration
+	// KeyGracePeriod is how long a retired key keeps verifying tokens, it has
+	// to cover the longest token lifetime plus the clock skew
+	KeyGracePeriod time.Duration
+	// KeyEncryptionKey is the base64 encoded AES-256 key rotated signing keys
+	// are encrypted with before they are stored, it is never stored itself.
+	// Without it rotated keys only live in memory and scheduled rotation is off
+	KeyEncryptionKey string
+	// RegistrableScopes are the scopes clients may ask for when they
+	// register themselves, other scopes are granted by an administrator
+	RegistrableScopes []string
+	// IntrospectionClients maps the client ids allowed to call the
+	// introspection endpoint to their secrets
+	IntrospectionClients map[string]string
+	// Issuer is put in the iss claim and required when validating tokens
+	Issuer string
+	// Audiences lists the accepted aud values, the first one is used when
+	// a token is issued without asking for a specific audience
+	Audiences []string
+	// AccessTokenTTL is the default access token lifetime
+	AccessTokenTTL time.Duration
+	// AudienceTTLs overrides the access token lifetime per audience
+	AudienceTTLs map[string]time.Duration
+	// RefreshTokenTTL is how long a refresh token can be used
+	RefreshTokenTTL time.Duration
+	// ClockSkew is the leeway allowed when checking exp, nbf and iat
+	ClockSkew time.Duration
+	// DPoPProofMaxAge is how old a DPoP proof may be when it is received
+	DPoPProofMaxAge time.Duration
+	// IDTokenTTL is the lifetime of OpenID Connect ID tokens
+	IDTokenTTL time.Duration
+	// DeviceCodeTTL is how long a device code waits for the user to approve it
+	DeviceCodeTTL time.Duration
+	// DevicePollInterval is how long devices wait between token requests
+	DevicePollInterval time.Duration
+	// FederatedProviders are the upstream OpenID Connect providers users
+	// can sign in with
+	FederatedProviders []FederatedProvider
+	// LDAP is the directory users can sign in with their corporate accounts
+	LDAP LDAPConfig
+	// SAMLProviders are the SAML 2.0 identity providers users can sign in with
+	SAMLProviders []SAMLProvider
+	// SAMLKeyPath and SAMLCertificatePath point to the PEM encoded RSA key
+	// pair of our service provider, one is generated on start up when empty
+	SAMLKeyPath         string
+	SAMLCertificatePath string
+	// PasswordHasher is the algorithm new password hashes are made with
+	PasswordHasher string
+	// Argon2Memory (in KiB), Argon2Iterations and Argon2Parallelism are the
+	// argon2id cost parameters, stored hashes with other values are rehashed
+	// on login
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	// PasswordPeppers maps pepper versions to base64 encoded secrets of at
+	// least 32 bytes, PasswordPepperVersion is the one new hashes use. Older
+	// versions stay until every hash made with them has been rehashed.
+	PasswordPeppers       map[string]string
+	PasswordPepperVersion string
+	// PasswordMinLength and PasswordMaxLength bound the number of characters
+	// in a password, a zero max length means no upper bound
+	PasswordMinLength int
+	PasswordMaxLength int
+	// PasswordRequiredClasses lists the character classes every password
+	// needs, any of lower, upper, digit and symbol
+	PasswordRequiredClasses []string
+	// PasswordDisallowEmail rejects passwords that contain the email
+	PasswordDisallowEmail bool
+	// PasswordDenyListPath points to a file of passwords that are never
+	// accepted, one per line, on top of a short built in list
+	PasswordDenyListPath string
+	// PasswordBreachCorpusPath points to a local copy of the Have I Been Pwned
+	// SHA-1 corpus, either the ordered HASH:COUNT file or a directory of range
+	// files, passwords seen PasswordBreachThreshold times or more are refused
+	PasswordBreachCorpusPath string
+	PasswordBreachThreshold  int
+	// PasswordResetTTL is how long a password reset link works
+	PasswordResetTTL time.Duration
+	// PasswordResetCooldown is how long after a reset link is sent no other
+	// link is sent to the same user
+	PasswordResetCooldown time.Duration
+	// PasswordResetURL is the page of the frontend reset links point to, the
+	// token is added as the token query parameter
+	PasswordResetURL string
+	// Mail is how emails to users are delivered
+	Mail MailConfig
+}
+
+// NewConfig - builds the service config from the environment
+func NewConfig() Config {
+	return Config{
+		DevMode:             getOrDefault("DEV_MODE", "false") == "true",
+		SigningAlgorithm:    getOrDefault("JWT_SIGNING_ALG", AlgorithmRS256),
+		TokenFormat:         getOrDefault("TOKEN_FORMAT", TokenFormatJWT),
+		AccessTokenMode:     getOrDefault("ACCESS_TOKEN_MODE", AccessTokenModeSigned),
+		SigningKeyPath:      getOrDefault("JWT_PRIVATE_KEY_PATH", ""),
+		KeyRotationInterval: getDurationOrDefault("JWT_KEY_ROTATION_INTERVAL", 0),
+		KeyGracePeriod:      getDurationOrDefault("JWT_KEY_GRACE_PERIOD", time.Hour*48),
+		KeyEncryptionKey:    getOrDefault("JWT_KEY_ENCRYPTION_KEY", ""),
+		// REGISTRABLE_SCOPES=openid,profile,email,catalog:read
+		RegistrableScopes: getListOrDefault("REGISTRABLE_SCOPES", defaultClientScopes),
+		// INTROSPECTION_CLIENTS=client-a:secret-a,client-b:secret-b
+		IntrospectionClients: getMapOrDefault("INTROSPECTION_CLIENTS", map[string]string{}),
+		Issuer:               getOrDefault("JWT_ISSUER", "http://localhost:8080"),
+		Audiences:            getListOrDefault("JWT_AUDIENCES", []string{"meathub"}),
+		AccessTokenTTL:       getDurationOrDefault("JWT_ACCESS_TOKEN_TTL", time.Hour*24),
+		// JWT_AUDIENCE_TTLS=payments:5m,orders:1h
+		AudienceTTLs:    getDurationMapOrDefault("JWT_AUDIENCE_TTLS", map[string]time.Duration{}),
+		RefreshTokenTTL: getDurationOrDefault("JWT_REFRESH_TOKEN_TTL", time.Hour*24*7),
+		ClockSkew:       getDurationOrDefault("JWT_CLOCK_SKEW", time.Second*30),
+		DPoPProofMaxAge: getDurationOrDefault("DPOP_PROOF_MAX_AGE", time.Minute*5),
+		IDTokenTTL:      getDurationOrDefault("ID_TOKEN_TTL", time.Hour),
+		DeviceCodeTTL:   getDurationOrDefault("DEVICE_CODE_TTL", time.Minute*10),
+		// RFC 8628 defaults to 5 seconds when no interval is given
+		DevicePollInterval:  getDurationOrDefault("DEVICE_POLL_INTERVAL", time.Second*5),
+		FederatedProviders:  getFederatedProviders(),
+		LDAP:                getLDAPConfig(),
+		SAMLProviders:       getSAMLProviders(),
+		SAMLKeyPath:         getOrDefault("SAML_SP_KEY_PATH", ""),
+		SAMLCertificatePath: getOrDefault("SAML_SP_CERT_PATH", ""),
+		PasswordHasher:      getOrDefault("PASSWORD_HASHER", PasswordHasherArgon2id),
+		// the second option recommended by RFC 9106, for hosts that can not
+		// spare 2 GiB per hash
+		Argon2Memory:      uint32(getIntOrDefault("ARGON2_MEMORY", 64*1024)),
+		Argon2Iterations:  uint32(getIntOrDefault("ARGON2_ITERATIONS", 3)),
+		Argon2Parallelism: uint8(getIntOrDefault("ARGON2_PARALLELISM", 4)),
+		// PASSWORD_PEPPERS=1:<base64 secret>,2:<base64 secret>
+		PasswordPeppers:       getMapOrDefault("PASSWORD_PEPPERS", map[string]string{}),
+		PasswordPepperVersion: getOrDefault("PASSWORD_PEPPER_VERSION", ""),
+		PasswordMinLength:     getIntOrDefault("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:     getNonNegativeIntOrDefault("PASSWORD_MAX_LENGTH", 128),
+		// PASSWORD_REQUIRED_CLASSES=lower,upper,digit
+		PasswordRequiredClasses: getListOrDefault("PASSWORD_REQUIRED_CLASSES", nil),
+		PasswordDisallowEmail:   getOrDefault("PASSWORD_DISALLOW_EMAIL", "true") == "true",
+		PasswordDenyListPath:    getOrDefault("PASSWORD_DENY_LIST_PATH", ""),
+		// PASSWORD_BREACH_CORPUS_PATH=/data/pwnedpasswords
+		PasswordBreachCorpusPath: getOrDefault("PASSWORD_BREACH_CORPUS_PATH", ""),
+		PasswordBreachThreshold:  getIntOrDefault("PASSWORD_BREACH_THRESHOLD", 1),
+		PasswordResetTTL:         getDurationOrDefault("PASSWORD_RESET_TTL", time.Minute*30),
+		PasswordResetCooldown:    getDurationOrDefault("PASSWORD_RESET_COOLDOWN", time.Minute*5),
+		PasswordResetURL:         getOrDefault("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+		Mail: MailConfig{
+			Mailer:       getOrDefault("MAILER", MailerLog),
+			SMTPAddr:     getOrDefault("SMTP_ADDR", ""),
+			SMTPUsername: getOrDefault("SMTP_USERNAME", ""),
+			SMTPPassword: getOrDefault("SMTP_PASSWORD", ""),
+			From:         getOrDefault("MAIL_FROM", ""),
+		},
+	}
+}
+
+// DefaultAudience - audience of tokens issued without asking for one
+func (c Config) DefaultAudience() string {
+	if len(c.Audiences) == 0 {
+		return ""
+	}
+	return c.Audiences[0]
+}
+
+// AllowsAudience - reports whether tokens may be issued for and accepted from the audience
+func (c Config) AllowsAudience(audience string) bool {
+	for _, allowed := range c.Audiences {
+		if allowed == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessTokenTTLFor - lifetime of an access token issued for the audience
+func (c Config) AccessTokenTTLFor(audience string) time.Duration {
+	if ttl, ok := c.AudienceTTLs[audience]; ok {
+		return ttl
+	}
+	return c.AccessTokenTTL
+}
+
+// longestSignedTokenTTL - lifetime of the longest lived token signed by the
+// keyring, a retired key has to keep verifying it until it expires
+func (c Config) longestSignedTokenTTL() time.Duration {
+	longest := c.IDTokenTTL
+	if c.AccessTokenTTL > longest {
+		longest = c.AccessTokenTTL
+	}
+	for _, ttl := range c.AudienceTTLs {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest
+}
+
+// getFederatedProviders - reads the providers named in FEDERATED_PROVIDERS,
+// the settings of a provider named google come from FEDERATED_GOOGLE_*
+func getFederatedProviders() []FederatedProvider {
+	var providers []FederatedProvider
+	for _, name := range getListOrDefault("FEDERATED_PROVIDERS", nil) {
+		prefix := "FEDERATED_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := FederatedProvider{
+			Name:         name,
+			Issuer:       getOrDefault(prefix+"ISSUER", ""),
+			ClientID:     getOrDefault(prefix+"CLIENT_ID", ""),
+			ClientSecret: getOrDefault(prefix+"CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(getOrDefault(prefix+"SCOPES", "openid email profile")),
+			// FEDERATED_MICROSOFT_CLAIM_MAPPING=email:preferred_username
+			ClaimMapping:         getMapOrDefault(prefix+"CLAIM_MAPPING", map[string]string{}),
+			AllowedDomains:       getListOrDefault(prefix+"ALLOWED_DOMAINS", nil),
+			RequireVerifiedEmail: getOrDefault(prefix+"REQUIRE_VERIFIED_EMAIL", "true") == "true",
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Errorf("identity provider %s needs %sISSUER and %sCLIENT_ID, skipping it", name, prefix, prefix)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+// getSAMLProviders - reads the providers named in SAML_PROVIDERS, the settings
+// of a provider named okta come from SAML_OKTA_*
+func getSAMLProviders() []SAMLProvider {
+	var providers []SAMLProvider
+	for _, name := range getListOrDefault("SAML_PROVIDERS", nil) {
+		prefix := "SAML_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := SAMLProvider{
+			Name:         name,
+			MetadataURL:  getOrDefault(prefix+"METADATA_URL", ""),
+			NameIDFormat: getOrDefault(prefix+"NAME_ID_FORMAT", "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"),
+			// SAML_ADFS_ATTRIBUTE_MAPPING=email:http://schemas.xmlsoap.org/claims/EmailAddress
+			AttributeMapping: getMapOrDefault(prefix+"ATTRIBUTE_MAPPING", map[string]string{}),
+			AllowedDomains:   getListOrDefault(prefix+"ALLOWED_DOMAINS", nil),
+			GroupRoles:       getMapOrDefault(prefix+"GROUP_ROLES", map[string]string{}),
+		}
+		if provider.MetadataURL == "" {
+			log.Errorf("identity provider %s needs %sMETADATA_URL, skipping it", name, prefix)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+// getLDAPConfig - reads the LDAP_* settings, the defaults suit OpenLDAP
+func getLDAPConfig() LDAPConfig {
+	baseDN := getOrDefault("LDAP_BASE_DN", "")
+	return LDAPConfig{
+		URL:          getOrDefault("LDAP_URL", ""),
+		StartTLS:     getOrDefault("LDAP_START_TLS", "false") == "true",
+		BindDN:       getOrDefault("LDAP_BIND_DN", ""),
+		BindPassword: getOrDefault("LDAP_BIND_PASSWORD", ""),
+		BaseDN:       baseDN,
+		UserFilter:   getOrDefault("LDAP_USER_FILTER", "(&(objectClass=person)(|(uid={login})(mail={login})))"),
+		// Active Directory keeps the email in mail as well, userPrincipalName
+		// is an alternative
+		EmailAttribute: getOrDefault("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		GroupBaseDN:    getOrDefault("LDAP_GROUP_BASE_DN", baseDN),
+		// LDAP_GROUP_FILTER=(&(objectClass=groupOfNames)(member={dn}))
+		GroupFilter: getOrDefault("LDAP_GROUP_FILTER", ""),
+		// group DNs contain commas, pairs are separated by semicolons
+		// LDAP_GROUP_ROLES=wholesale-buyers:buyer;cn=admins,ou=groups,dc=meathub,dc=local:admin
+		GroupRoles: getGroupRolesOrDefault("LDAP_GROUP_ROLES", map[string]string{}),
+		Timeout:    getDurationOrDefault("LDAP_TIMEOUT", time.Second*5),
+	}
+}
+
+func getOrDefault(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Errorf("invalid duration %q for %s, using %s", value, key, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+func getIntOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		log.Errorf("invalid number %q for %s, using %d", value, key, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// getNonNegativeIntOrDefault - like getIntOrDefault but accepts zero, for
+// settings where zero turns a limit off
+func getNonNegativeIntOrDefault(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		log.Errorf("invalid number %q for %s, using %d", value, key, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+func getMapOrDefault(key string, defaultValue map[string]string) map[string]string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	result := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || k == "" {
+			log.Errorf("invalid entry %q in %s, expected key:value", pair, key)
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// getGroupRolesOrDefault - reads group:role pairs separated by semicolons, a
+// pair is split at its last colon as roles never contain one
+func getGroupRolesOrDefault(key string, defaultValue map[string]string) map[string]string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	result := map[string]string{}
+	for _, pair := range strings.Split(value, ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 {
+			log.Errorf("invalid entry %q in %s, expected group:role", pair, key)
+			continue
+		}
+		result[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+	return result
+}
+
+func getListOrDefault(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func getDurationMapOrDefault(key string, defaultValue map[string]time.Duration) map[string]time.Duration {
+	if os.Getenv(key) == "" {
+		return defaultValue
+	}
+	result := map[string]time.Duration{}
+	for k, v := range getMapOrDefault(key, map[string]string{}) {
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			log.Errorf("invalid duration %q for %s in %s", v, k, key)
+			continue
+		}
+		result[k] = duration
+	}
+	return result
+}
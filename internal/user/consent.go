@@ -0,0 +1,185 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	consentChallengeTTL    = time.Minute * 10
+	consentChallengeLength = 32
+)
+
+var (
+	ErrorConsentChallenge = errors.New("consent request is unknown or expired")
+	ErrorConsentDenied    = errors.New("the user denied the request")
+	ErrorConsentNotFound  = errors.New("no consent was given to this client")
+)
+
+// Consent - the scopes a user agreed to share with a third party client
+type Consent struct {
+	UserID     string
+	ClientID   string
+	ClientName string
+	Scopes     []string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// ConsentChallenge - an authorization request waiting for the user to
+// approve it, only the hash of the challenge is stored
+type ConsentChallenge struct {
+	ChallengeHash string
+	UserID        string
+	Request       AuthorizationRequest
+	ExpiresAt     time.Time
+}
+
+// ConsentPrompt - what the user is asked to approve, the challenge has to
+// come back with the decision
+type ConsentPrompt struct {
+	Challenge string
+	Client    Client
+	Scopes    []string
+}
+
+type ConsentStore interface {
+	GetConsent(ctx context.Context, userID string, clientID string) (Consent, error)
+	// SaveConsent creates the consent or replaces its scopes
+	SaveConsent(context.Context, Consent) error
+	ListConsents(ctx context.Context, userID string) ([]Consent, error)
+	// DeleteConsent returns false when there was no consent to delete
+	DeleteConsent(ctx context.Context, userID string, clientID string) (bool, error)
+	CreateConsentChallenge(context.Context, ConsentChallenge) error
+	// ConsumeConsentChallenge deletes the challenge and returns it
+	ConsumeConsentChallenge(ctx context.Context, challengeHash string) (ConsentChallenge, error)
+	DeleteExpiredConsentChallenges(ctx context.Context) (int64, error)
+}
+
+// PromptConsent - checks whether the user has to approve the authorization
+// request, nil means the code can be issued right away. First party clients
+// never ask and a consent that covers the requested scopes is reused.
+func (s *Service) PromptConsent(ctx context.Context, req AuthorizationRequest, usr User) (*ConsentPrompt, error) {
+	client, err := s.Store.GetClient(ctx, req.ClientID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidClient, err)
+	}
+	if client.FirstParty {
+		return nil, nil
+	}
+	scopes := strings.Fields(req.Scope)
+	if consent, err := s.Store.GetConsent(ctx, usr.ID, client.ID); err == nil && coversScopes(consent.Scopes, scopes) {
+		return nil, nil
+	}
+
+	challenge, err := randomToken(consentChallengeLength)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Store.CreateConsentChallenge(ctx, ConsentChallenge{
+		ChallengeHash: hashToken(challenge),
+		UserID:        usr.ID,
+		Request:       req,
+		ExpiresAt:     time.Now().Add(consentChallengeTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not store consent request: %w", err)
+	}
+	return &ConsentPrompt{Challenge: challenge, Client: client, Scopes: scopes}, nil
+}
+
+// DecideConsent - records the decision of the user on a consent prompt and
+// returns the user and the authorization request to resume. An approval is
+// added to the scopes the user already shared with the client, a denial
+// returns ErrorConsentDenied along with the request to answer.
+func (s *Service) DecideConsent(ctx context.Context, challenge string, approved bool) (User, AuthorizationRequest, error) {
+	pending, err := s.Store.ConsumeConsentChallenge(ctx, hashToken(challenge))
+	if err != nil || time.Now().After(pending.ExpiresAt) {
+		return User{}, AuthorizationRequest{}, ErrorConsentChallenge
+	}
+	if !approved {
+		return User{}, pending.Request, ErrorConsentDenied
+	}
+	usr, err := s.Store.GetUser(ctx, pending.UserID)
+	if err != nil {
+		return User{}, AuthorizationRequest{}, err
+	}
+
+	if err := s.grantConsent(ctx, usr.ID, pending.Request.ClientID, strings.Fields(pending.Request.Scope)); err != nil {
+		return User{}, AuthorizationRequest{}, err
+	}
+	return usr, pending.Request, nil
+}
+
+// grantConsent - adds the scopes to the ones the user already shared with
+// the client
+func (s *Service) grantConsent(ctx context.Context, userID string, clientID string, scopes []string) error {
+	if consent, err := s.Store.GetConsent(ctx, userID, clientID); err == nil {
+		for _, scope := range consent.Scopes {
+			if !contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	err := s.Store.SaveConsent(ctx, Consent{
+		UserID:   userID,
+		ClientID: clientID,
+		Scopes:   scopes,
+	})
+	if err != nil {
+		return fmt.Errorf("could not save consent: %w", err)
+	}
+	return nil
+}
+
+// Consents - the clients the user shared scopes with
+func (s *Service) Consents(ctx context.Context, userID string) ([]Consent, error) {
+	return s.Store.ListConsents(ctx, userID)
+}
+
+// RevokeConsent - withdraws the consent given to the client along with the
+// refresh tokens the client holds for the user, the client has to ask again
+func (s *Service) RevokeConsent(ctx context.Context, userID string, clientID string) error {
+	deleted, err := s.Store.DeleteConsent(ctx, userID, clientID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrorConsentNotFound
+	}
+	if err := s.Store.RevokeClientRefreshTokens(ctx, userID, clientID); err != nil {
+		return fmt.Errorf("could not revoke refresh tokens of %s: %w", clientID, err)
+	}
+	return nil
+}
+
+// ConsentOwner - the user whose consents the token may manage, third party
+// clients can not see or withdraw what the user shared with others
+func (s *Service) ConsentOwner(ctx context.Context, claims map[string]interface{}) (string, error) {
+	if IsClientToken(claims) {
+		return "", ErrorInsufficientScope
+	}
+	sub, _ := claims["sub"].(string)
+	clientID, _ := claims["client_id"].(string)
+	if clientID == "" {
+		return sub, nil
+	}
+	client, err := s.Store.GetClient(ctx, clientID)
+	if err != nil || !client.FirstParty {
+		return "", ErrorInsufficientScope
+	}
+	return sub, nil
+}
+
+// coversScopes - reports whether every requested scope was already granted
+func coversScopes(granted []string, requested []string) bool {
+	for _, scope := range requested {
+		if !contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
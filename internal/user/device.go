@@ -0,0 +1,247 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+	DeviceCodeRedeemed = "redeemed"
+
+	deviceCodeLength = 32
+	// user codes avoid vowels so they never spell words and avoid letters
+	// that are easy to mix up on a small display
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	// deviceCodeSlowDown is added to the interval of a device polling too fast
+	deviceCodeSlowDown = time.Second * 5
+)
+
+var (
+	ErrorAuthorizationPending = errors.New("the user has not approved the device yet")
+	ErrorSlowDown             = errors.New("the device is polling too fast")
+	ErrorExpiredToken         = errors.New("device code expired")
+	ErrorAccessDenied         = errors.New("the user denied the device")
+	ErrorUserCodeNotFound     = errors.New("user code is unknown or no longer pending")
+)
+
+// DeviceCode - server side record of a device authorization, only the hash
+// of the device code is stored
+type DeviceCode struct {
+	DeviceCodeHash string
+	UserCode       string
+	ClientID       string
+	Scope          string
+	// UserID is set once a user approved or denied the device
+	UserID       string
+	Status       string
+	Interval     time.Duration
+	LastPolledAt *time.Time
+	ExpiresAt    time.Time
+}
+
+type DeviceCodeStore interface {
+	CreateDeviceCode(context.Context, DeviceCode) error
+	GetDeviceCodeByUserCode(ctx context.Context, userCode string) (DeviceCode, error)
+	// DecideDeviceCode sets the status of a pending code, false means the
+	// code was no longer pending
+	DecideDeviceCode(ctx context.Context, userCode string, status string, userID string) (bool, error)
+	// PollDeviceCode records a poll of the token endpoint and returns the
+	// code as it was before that poll
+	PollDeviceCode(ctx context.Context, deviceCodeHash string) (DeviceCode, error)
+	SlowDownDeviceCode(ctx context.Context, deviceCodeHash string, by time.Duration) error
+	// RedeemDeviceCode marks an approved code as redeemed, false means it
+	// was redeemed before
+	RedeemDeviceCode(ctx context.Context, deviceCodeHash string) (bool, error)
+	DeleteExpiredDeviceCodes(ctx context.Context) (int64, error)
+}
+
+// DeviceAuthorization - RFC 8628 device authorization response
+type DeviceAuthorization struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               int64
+	Interval                int64
+}
+
+// StartDeviceAuthorization - issues a device code for the device to poll
+// with and a user code for the user to enter on another device
+func (s *Service) StartDeviceAuthorization(ctx context.Context, client Client, scope string) (DeviceAuthorization, error) {
+	if !client.AllowsGrant(GrantTypeDeviceCode) {
+		return DeviceAuthorization{}, ErrorUnauthorizedClient
+	}
+	scope, err := allowedScope(client.Scopes, scope)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	deviceCode, err := randomToken(deviceCodeLength)
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+
+	err = s.Store.CreateDeviceCode(ctx, DeviceCode{
+		DeviceCodeHash: hashToken(deviceCode),
+		UserCode:       userCode,
+		ClientID:       client.ID,
+		Scope:          scope,
+		Status:         DeviceCodePending,
+		Interval:       s.Config.DevicePollInterval,
+		ExpiresAt:      time.Now().Add(s.Config.DeviceCodeTTL),
+	})
+	if err != nil {
+		return DeviceAuthorization{}, fmt.Errorf("could not store device code: %w", err)
+	}
+	display := formatUserCode(userCode)
+	return DeviceAuthorization{
+		DeviceCode:              deviceCode,
+		UserCode:                display,
+		VerificationURI:         s.externalURL("/oauth/device"),
+		VerificationURIComplete: s.externalURL("/oauth/device") + "?user_code=" + display,
+		ExpiresIn:               int64(s.Config.DeviceCodeTTL.Seconds()),
+		Interval:                int64(s.Config.DevicePollInterval.Seconds()),
+	}, nil
+}
+
+// LookupUserCode - returns the pending device authorization a user code belongs to
+func (s *Service) LookupUserCode(ctx context.Context, userCode string) (DeviceCode, error) {
+	stored, err := s.Store.GetDeviceCodeByUserCode(ctx, normalizeUserCode(userCode))
+	if err != nil || stored.Status != DeviceCodePending || time.Now().After(stored.ExpiresAt) {
+		return DeviceCode{}, ErrorUserCodeNotFound
+	}
+	return stored, nil
+}
+
+// ApproveDevice - lets the device behind the user code get tokens for the
+// user. The verification page shows the requested scopes, approving a third
+// party client there is consent to share them and is recorded like one so
+// the user can withdraw it.
+func (s *Service) ApproveDevice(ctx context.Context, userCode string, usr User) error {
+	stored, err := s.LookupUserCode(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	client, err := s.Store.GetClient(ctx, stored.ClientID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrorInvalidClient, err)
+	}
+	if !client.FirstParty {
+		if err := s.grantConsent(ctx, usr.ID, client.ID, strings.Fields(stored.Scope)); err != nil {
+			return err
+		}
+	}
+	return s.decideDevice(ctx, stored, DeviceCodeApproved, usr)
+}
+
+// DenyDevice - makes the device behind the user code stop polling
+func (s *Service) DenyDevice(ctx context.Context, userCode string, usr User) error {
+	stored, err := s.LookupUserCode(ctx, userCode)
+	if err != nil {
+		return err
+	}
+	return s.decideDevice(ctx, stored, DeviceCodeDenied, usr)
+}
+
+func (s *Service) decideDevice(ctx context.Context, stored DeviceCode, status string, usr User) error {
+	decided, err := s.Store.DecideDeviceCode(ctx, stored.UserCode, status, usr.ID)
+	if err != nil {
+		return fmt.Errorf("could not update device code: %w", err)
+	}
+	if !decided {
+		return ErrorUserCodeNotFound
+	}
+	return nil
+}
+
+// DeviceCodeGrant - the device_code grant, answers a poll of the device with
+// tokens once the user approved it. Devices polling faster than their
+// interval are told to slow down and get a longer interval.
+func (s *Service) DeviceCodeGrant(ctx context.Context, client Client, deviceCode string, jkt string) (TokenSet, error) {
+	if !client.AllowsGrant(GrantTypeDeviceCode) {
+		return TokenSet{}, ErrorUnauthorizedClient
+	}
+	stored, err := s.Store.PollDeviceCode(ctx, hashToken(deviceCode))
+	if err != nil || stored.ClientID != client.ID {
+		return TokenSet{}, ErrorInvalidGrant
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return TokenSet{}, ErrorExpiredToken
+	}
+
+	switch stored.Status {
+	case DeviceCodePending:
+		if stored.LastPolledAt != nil && time.Since(*stored.LastPolledAt) < stored.Interval {
+			if err := s.Store.SlowDownDeviceCode(ctx, stored.DeviceCodeHash, deviceCodeSlowDown); err != nil {
+				return TokenSet{}, err
+			}
+			return TokenSet{}, ErrorSlowDown
+		}
+		return TokenSet{}, ErrorAuthorizationPending
+	case DeviceCodeDenied:
+		return TokenSet{}, ErrorAccessDenied
+	case DeviceCodeApproved:
+		redeemed, err := s.Store.RedeemDeviceCode(ctx, stored.DeviceCodeHash)
+		if err != nil {
+			return TokenSet{}, err
+		}
+		if !redeemed {
+			return TokenSet{}, ErrorInvalidGrant
+		}
+		usr, err := s.Store.GetUser(ctx, stored.UserID)
+		if err != nil {
+			return TokenSet{}, err
+		}
+		tokens, _, err := s.issueTokenSet(ctx, usr, client.ID, stored.Scope, jkt)
+		return tokens, err
+	}
+	return TokenSet{}, ErrorInvalidGrant
+}
+
+// newUserCode - a random user code in its normalized form
+func newUserCode() (string, error) {
+	code := make([]byte, userCodeLength)
+	size := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode - upper cases the code and drops the dash and anything
+// else users may type around it
+func normalizeUserCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// formatUserCode - splits a normalized code in two halves for display
+func formatUserCode(code string) string {
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
@@ -0,0 +1,178 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	dpopProofType = "dpop+jwt"
+
+	TokenTypeBearer = "Bearer"
+	TokenTypeDPoP   = "DPoP"
+)
+
+var (
+	ErrorInvalidDPoPProof    = errors.New("invalid dpop proof")
+	ErrorDPoPProofReplayed   = errors.New("dpop proof has already been used")
+	ErrorDPoPBindingMismatch = errors.New("token is bound to another dpop key")
+	ErrorDPoPProofRequired   = errors.New("token is dpop bound, a dpop proof is required")
+)
+
+// dpopProofAlgorithms - asymmetric algorithms clients may sign proofs with
+var dpopProofAlgorithms = []string{"RS256", "PS256", "ES256", "ES384", "EdDSA"}
+
+// DPoPReplayStore - remembers the proofs that have been seen so a proof can
+// only be used once
+type DPoPReplayStore interface {
+	// RecordDPoPProof returns false when the proof was already recorded
+	RecordDPoPProof(ctx context.Context, id string, expiresAt time.Time) (bool, error)
+	DeleteExpiredDPoPProofs(ctx context.Context) (int64, error)
+}
+
+// ValidateDPoPProof - verifies an RFC 9449 proof sent with a request to path
+// and returns the thumbprint of the key it was signed with. The access token
+// is only given when the proof accompanies one, its hash must then match ath.
+func (s *Service) ValidateDPoPProof(ctx context.Context, proof string, method string, path string, accessToken string) (string, error) {
+	var jwk JWK
+	parser := jwt.NewParser(jwt.WithValidMethods(dpopProofAlgorithms), jwt.WithoutClaimsValidation())
+	token, err := parser.Parse(proof, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != dpopProofType {
+			return nil, fmt.Errorf("%w: typ must be %s", ErrorInvalidDPoPProof, dpopProofType)
+		}
+		header, ok := token.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: jwk is missing", ErrorInvalidDPoPProof)
+		}
+		if _, private := header["d"]; private {
+			return nil, fmt.Errorf("%w: jwk must not contain a private key", ErrorInvalidDPoPProof)
+		}
+		raw, err := json.Marshal(header)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &jwk); err != nil {
+			return nil, fmt.Errorf("%w: invalid jwk", ErrorInvalidDPoPProof)
+		}
+		return jwk.PublicKey()
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrorInvalidDPoPProof, err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", ErrorInvalidDPoPProof
+	}
+
+	if htm, _ := claims["htm"].(string); htm != method {
+		return "", fmt.Errorf("%w: htm does not match the request", ErrorInvalidDPoPProof)
+	}
+	if htu, _ := claims["htu"].(string); !sameURL(htu, s.externalURL(path)) {
+		return "", fmt.Errorf("%w: htu does not match the request", ErrorInvalidDPoPProof)
+	}
+	iat, ok := numericClaim(claims, "iat")
+	if !ok {
+		return "", fmt.Errorf("%w: iat is missing", ErrorInvalidDPoPProof)
+	}
+	window := s.Config.DPoPProofMaxAge + s.Config.ClockSkew
+	if age := time.Since(iat); age > window || age < -window {
+		return "", fmt.Errorf("%w: iat is outside the accepted window", ErrorInvalidDPoPProof)
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if ath, _ := claims["ath"].(string); ath != encodeSegment(sum[:]) {
+			return "", fmt.Errorf("%w: ath does not match the access token", ErrorInvalidDPoPProof)
+		}
+	}
+
+	jkt, err := thumbprint(jwk)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrorInvalidDPoPProof, err)
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", fmt.Errorf("%w: jti is missing", ErrorInvalidDPoPProof)
+	}
+	fresh, err := s.Store.RecordDPoPProof(ctx, hashToken(jkt+":"+jti), iat.Add(window))
+	if err != nil {
+		return "", fmt.Errorf("could not record dpop proof: %w", err)
+	}
+	if !fresh {
+		return "", ErrorDPoPProofReplayed
+	}
+	return jkt, nil
+}
+
+// ValidateBoundToken - validates an access token presented with the given
+// authorization scheme, DPoP bound tokens also need a valid proof for the request
+func (s *Service) ValidateBoundToken(ctx context.Context, scheme string, token string, proof string, method string, path string) (map[string]interface{}, error) {
+	claims, err := s.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	bound := ConfirmationThumbprint(claims)
+	if bound == "" {
+		if strings.EqualFold(scheme, TokenTypeDPoP) {
+			return nil, fmt.Errorf("%w: token is not dpop bound", ErrorInvalidDPoPProof)
+		}
+		return claims, nil
+	}
+
+	if !strings.EqualFold(scheme, TokenTypeDPoP) || proof == "" {
+		return nil, ErrorDPoPProofRequired
+	}
+	jkt, err := s.ValidateDPoPProof(ctx, proof, method, path, token)
+	if err != nil {
+		return nil, err
+	}
+	if jkt != bound {
+		return nil, ErrorDPoPBindingMismatch
+	}
+	return claims, nil
+}
+
+// TokenType - the token_type to report for a token bound to the thumbprint
+func TokenType(jkt string) string {
+	if jkt != "" {
+		return TokenTypeDPoP
+	}
+	return TokenTypeBearer
+}
+
+// ConfirmationThumbprint - reads cnf.jkt from the claims of a bound token
+func ConfirmationThumbprint(claims map[string]interface{}) string {
+	cnf, ok := claims["cnf"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	jkt, _ := cnf["jkt"].(string)
+	return jkt
+}
+
+// externalURL - the URL clients use to reach path on this service
+func (s *Service) externalURL(path string) string {
+	return strings.TrimSuffix(s.Config.Issuer, "/") + path
+}
+
+// sameURL - compares two URLs the way RFC 9449 asks for, scheme and host
+// are case insensitive and query and fragment are ignored
+func sameURL(a string, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil || a == "" {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) &&
+		strings.EqualFold(ua.Host, ub.Host) &&
+		strings.TrimSuffix(ua.EscapedPath(), "/") == strings.TrimSuffix(ub.EscapedPath(), "/")
+}
@@ -0,0 +1,177 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"testing"
+	"time"
+)
+
+const dpopTestPath = "/userinfo"
+
+func newDPoPService(t *testing.T) *Service {
+	t.Helper()
+	svc, _ := newTestService(t, func(config *Config) {
+		config.DPoPProofMaxAge = time.Minute
+	})
+	return svc
+}
+
+// dpopProof - a proof for a GET of the test path signed by the client key,
+// change adjusts the header and claims before signing
+func dpopProof(t *testing.T, key SigningKey, change func(header map[string]interface{}, claims jwt.MapClaims)) string {
+	t.Helper()
+	token := jwt.NewWithClaims(key.Method(), jwt.MapClaims{
+		"htm": "GET",
+		"htu": "http://auth.test" + dpopTestPath,
+		"iat": time.Now().Unix(),
+		"jti": randomTestID(t),
+	})
+	token.Header["typ"] = dpopProofType
+	token.Header["jwk"] = publicJWK(key.PublicKey())
+	if change != nil {
+		change(token.Header, token.Claims.(jwt.MapClaims))
+	}
+	proof, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func randomTestID(t *testing.T) string {
+	t.Helper()
+	id, err := randomToken(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestValidateDPoPProof(t *testing.T) {
+	clientKey := newTestSigningKey(t)
+	jkt, err := thumbprint(publicJWK(clientKey.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken := "access-token"
+	sum := sha256.Sum256([]byte(accessToken))
+
+	tests := []struct {
+		name        string
+		change      func(header map[string]interface{}, claims jwt.MapClaims)
+		accessToken string
+		wantErr     error
+	}{
+		{name: "valid proof"},
+		{name: "host and scheme in another case", change: func(_ map[string]interface{}, c jwt.MapClaims) { c["htu"] = "HTTP://AUTH.TEST" + dpopTestPath }},
+		{name: "query is ignored", change: func(_ map[string]interface{}, c jwt.MapClaims) { c["htu"] = "http://auth.test" + dpopTestPath + "?a=b" }},
+		{name: "other method", change: func(_ map[string]interface{}, c jwt.MapClaims) { c["htm"] = "POST" }, wantErr: ErrorInvalidDPoPProof},
+		{name: "other path", change: func(_ map[string]interface{}, c jwt.MapClaims) { c["htu"] = "http://auth.test/oauth/token" }, wantErr: ErrorInvalidDPoPProof},
+		{name: "other host", change: func(_ map[string]interface{}, c jwt.MapClaims) { c["htu"] = "http://evil.test" + dpopTestPath }, wantErr: ErrorInvalidDPoPProof},
+		{name: "too old", change: func(_ map[string]interface{}, c jwt.MapClaims) { c["iat"] = time.Now().Add(-time.Hour).Unix() }, wantErr: ErrorInvalidDPoPProof},
+		{name: "from the future", change: func(_ map[string]interface{}, c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }, wantErr: ErrorInvalidDPoPProof},
+		{name: "no jti", change: func(_ map[string]interface{}, c jwt.MapClaims) { delete(c, "jti") }, wantErr: ErrorInvalidDPoPProof},
+		{name: "other typ", change: func(h map[string]interface{}, _ jwt.MapClaims) { h["typ"] = "JWT" }, wantErr: ErrorInvalidDPoPProof},
+		{name: "no jwk", change: func(h map[string]interface{}, _ jwt.MapClaims) { delete(h, "jwk") }, wantErr: ErrorInvalidDPoPProof},
+		{name: "jwk of another key", change: func(h map[string]interface{}, _ jwt.MapClaims) { h["jwk"] = newTestSigningKey(t).JWK() }, wantErr: ErrorInvalidDPoPProof},
+		{
+			name:        "matching ath",
+			change:      func(_ map[string]interface{}, c jwt.MapClaims) { c["ath"] = encodeSegment(sum[:]) },
+			accessToken: accessToken,
+		},
+		{name: "missing ath", accessToken: accessToken, wantErr: ErrorInvalidDPoPProof},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := newDPoPService(t)
+			got, err := svc.ValidateDPoPProof(context.Background(), dpopProof(t, clientKey, tt.change), "GET", dpopTestPath, tt.accessToken)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got != jkt {
+				t.Errorf("thumbprint = %s, want %s", got, jkt)
+			}
+		})
+	}
+}
+
+func TestDPoPProofReplay(t *testing.T) {
+	svc := newDPoPService(t)
+	ctx := context.Background()
+	clientKey := newTestSigningKey(t)
+	proof := dpopProof(t, clientKey, nil)
+
+	if _, err := svc.ValidateDPoPProof(ctx, proof, "GET", dpopTestPath, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ValidateDPoPProof(ctx, proof, "GET", dpopTestPath, ""); !errors.Is(err, ErrorDPoPProofReplayed) {
+		t.Errorf("replayed proof: got %v, want %v", err, ErrorDPoPProofReplayed)
+	}
+	// the jti only has to be unique per key
+	other := dpopProof(t, newTestSigningKey(t), func(_ map[string]interface{}, c jwt.MapClaims) {
+		c["jti"] = claimOf(t, proof, "jti")
+	})
+	if _, err := svc.ValidateDPoPProof(ctx, other, "GET", dpopTestPath, ""); err != nil {
+		t.Errorf("the jti of another key was taken as a replay: %v", err)
+	}
+}
+
+func claimOf(t *testing.T, token string, name string) interface{} {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims[name]
+}
+
+func TestValidateBoundToken(t *testing.T) {
+	svc := newDPoPService(t)
+	ctx := context.Background()
+	clientKey := newTestSigningKey(t)
+	jkt, err := thumbprint(publicJWK(clientKey.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr := User{ID: "1", Email: "jane@example.com"}
+	bound, err := svc.GenerateToken(ctx, usr, jkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer, err := svc.GenerateToken(ctx, usr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofFor := func(key SigningKey, token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return dpopProof(t, key, func(_ map[string]interface{}, c jwt.MapClaims) {
+			c["ath"] = encodeSegment(sum[:])
+		})
+	}
+
+	tests := []struct {
+		name    string
+		scheme  string
+		token   string
+		proof   string
+		wantErr error
+	}{
+		{name: "bound token with a proof of its key", scheme: TokenTypeDPoP, token: bound, proof: proofFor(clientKey, bound)},
+		{name: "bound token as bearer", scheme: TokenTypeBearer, token: bound, wantErr: ErrorDPoPProofRequired},
+		{name: "bound token without a proof", scheme: TokenTypeDPoP, token: bound, wantErr: ErrorDPoPProofRequired},
+		{name: "bound token with a proof of another key", scheme: TokenTypeDPoP, token: bound, proof: proofFor(newTestSigningKey(t), bound), wantErr: ErrorDPoPBindingMismatch},
+		{name: "bearer token", scheme: TokenTypeBearer, token: bearer},
+		{name: "bearer token as dpop", scheme: TokenTypeDPoP, token: bearer, proof: proofFor(clientKey, bearer), wantErr: ErrorInvalidDPoPProof},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.ValidateBoundToken(ctx, tt.scheme, tt.token, tt.proof, "GET", dpopTestPath)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
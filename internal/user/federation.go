@@ -0,0 +1,328 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	federatedLoginTTL    = time.Minute * 10
+	federatedStateLength = 32
+	federatedNonceLength = 16
+	// codeVerifierLength gives a 43 character PKCE code verifier
+	codeVerifierLength = 32
+	// federatedPasswordLength is the length of the random password of users
+	// created on their first federated sign in, nobody knows it
+	federatedPasswordLength = 32
+
+	AttributeEmail         = "email"
+	AttributeEmailVerified = "email_verified"
+)
+
+var (
+	ErrorUnknownProvider       = errors.New("unknown identity provider")
+	ErrorFederatedLoginState   = errors.New("sign in state is unknown or expired")
+	ErrorEmailDomainNotAllowed = errors.New("email domain is not allowed for this identity provider")
+	ErrorEmailNotVerified      = errors.New("identity provider did not verify the email")
+	ErrorFederatedEmailExists  = errors.New("an account with this email already exists, sign in with your password")
+)
+
+// defaultClaimMapping - where the user attributes are read from in an
+// upstream ID token unless the provider maps them differently
+var defaultClaimMapping = map[string]string{
+	AttributeEmail:         "email",
+	AttributeEmailVerified: "email_verified",
+}
+
+// FederatedProvider - an upstream OpenID Connect provider users can sign in with
+type FederatedProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// ClaimMapping maps user attributes to the upstream claims they are read
+	// from, e.g. email to upn for some Microsoft tenants
+	ClaimMapping map[string]string
+	// AllowedDomains limits sign in to emails of these domains, empty allows any
+	AllowedDomains []string
+	// RequireVerifiedEmail rejects accounts whose email the provider did not verify
+	RequireVerifiedEmail bool
+}
+
+// FederatedIdentity - links an account at an upstream provider to a user
+type FederatedIdentity struct {
+	Provider string
+	Subject  string
+	UserID   string
+}
+
+// FederatedLogin - server side state of a sign in that was sent upstream,
+// only the hash of the state is stored
+type FederatedLogin struct {
+	StateHash string
+	Provider  string
+	// Nonce is the ID of the AuthnRequest for SAML sign ins
+	Nonce        string
+	CodeVerifier string
+	// Request is the authorization request the sign in started from, it is
+	// empty when the sign in did not come from /oauth/authorize
+	Request   AuthorizationRequest
+	ExpiresAt time.Time
+}
+
+type FederationStore interface {
+	GetFederatedIdentity(ctx context.Context, provider string, subject string) (FederatedIdentity, error)
+	// HasFederatedIdentity tells whether the user is linked to an account at
+	// the provider
+	HasFederatedIdentity(ctx context.Context, userID string, provider string) (bool, error)
+	CreateFederatedIdentity(context.Context, FederatedIdentity) error
+	CreateFederatedLogin(context.Context, FederatedLogin) error
+	// ConsumeFederatedLogin deletes the state and returns it
+	ConsumeFederatedLogin(ctx context.Context, stateHash string) (FederatedLogin, error)
+	DeleteExpiredFederatedLogins(ctx context.Context) (int64, error)
+}
+
+func newUpstreams(providers []FederatedProvider) map[string]*upstream {
+	client := &http.Client{Timeout: time.Second * 10}
+	upstreams := map[string]*upstream{}
+	for _, provider := range providers {
+		upstreams[provider.Name] = newUpstream(provider, client)
+	}
+	return upstreams
+}
+
+// FederatedProviders - names of the providers users can sign in with
+func (s *Service) FederatedProviders() []string {
+	var names []string
+	for _, provider := range s.Config.FederatedProviders {
+		names = append(names, provider.Name)
+	}
+	return names
+}
+
+// StartFederatedLogin - returns the URL that sends the user to sign in at the
+// provider, along with the state the callback has to come back with. The
+// authorization request is resumed once the user is back, it is empty when
+// the user signs in directly.
+func (s *Service) StartFederatedLogin(ctx context.Context, provider string, req AuthorizationRequest) (string, string, error) {
+	up, ok := s.upstreams[provider]
+	if !ok {
+		return "", "", ErrorUnknownProvider
+	}
+	metadata, err := up.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken(federatedStateLength)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(federatedNonceLength)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken(codeVerifierLength)
+	if err != nil {
+		return "", "", err
+	}
+	err = s.Store.CreateFederatedLogin(ctx, FederatedLogin{
+		StateHash:    hashToken(state),
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		Request:      req,
+		ExpiresAt:    time.Now().Add(federatedLoginTTL),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("could not store sign in state: %w", err)
+	}
+
+	target, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: invalid authorization endpoint", ErrorUpstreamRequest)
+	}
+	query := target.Query()
+	query.Set("response_type", ResponseTypeCode)
+	query.Set("client_id", up.config.ClientID)
+	query.Set("redirect_uri", s.federatedCallbackURL(provider))
+	query.Set("scope", strings.Join(up.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge(verifier))
+	query.Set("code_challenge_method", CodeChallengeMethodS256)
+	target.RawQuery = query.Encode()
+	return target.String(), state, nil
+}
+
+// CompleteFederatedLogin - handles the user coming back from the provider,
+// redeems the code, validates the ID token and returns the local user, who
+// is created on their first sign in. The authorization request the sign in
+// started from is returned so it can be resumed.
+func (s *Service) CompleteFederatedLogin(ctx context.Context, provider string, state string, code string) (User, AuthorizationRequest, error) {
+	login, err := s.Store.ConsumeFederatedLogin(ctx, hashToken(state))
+	if err != nil || login.Provider != provider || time.Now().After(login.ExpiresAt) {
+		return User{}, AuthorizationRequest{}, ErrorFederatedLoginState
+	}
+	up, ok := s.upstreams[provider]
+	if !ok {
+		return User{}, AuthorizationRequest{}, ErrorUnknownProvider
+	}
+	metadata, err := up.discover(ctx)
+	if err != nil {
+		return User{}, AuthorizationRequest{}, err
+	}
+	idToken, err := up.exchangeCode(ctx, metadata.TokenEndpoint, code, s.federatedCallbackURL(provider), login.CodeVerifier)
+	if err != nil {
+		return User{}, AuthorizationRequest{}, err
+	}
+	claims, err := up.verifyIDToken(ctx, metadata, idToken, login.Nonce, s.Config.ClockSkew)
+	if err != nil {
+		return User{}, AuthorizationRequest{}, err
+	}
+
+	usr, err := s.federatedUser(ctx, up.config, claims)
+	if err != nil {
+		return User{}, AuthorizationRequest{}, err
+	}
+	return usr, login.Request, nil
+}
+
+// federatedUser - finds the user linked to the upstream account or creates
+// one. An existing user is only linked when both the provider and the user
+// verified the email, otherwise anyone could claim an account by its email
+// at any provider, or register the email first and wait for its owner to
+// sign in upstream.
+func (s *Service) federatedUser(ctx context.Context, provider FederatedProvider, claims map[string]interface{}) (User, error) {
+	subject, _ := claims["sub"].(string)
+	if identity, err := s.Store.GetFederatedIdentity(ctx, provider.Name, subject); err == nil {
+		return s.Store.GetUser(ctx, identity.UserID)
+	}
+
+	email, verified := mapAttributes(provider, claims)
+	if email == "" {
+		return User{}, fmt.Errorf("%w: no email in the id token", ErrorInvalidIDToken)
+	}
+	if !emailDomainAllowed(provider.AllowedDomains, email) {
+		return User{}, ErrorEmailDomainNotAllowed
+	}
+	if provider.RequireVerifiedEmail && !verified {
+		return User{}, ErrorEmailNotVerified
+	}
+
+	usr, err := s.Store.GetUserByEmail(ctx, email)
+	switch {
+	case errors.Is(err, ErrorUserNotFound):
+		password, err := randomToken(federatedPasswordLength)
+		if err != nil {
+			return User{}, err
+		}
+		usr, err = s.createUser(ctx, User{Email: email, Password: password, EmailVerified: verified})
+		if err != nil {
+			return User{}, err
+		}
+	case err != nil:
+		return User{}, fmt.Errorf("could not look up user %s: %w", email, err)
+	case !verified || !usr.EmailVerified:
+		return User{}, ErrorFederatedEmailExists
+	}
+
+	err = s.Store.CreateFederatedIdentity(ctx, FederatedIdentity{
+		Provider: provider.Name,
+		Subject:  subject,
+		UserID:   usr.ID,
+	})
+	if err != nil {
+		return User{}, fmt.Errorf("could not link %s account: %w", provider.Name, err)
+	}
+	return usr, nil
+}
+
+// linkedUser - returns the shadow user linked to the account at the provider
+// or creates it on the first sign in, for providers that do not say whether
+// they verified the email. A local account with the same email is therefore
+// never taken over. The roles of the user follow the provider.
+func (s *Service) linkedUser(ctx context.Context, provider string, subject string, email string, roles []string) (User, error) {
+	if identity, err := s.Store.GetFederatedIdentity(ctx, provider, subject); err == nil {
+		usr, err := s.Store.GetUser(ctx, identity.UserID)
+		if err != nil {
+			return User{}, err
+		}
+		if strings.Join(usr.Roles, " ") != strings.Join(roles, " ") {
+			if err := s.Store.UpdateUserRoles(ctx, usr.ID, roles); err != nil {
+				return User{}, fmt.Errorf("could not update roles of user %s: %w", usr.ID, err)
+			}
+			usr.Roles = roles
+		}
+		return usr, nil
+	}
+
+	if existing, err := s.Store.GetUserByEmail(ctx, email); err == nil && existing.ID != "" {
+		return User{}, ErrorFederatedEmailExists
+	}
+	password, err := randomToken(federatedPasswordLength)
+	if err != nil {
+		return User{}, err
+	}
+	usr, err := s.createUser(ctx, User{Email: email, Password: password, Roles: roles})
+	if err != nil {
+		return User{}, err
+	}
+	err = s.Store.CreateFederatedIdentity(ctx, FederatedIdentity{
+		Provider: provider,
+		Subject:  subject,
+		UserID:   usr.ID,
+	})
+	if err != nil {
+		return User{}, fmt.Errorf("could not link %s account: %w", provider, err)
+	}
+	return usr, nil
+}
+
+func (s *Service) federatedCallbackURL(provider string) string {
+	return s.externalURL("/auth/federated/" + url.PathEscape(provider) + "/callback")
+}
+
+// mapAttributes - reads the email and whether it is verified from the
+// upstream claims following the claim mapping of the provider
+func mapAttributes(provider FederatedProvider, claims map[string]interface{}) (string, bool) {
+	claimFor := func(attribute string) string {
+		if claim, ok := provider.ClaimMapping[attribute]; ok {
+			return claim
+		}
+		return defaultClaimMapping[attribute]
+	}
+	email, _ := claims[claimFor(AttributeEmail)].(string)
+	var verified bool
+	switch v := claims[claimFor(AttributeEmailVerified)].(type) {
+	case bool:
+		verified = v
+	case string:
+		// some providers send booleans as strings
+		verified = v == "true"
+	}
+	return strings.TrimSpace(email), verified
+}
+
+func emailDomainAllowed(domains []string, email string) bool {
+	if len(domains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range domains {
+		if strings.ToLower(allowed) == domain {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,327 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const (
+	fakeClientID     = "meathub-auth"
+	fakeClientSecret = "secret"
+	fakeCode         = "upstream-code"
+)
+
+// fakeIssuer - an OpenID Connect provider serving a discovery document, its
+// keys and a token endpoint that redeems fakeCode for an ID token
+type fakeIssuer struct {
+	*httptest.Server
+	key SigningKey
+	// nonce and challenge come from the authorization request of the sign in
+	nonce     string
+	challenge string
+	// claims edits the ID token claims before they are signed
+	claims func(jwt.MapClaims)
+	// signWith signs the ID token instead of key when set
+	signWith *SigningKey
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := NewSigningKey(AlgorithmES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &fakeIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(upstreamMetadata{
+			Issuer:                issuer.URL,
+			AuthorizationEndpoint: issuer.URL + "/authorize",
+			TokenEndpoint:         issuer.URL + "/token",
+			JWKSURI:               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(JWKS{Keys: []JWK{key.JWK()}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// token - redeems the code for a client that authenticates and sends the
+// PKCE verifier of the sign in
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, _ := r.BasicAuth()
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if clientID != fakeClientID || secret != fakeClientSecret ||
+		r.PostFormValue("code") != fakeCode ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != f.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            f.URL,
+		"aud":            fakeClientID,
+		"sub":            "upstream-1",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          f.nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+	}
+	if f.claims != nil {
+		f.claims(claims)
+	}
+	key := f.key
+	if f.signWith != nil {
+		key = *f.signWith
+	}
+	token := jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	idToken, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "upstream-access-token"})
+}
+
+// newFederatedService - a service with the fake issuer configured as the
+// fake provider
+func newFederatedService(t *testing.T, issuer *fakeIssuer, configure func(*FederatedProvider)) (*Service, *memoryStore) {
+	t.Helper()
+	return newTestService(t, func(config *Config) {
+		provider := FederatedProvider{
+			Name:                 "fake",
+			Issuer:               issuer.URL,
+			ClientID:             fakeClientID,
+			ClientSecret:         fakeClientSecret,
+			Scopes:               []string{"openid", "email"},
+			RequireVerifiedEmail: true,
+		}
+		if configure != nil {
+			configure(&provider)
+		}
+		config.FederatedProviders = []FederatedProvider{provider}
+	})
+}
+
+// startLogin - starts a sign in and passes the nonce and code challenge of the
+// authorization request to the issuer like a browser would
+func startLogin(t *testing.T, svc *Service, issuer *fakeIssuer) string {
+	t.Helper()
+	target, state, err := svc.StartFederatedLogin(context.Background(), "fake", AuthorizationRequest{})
+	if err != nil {
+		t.Fatalf("could not start sign in: %v", err)
+	}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("state") != state || query.Get("code_challenge_method") != CodeChallengeMethodS256 {
+		t.Fatalf("authorization request %s does not carry the state and PKCE challenge", target)
+	}
+	if want := "http://auth.test/auth/federated/fake/callback"; query.Get("redirect_uri") != want {
+		t.Fatalf("redirect_uri = %q, want %q", query.Get("redirect_uri"), want)
+	}
+	issuer.nonce = query.Get("nonce")
+	issuer.challenge = query.Get("code_challenge")
+	return state
+}
+
+func TestCompleteFederatedLoginCreatesUser(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	svc, store := newFederatedService(t, issuer, nil)
+	ctx := context.Background()
+
+	usr, _, err := svc.CompleteFederatedLogin(ctx, "fake", startLogin(t, svc, issuer), fakeCode)
+	if err != nil {
+		t.Fatalf("sign in failed: %v", err)
+	}
+	if usr.Email != "jane@example.com" || !usr.EmailVerified {
+		t.Errorf("created user %+v, want a verified jane@example.com", usr)
+	}
+	if identity, err := store.GetFederatedIdentity(ctx, "fake", "upstream-1"); err != nil || identity.UserID != usr.ID {
+		t.Errorf("identity %+v, %v, want a link to user %s", identity, err, usr.ID)
+	}
+
+	again, _, err := svc.CompleteFederatedLogin(ctx, "fake", startLogin(t, svc, issuer), fakeCode)
+	if err != nil {
+		t.Fatalf("second sign in failed: %v", err)
+	}
+	if again.ID != usr.ID || len(store.users) != 1 {
+		t.Errorf("second sign in returned user %s with %d users stored, want user %s only", again.ID, len(store.users), usr.ID)
+	}
+}
+
+func TestCompleteFederatedLoginState(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	svc, store := newFederatedService(t, issuer, nil)
+	ctx := context.Background()
+
+	if _, _, err := svc.CompleteFederatedLogin(ctx, "fake", "unknown-state", fakeCode); !errors.Is(err, ErrorFederatedLoginState) {
+		t.Errorf("unknown state: got %v, want %v", err, ErrorFederatedLoginState)
+	}
+
+	state := startLogin(t, svc, issuer)
+	if _, _, err := svc.CompleteFederatedLogin(ctx, "fake", state, fakeCode); err != nil {
+		t.Fatalf("sign in failed: %v", err)
+	}
+	if _, _, err := svc.CompleteFederatedLogin(ctx, "fake", state, fakeCode); !errors.Is(err, ErrorFederatedLoginState) {
+		t.Errorf("replayed state: got %v, want %v", err, ErrorFederatedLoginState)
+	}
+
+	state = startLogin(t, svc, issuer)
+	login := store.logins[hashToken(state)]
+	login.ExpiresAt = time.Now().Add(-time.Second)
+	store.logins[hashToken(state)] = login
+	if _, _, err := svc.CompleteFederatedLogin(ctx, "fake", state, fakeCode); !errors.Is(err, ErrorFederatedLoginState) {
+		t.Errorf("expired state: got %v, want %v", err, ErrorFederatedLoginState)
+	}
+}
+
+func TestCompleteFederatedLoginIDToken(t *testing.T) {
+	otherKey, err := NewSigningKey(AlgorithmES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedKey := otherKey
+	tests := []struct {
+		name     string
+		claims   func(jwt.MapClaims)
+		signWith *SigningKey
+	}{
+		{name: "nonce of another sign in", claims: func(c jwt.MapClaims) { c["nonce"] = "another-nonce" }},
+		{name: "missing nonce", claims: func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{name: "other issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{name: "other audience", claims: func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "issued in the future", claims: func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{name: "missing subject", claims: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "unknown key", signWith: &otherKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			svc, store := newFederatedService(t, issuer, nil)
+			issuer.claims = tt.claims
+			issuer.signWith = tt.signWith
+
+			_, _, err := svc.CompleteFederatedLogin(context.Background(), "fake", startLogin(t, svc, issuer), fakeCode)
+			if !errors.Is(err, ErrorInvalidIDToken) {
+				t.Errorf("got %v, want %v", err, ErrorInvalidIDToken)
+			}
+			if len(store.users) != 0 {
+				t.Errorf("a rejected ID token created %d users", len(store.users))
+			}
+		})
+	}
+
+	t.Run("forged signature", func(t *testing.T) {
+		issuer := newFakeIssuer(t)
+		svc, _ := newFederatedService(t, issuer, nil)
+		// signed by another key under the kid of the published one
+		forgedKey.ID = issuer.key.ID
+		issuer.signWith = &forgedKey
+
+		_, _, err := svc.CompleteFederatedLogin(context.Background(), "fake", startLogin(t, svc, issuer), fakeCode)
+		if !errors.Is(err, ErrorInvalidIDToken) {
+			t.Errorf("got %v, want %v", err, ErrorInvalidIDToken)
+		}
+	})
+}
+
+func TestCompleteFederatedLoginUnverifiedEmail(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	svc, store := newFederatedService(t, issuer, nil)
+	issuer.claims = func(c jwt.MapClaims) { c["email_verified"] = false }
+
+	_, _, err := svc.CompleteFederatedLogin(context.Background(), "fake", startLogin(t, svc, issuer), fakeCode)
+	if !errors.Is(err, ErrorEmailNotVerified) {
+		t.Errorf("got %v, want %v", err, ErrorEmailNotVerified)
+	}
+	if len(store.users) != 0 || len(store.identities) != 0 {
+		t.Errorf("an unverified email created %d users and %d identities", len(store.users), len(store.identities))
+	}
+}
+
+func TestCompleteFederatedLoginLinksExistingUser(t *testing.T) {
+	tests := []struct {
+		name          string
+		verified      bool
+		localVerified bool
+		wantErr       error
+	}{
+		{name: "email verified on both sides links the account", verified: true, localVerified: true},
+		{name: "unverified email does not take over the account", verified: false, localVerified: true, wantErr: ErrorFederatedEmailExists},
+		{name: "unverified local account is not linked", verified: true, localVerified: false, wantErr: ErrorFederatedEmailExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newFakeIssuer(t)
+			svc, store := newFederatedService(t, issuer, func(provider *FederatedProvider) {
+				provider.RequireVerifiedEmail = false
+			})
+			ctx := context.Background()
+			existing, err := store.PostUser(ctx, User{Email: "jane@example.com", Password: "hash", EmailVerified: tt.localVerified})
+			if err != nil {
+				t.Fatal(err)
+			}
+			issuer.claims = func(c jwt.MapClaims) { c["email_verified"] = tt.verified }
+
+			usr, _, err := svc.CompleteFederatedLogin(ctx, "fake", startLogin(t, svc, issuer), fakeCode)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(store.identities) != 0 {
+					t.Errorf("the account was linked anyway")
+				}
+				return
+			}
+			if usr.ID != existing.ID || len(store.users) != 1 {
+				t.Errorf("signed in as user %s with %d users stored, want the existing user %s", usr.ID, len(store.users), existing.ID)
+			}
+			if identity, err := store.GetFederatedIdentity(ctx, "fake", "upstream-1"); err != nil || identity.UserID != existing.ID {
+				t.Errorf("identity %+v, %v, want a link to user %s", identity, err, existing.ID)
+			}
+		})
+	}
+}
+
+// unavailableEmailStore - a store whose email lookups fail
+type unavailableEmailStore struct {
+	*memoryStore
+}
+
+func (unavailableEmailStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	return User{}, errors.New("connection refused")
+}
+
+func TestCompleteFederatedLoginLookupFailure(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	svc, store := newFederatedService(t, issuer, nil)
+	svc.Store = unavailableEmailStore{store}
+
+	_, _, err := svc.CompleteFederatedLogin(context.Background(), "fake", startLogin(t, svc, issuer), fakeCode)
+	if err == nil {
+		t.Fatal("signed in although the user could not be looked up")
+	}
+	if len(store.users) != 0 || len(store.identities) != 0 {
+		t.Errorf("a failed lookup created %d users and %d identities", len(store.users), len(store.identities))
+	}
+}
@@ -0,0 +1,98 @@
+package user
+
+import (
+	"fmt"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"strings"
+)
+
+const (
+	TokenFormatJWT    = "jwt"
+	TokenFormatPASETO = "paseto"
+)
+
+// TokenFormat - turns claims into a signed token and back. The registered
+// claims are validated by the service so every format behaves the same.
+type TokenFormat interface {
+	// Sign signs the claims with the active key of the keyring
+	Sign(claims map[string]interface{}, typ string) (string, error)
+	// Verify checks the signature and returns the claims of the token
+	Verify(token string) (map[string]interface{}, error)
+	// Peek returns the claims without verifying anything, false means the
+	// token is not in this format at all
+	Peek(token string) (map[string]interface{}, bool)
+}
+
+// NewTokenFormat - returns the token format with the given name
+func NewTokenFormat(name string, keys *Keyring) (TokenFormat, error) {
+	switch name {
+	case TokenFormatJWT:
+		return &jwtFormat{keys: keys}, nil
+	case TokenFormatPASETO:
+		if keys.Active().Algorithm != AlgorithmEdDSA {
+			return nil, fmt.Errorf("%w: paseto v4.public tokens need %s keys", ErrorUnsupportedAlgorithm, AlgorithmEdDSA)
+		}
+		return &pasetoFormat{keys: keys}, nil
+	}
+	return nil, fmt.Errorf("unsupported token format %q", name)
+}
+
+type jwtFormat struct {
+	keys *Keyring
+}
+
+// Sign - signs the claims with the active key and stamps its kid and the
+// token type on the header
+func (f *jwtFormat) Sign(claims map[string]interface{}, typ string) (string, error) {
+	key := f.keys.Active()
+	token := jwt.NewWithClaims(key.Method(), jwt.MapClaims(claims))
+	token.Header["kid"] = key.ID
+	token.Header["typ"] = typ
+
+	tokenString, err := token.SignedString(key.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+func (f *jwtFormat) Verify(tokenString string) (map[string]interface{}, error) {
+	// the registered claims are checked by the service, jwt only knows how
+	// to check them without leeway
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, ErrorUnknownKey
+		}
+		key, ok := f.keys.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrorUnknownKey, kid)
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, ErrorInvalidToken
+		}
+		return key.PublicKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrorInvalidToken
+	}
+	return claims, nil
+}
+
+func (f *jwtFormat) Peek(tokenString string) (map[string]interface{}, bool) {
+	if strings.Count(tokenString, ".") != 2 {
+		return nil, false
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, claims); err != nil {
+		return nil, false
+	}
+	return claims, true
+}
@@ -0,0 +1,45 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+)
+
+var (
+	ErrorInvalidClient = errors.New("invalid client credentials")
+)
+
+// AuthenticateIntrospectionClient - checks the credentials of a caller of the
+// introspection endpoint, either a client from the configuration or a
+// confidential client from the clients table
+func (s *Service) AuthenticateIntrospectionClient(ctx context.Context, clientID string, secret string) error {
+	if expected, ok := s.Config.IntrospectionClients[clientID]; ok && expected != "" {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) != 1 {
+			return ErrorInvalidClient
+		}
+		return nil
+	}
+	client, err := s.AuthenticateClient(ctx, clientID, secret)
+	if err != nil || !client.Confidential() {
+		return ErrorInvalidClient
+	}
+	return nil
+}
+
+// LookupRefreshToken - returns the stored record of a refresh token that can
+// still be used
+func (s *Service) LookupRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	if use, ok := s.tokenUseOf(token); ok {
+		return RefreshToken{}, &TokenTypeError{Expected: TokenUseRefresh, Actual: use}
+	}
+	stored, err := s.Store.GetRefreshToken(ctx, hashToken(token))
+	if err != nil {
+		return RefreshToken{}, ErrorInvalidRefreshToken
+	}
+	if stored.UsedAt != nil || stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return RefreshToken{}, ErrorInvalidRefreshToken
+	}
+	return stored, nil
+}
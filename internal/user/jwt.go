@@ -1,40 +1,119 @@
 package user
 
 import (
-	jwt "github.com/golang-jwt/jwt/v4"
+	"context"
+	"errors"
 	"time"
 )
 
-func (s *Service) GenerateToken(user User) (string, error) {
+var (
+	ErrorInvalidToken = errors.New("could not validate auth token")
+	ErrorUnknownKey   = errors.New("token signed with unknown key")
+)
 
-	token := jwt.New(jwt.SigningMethodHS256)
+// GenerateToken - issues an access token for the user, the token is bound to
+// the DPoP key thumbprint when one is given
+func (s *Service) GenerateToken(ctx context.Context, user User, jkt string) (string, error) {
+	claims, err := s.userAccessTokenClaims(user, "", "", jkt)
+	if err != nil {
+		return "", err
+	}
 
-	claims := token.Claims.(jwt.MapClaims)
+	return s.issueAccessToken(ctx, claims)
+}
+
+// userAccessTokenClaims - claims of an access token issued to a user, through
+// an OAuth client when clientID is set
+func (s *Service) userAccessTokenClaims(user User, clientID string, scope string, jkt string) (map[string]interface{}, error) {
+	claims, err := s.accessTokenClaims(user.ID, s.Config.DefaultAudience())
+	if err != nil {
+		return nil, err
+	}
 	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
-	claims["iat"] = time.Now().Unix()
-	claims["sub"] = user.ID
+	if len(user.Roles) > 0 {
+		claims["roles"] = user.Roles
+	}
+	if clientID != "" {
+		claims["client_id"] = clientID
+	}
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	if jkt != "" {
+		claims["cnf"] = map[string]interface{}{"jkt": jkt}
+	}
+	return claims, nil
+}
 
-	tokenString, err := token.SignedString([]byte("missionimpossible"))
+// accessTokenClaims - registered claims of an access token for the audience
+func (s *Service) accessTokenClaims(subject string, audience string) (map[string]interface{}, error) {
+	jti, err := randomToken(jtiLength)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	now := time.Now()
 
-	return tokenString, nil
+	claims := map[string]interface{}{}
+	claims["iss"] = s.Config.Issuer
+	claims["aud"] = audience
+	claims["exp"] = now.Add(s.Config.AccessTokenTTLFor(audience)).Unix()
+	claims["nbf"] = now.Unix()
+	claims["iat"] = now.Unix()
+	claims["sub"] = subject
+	claims["jti"] = jti
+	claims["token_use"] = TokenUseAccess
+	return claims, nil
 }
-func (s *Service) GenerateRefreshToken(user User) (string, error) {
 
-	token := jwt.New(jwt.SigningMethodHS256)
+// signToken - signs the claims in the configured token format
+func (s *Service) signToken(claims map[string]interface{}, typ string) (string, error) {
+	return s.Format.Sign(claims, typ)
+}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["exp"] = time.Now().Add(time.Hour * 24 * 7).Unix()
-	claims["iat"] = time.Now().Unix()
-	claims["sub"] = user.ID
+// ValidateToken - verifies the signature and expiry of an access token issued
+// by this service, or looks it up when it is opaque, and makes sure it has
+// not been revoked
+func (s *Service) ValidateToken(ctx context.Context, tokenString string) (map[string]interface{}, error) {
+	claims, err := s.resolveToken(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTokenUse(claims, TokenUseAccess); err != nil {
+		return nil, err
+	}
+	if err := s.checkRevoked(ctx, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
 
-	tokenString, err := token.SignedString([]byte("missionimpossible"))
+// parseToken - verifies the signature and registered claims of a token signed by the keyring
+func (s *Service) parseToken(tokenString string) (map[string]interface{}, error) {
+	claims, err := s.Format.Verify(tokenString)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	if err := s.Config.validateClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
 
-	return tokenString, nil
+// JWKS - returns the public keys other services can verify our tokens with
+func (s *Service) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range s.Keys.Keys() {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	return jwks
+}
+
+// RotateSigningKey - replaces the active signing key, the previous key keeps
+// verifying tokens for the configured grace period
+func (s *Service) RotateSigningKey(ctx context.Context) (JWK, error) {
+	key, err := s.Keys.Rotate(ctx)
+	if err != nil {
+		return JWK{}, err
+	}
+	return key.JWK(), nil
 }
@@ -0,0 +1,338 @@
+package user
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// keyReloadInterval limits how often a token signed with an unknown kid
+	// makes the keyring look for keys rotated by other replicas
+	keyReloadInterval = time.Second * 10
+	// keyEncryptionKeyLength is the length of the AES-256 key encryption key
+	keyEncryptionKeyLength = 32
+)
+
+var (
+	ErrorSigningKeyDecryption = errors.New("could not decrypt stored signing key")
+)
+
+// StoredSigningKey - a rotated signing key as it is persisted, EncryptedKey
+// is the PEM encoded private key sealed with the key encryption key
+type StoredSigningKey struct {
+	ID           string
+	Algorithm    string
+	EncryptedKey []byte
+	CreatedAt    time.Time
+}
+
+type SigningKeyStore interface {
+	CreateSigningKey(context.Context, StoredSigningKey) error
+	// GetSigningKeys returns, oldest first, the keys of the algorithm created
+	// after the time along with the last one created before it
+	GetSigningKeys(ctx context.Context, algorithm string, after time.Time) ([]StoredSigningKey, error)
+	// LockSigningKeyRotation takes the lock replicas share to rotate one at
+	// a time, locked is false when another replica holds it
+	LockSigningKeyRotation(ctx context.Context) (unlock func(), locked bool, err error)
+}
+
+// Keyring - holds the active signing key along with the keys it replaced.
+// Retired keys keep verifying tokens until their grace period runs out so
+// a rotation does not invalidate tokens that are already out there. Rotated
+// keys are encrypted and persisted so they survive restarts and are shared
+// by replicas, the configured key is active until the first rotation.
+type Keyring struct {
+	mu          sync.RWMutex
+	algorithm   string
+	gracePeriod time.Duration
+	store       SigningKeyStore
+	encryption  cipher.AEAD
+	configured  SigningKey
+	active      SigningKey
+	activeSince time.Time
+	previous    []retiredKey
+	loadedAt    time.Time
+}
+
+type retiredKey struct {
+	key       SigningKey
+	retiredAt time.Time
+}
+
+// NewKeyring - a keyring starting with the configured key, rotated keys are
+// only kept in memory when store or encryption is nil
+func NewKeyring(active SigningKey, gracePeriod time.Duration, store SigningKeyStore, encryption cipher.AEAD) *Keyring {
+	if encryption == nil {
+		store = nil
+	}
+	return &Keyring{
+		algorithm:   active.Algorithm,
+		gracePeriod: gracePeriod,
+		store:       store,
+		encryption:  encryption,
+		configured:  active,
+		active:      active,
+	}
+}
+
+// newKeyEncryption - the cipher stored signing keys are sealed with, nil when
+// no key encryption key is configured
+func newKeyEncryption(config Config) (cipher.AEAD, error) {
+	if config.KeyEncryptionKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(config.KeyEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_KEY_ENCRYPTION_KEY is not base64 encoded: %w", err)
+	}
+	if len(key) != keyEncryptionKeyLength {
+		return nil, fmt.Errorf("JWT_KEY_ENCRYPTION_KEY must be %d bytes", keyEncryptionKeyLength)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal - encrypts the private key for storage, the kid and algorithm are
+// authenticated so a stored key can not be passed off as another one
+func (k *Keyring) seal(key SigningKey) ([]byte, error) {
+	encoded, err := key.MarshalPEM()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, k.encryption.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.encryption.Seal(nonce, nonce, encoded, storedKeyData(key.ID, key.Algorithm)), nil
+}
+
+// open - decrypts a stored key and checks it is the key its row says it is
+func (k *Keyring) open(row StoredSigningKey) (SigningKey, error) {
+	size := k.encryption.NonceSize()
+	if len(row.EncryptedKey) < size {
+		return SigningKey{}, ErrorSigningKeyDecryption
+	}
+	nonce, sealed := row.EncryptedKey[:size], row.EncryptedKey[size:]
+	encoded, err := k.encryption.Open(nil, nonce, sealed, storedKeyData(row.ID, row.Algorithm))
+	if err != nil {
+		return SigningKey{}, ErrorSigningKeyDecryption
+	}
+	key, err := ParseSigningKey(encoded, k.algorithm)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	if key.ID != row.ID {
+		return SigningKey{}, fmt.Errorf("stored key has kid %s", key.ID)
+	}
+	return key, nil
+}
+
+func storedKeyData(kid string, algorithm string) []byte {
+	return []byte(algorithm + " " + kid)
+}
+
+// Load - replaces the keys with the configured key followed by the keys
+// rotated since, the newest one becomes the active key
+func (k *Keyring) Load(ctx context.Context) error {
+	if k.store == nil {
+		return nil
+	}
+	now := time.Now()
+	stored, err := k.store.GetSigningKeys(ctx, k.algorithm, now.Add(-k.gracePeriod))
+	if err != nil {
+		return fmt.Errorf("could not load signing keys: %w", err)
+	}
+
+	active, activeSince := k.configured, time.Time{}
+	var retired []retiredKey
+	for _, row := range stored {
+		key, err := k.open(row)
+		if err != nil {
+			return fmt.Errorf("could not load signing key %s: %w", row.ID, err)
+		}
+		retired = append(retired, retiredKey{key: active, retiredAt: row.CreatedAt})
+		active, activeSince = key, row.CreatedAt
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active, k.activeSince = active, activeSince
+	k.previous = nil
+	for i := len(retired) - 1; i >= 0; i-- {
+		if k.inGracePeriod(retired[i], now) {
+			k.previous = append(k.previous, retired[i])
+		}
+	}
+	k.loadedAt = now
+	return nil
+}
+
+// Active - returns the key new tokens are signed with
+func (k *Keyring) Active() SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Key - looks up a key that may still be used for verification by its kid,
+// unknown kids may belong to a key another replica just rotated to
+func (k *Keyring) Key(kid string) (SigningKey, bool) {
+	if key, ok := k.key(kid); ok {
+		return key, true
+	}
+	if !k.reloadDue() {
+		return SigningKey{}, false
+	}
+	if err := k.Load(context.Background()); err != nil {
+		log.WithError(err).Error("could not reload signing keys")
+		return SigningKey{}, false
+	}
+	return k.key(kid)
+}
+
+func (k *Keyring) key(kid string) (SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.active.ID == kid {
+		return k.active, true
+	}
+	for _, retired := range k.previous {
+		if retired.key.ID == kid && k.inGracePeriod(retired, time.Now()) {
+			return retired.key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// reloadDue - reports whether the keys may be loaded again and claims the
+// reload so concurrent lookups do not all hit the store
+func (k *Keyring) reloadDue() bool {
+	if k.store == nil {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if time.Since(k.loadedAt) < keyReloadInterval {
+		return false
+	}
+	k.loadedAt = time.Now()
+	return true
+}
+
+// Keys - returns every key that may still be used for verification,
+// starting with the active one
+func (k *Keyring) Keys() []SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	now := time.Now()
+	keys := []SigningKey{k.active}
+	for _, retired := range k.previous {
+		if k.inGracePeriod(retired, now) {
+			keys = append(keys, retired.key)
+		}
+	}
+	return keys
+}
+
+// Rotate - generates a new active key and retires the current one, the key
+// is stored encrypted before it is used
+func (k *Keyring) Rotate(ctx context.Context) (SigningKey, error) {
+	key, err := NewSigningKey(k.algorithm)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	now := time.Now()
+	if k.store != nil {
+		sealed, err := k.seal(key)
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("could not encrypt signing key: %w", err)
+		}
+		err = k.store.CreateSigningKey(ctx, StoredSigningKey{
+			ID:           key.ID,
+			Algorithm:    key.Algorithm,
+			EncryptedKey: sealed,
+			CreatedAt:    now,
+		})
+		if err != nil {
+			return SigningKey{}, fmt.Errorf("could not store signing key: %w", err)
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	previous := []retiredKey{{key: k.active, retiredAt: now}}
+	for _, retired := range k.previous {
+		if k.inGracePeriod(retired, now) {
+			previous = append(previous, retired)
+		}
+	}
+	k.previous = previous
+	k.active, k.activeSince = key, now
+	return key, nil
+}
+
+// RunRotation - rotates the active key every interval until the context is
+// done
+func (k *Keyring) RunRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.rotateIfDue(ctx, interval)
+		}
+	}
+}
+
+// rotateIfDue - rotates when the active key is older than the interval. The
+// rotation lock is held while the keys are reloaded and rotated so replicas
+// skip the rotation when another one is rotating or already rotated.
+func (k *Keyring) rotateIfDue(ctx context.Context, interval time.Duration) {
+	if k.store != nil {
+		unlock, locked, err := k.store.LockSigningKeyRotation(ctx)
+		if err != nil {
+			log.WithError(err).Error("could not take the signing key rotation lock")
+			return
+		}
+		if !locked {
+			return
+		}
+		defer unlock()
+		if err := k.Load(ctx); err != nil {
+			log.WithError(err).Error("could not reload signing keys")
+			return
+		}
+	}
+	if time.Since(k.activeSinceTime()) < interval {
+		return
+	}
+	key, err := k.Rotate(ctx)
+	if err != nil {
+		log.WithError(err).Error("could not rotate signing key")
+		return
+	}
+	log.Infof("rotated signing key, new kid %s", key.ID)
+}
+
+func (k *Keyring) activeSinceTime() time.Time {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.activeSince
+}
+
+func (k *Keyring) inGracePeriod(retired retiredKey, now time.Time) bool {
+	return now.Sub(retired.retiredAt) < k.gracePeriod
+}
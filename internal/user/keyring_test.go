@@ -0,0 +1,254 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySigningKeys - a signing key store shared by the keyrings of a test
+// like the database is shared by replicas
+type memorySigningKeys struct {
+	mu     sync.Mutex
+	rows   []StoredSigningKey
+	locked bool
+}
+
+func (m *memorySigningKeys) CreateSigningKey(ctx context.Context, key StoredSigningKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rows = append(m.rows, key)
+	return nil
+}
+
+func (m *memorySigningKeys) GetSigningKeys(ctx context.Context, algorithm string, after time.Time) ([]StoredSigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	start := 0
+	for i, row := range m.rows {
+		if !row.CreatedAt.After(after) {
+			start = i
+		}
+	}
+	return append([]StoredSigningKey(nil), m.rows[start:]...), nil
+}
+
+func (m *memorySigningKeys) LockSigningKeyRotation(ctx context.Context) (func(), bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locked {
+		return nil, false, nil
+	}
+	m.locked = true
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.locked = false
+	}, true, nil
+}
+
+func newTestKeyEncryption(t *testing.T, secret string) cipher.AEAD {
+	t.Helper()
+	encryption, err := newKeyEncryption(Config{KeyEncryptionKey: base64.StdEncoding.EncodeToString([]byte(secret))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encryption
+}
+
+func newTestSigningKey(t *testing.T) SigningKey {
+	t.Helper()
+	key, err := NewSigningKey(AlgorithmES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func tokenKID(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestTokensVerifyAcrossRotation(t *testing.T) {
+	svc, _ := newTestService(t, nil)
+	ctx := context.Background()
+	usr := User{ID: "1", Email: "jane@example.com"}
+
+	before, err := svc.GenerateToken(ctx, usr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	retiredKID := tokenKID(t, before)
+	jwk, err := svc.RotateSigningKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwk.Kid == retiredKID {
+		t.Fatal("rotation kept the kid of the retired key")
+	}
+	after, err := svc.GenerateToken(ctx, usr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKID(t, after); kid != jwk.Kid {
+		t.Errorf("new token has kid %s, want the rotated key %s", kid, jwk.Kid)
+	}
+
+	keys := svc.JWKS().Keys
+	if len(keys) != 2 || keys[0].Kid != jwk.Kid || keys[1].Kid != retiredKID {
+		t.Errorf("jwks = %+v, want the active key followed by the retired one", keys)
+	}
+	for name, token := range map[string]string{"before": before, "after": after} {
+		if _, err := svc.parseToken(token); err != nil {
+			t.Errorf("token issued %s the rotation does not verify: %v", name, err)
+		}
+	}
+
+	svc.Keys.previous[0].retiredAt = time.Now().Add(-svc.Config.KeyGracePeriod)
+	if _, err := svc.parseToken(before); !errors.Is(err, ErrorUnknownKey) {
+		t.Errorf("token of a key past its grace period: got %v, want %v", err, ErrorUnknownKey)
+	}
+	if keys := svc.JWKS().Keys; len(keys) != 1 || keys[0].Kid != jwk.Kid {
+		t.Errorf("jwks = %+v, want only the active key", keys)
+	}
+}
+
+func TestTokenWithUnknownKIDIsRejected(t *testing.T) {
+	svc, _ := newTestService(t, nil)
+	other := newTestSigningKey(t)
+	token := jwt.NewWithClaims(other.Method(), jwt.MapClaims{"sub": "1"})
+	token.Header["kid"] = other.ID
+	forged, err := token.SignedString(other.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.parseToken(forged); !errors.Is(err, ErrorUnknownKey) {
+		t.Errorf("got %v, want %v", err, ErrorUnknownKey)
+	}
+}
+
+func TestKeyringSharesRotatedKeys(t *testing.T) {
+	ctx := context.Background()
+	configured := newTestSigningKey(t)
+	store := &memorySigningKeys{}
+	encryption := newTestKeyEncryption(t, "0123456789abcdef0123456789abcdef")
+	a := NewKeyring(configured, time.Hour, store, encryption)
+	b := NewKeyring(configured, time.Hour, store, encryption)
+	for _, k := range []*Keyring{a, b} {
+		if err := k.Load(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotated, err := a.Rotate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Key(rotated.ID); ok {
+		t.Fatal("the keys were reloaded before the reload interval passed")
+	}
+	b.loadedAt = time.Time{}
+	if _, ok := b.Key(rotated.ID); !ok {
+		t.Fatal("a replica did not pick up the rotated key")
+	}
+	if b.Active().ID != rotated.ID {
+		t.Errorf("replica signs with %s, want %s", b.Active().ID, rotated.ID)
+	}
+
+	restarted := NewKeyring(configured, time.Hour, store, encryption)
+	if err := restarted.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Active().ID != rotated.ID {
+		t.Errorf("restarted keyring signs with %s, want %s", restarted.Active().ID, rotated.ID)
+	}
+	if _, ok := restarted.Key(configured.ID); !ok {
+		t.Error("the retired configured key no longer verifies after a restart")
+	}
+}
+
+func TestKeyringEncryptsStoredKeys(t *testing.T) {
+	ctx := context.Background()
+	configured := newTestSigningKey(t)
+	store := &memorySigningKeys{}
+	k := NewKeyring(configured, time.Hour, store, newTestKeyEncryption(t, "0123456789abcdef0123456789abcdef"))
+	if _, err := k.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(store.rows[0].EncryptedKey, []byte("PRIVATE KEY")) {
+		t.Fatal("the rotated key was stored in plain text")
+	}
+
+	wrongKey := NewKeyring(configured, time.Hour, store, newTestKeyEncryption(t, "fedcba9876543210fedcba9876543210"))
+	if err := wrongKey.Load(ctx); !errors.Is(err, ErrorSigningKeyDecryption) {
+		t.Errorf("load with another key encryption key: got %v, want %v", err, ErrorSigningKeyDecryption)
+	}
+
+	store.rows[0].ID = configured.ID
+	if err := k.Load(ctx); !errors.Is(err, ErrorSigningKeyDecryption) {
+		t.Errorf("load of a row with a swapped kid: got %v, want %v", err, ErrorSigningKeyDecryption)
+	}
+}
+
+func TestKeyringWithoutEncryptionKeepsKeysInMemory(t *testing.T) {
+	store := &memorySigningKeys{}
+	k := NewKeyring(newTestSigningKey(t), time.Hour, store, nil)
+	if _, err := k.Rotate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.rows) != 0 {
+		t.Errorf("stored %d keys without a key encryption key", len(store.rows))
+	}
+}
+
+func TestRotateIfDue(t *testing.T) {
+	ctx := context.Background()
+	configured := newTestSigningKey(t)
+	store := &memorySigningKeys{}
+	encryption := newTestKeyEncryption(t, "0123456789abcdef0123456789abcdef")
+	a := NewKeyring(configured, time.Hour, store, encryption)
+	b := NewKeyring(configured, time.Hour, store, encryption)
+
+	unlock, _, _ := store.LockSigningKeyRotation(ctx)
+	a.rotateIfDue(ctx, time.Minute)
+	if len(store.rows) != 0 {
+		t.Fatal("rotated while another replica held the rotation lock")
+	}
+	unlock()
+
+	a.rotateIfDue(ctx, time.Minute)
+	b.rotateIfDue(ctx, time.Minute)
+	if len(store.rows) != 1 {
+		t.Fatalf("replicas rotated %d times in one interval, want once", len(store.rows))
+	}
+	if a.Active().ID != b.Active().ID {
+		t.Errorf("replicas sign with %s and %s", a.Active().ID, b.Active().ID)
+	}
+	if store.locked {
+		t.Error("the rotation lock was not released")
+	}
+}
+
+func TestNewServiceRequiresKeyEncryptionToRotate(t *testing.T) {
+	config := testConfig()
+	config.KeyRotationInterval = time.Hour
+	if _, err := NewService(newMemoryStore(), config); err == nil {
+		t.Error("scheduled rotation was accepted without a key encryption key")
+	}
+	config.KeyEncryptionKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	if _, err := NewService(newMemoryStore(), config); err != nil {
+		t.Errorf("scheduled rotation with a key encryption key: %v", err)
+	}
+}
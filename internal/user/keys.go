@@ -0,0 +1,302 @@
+package user
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"os"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+
+	rsaKeySize = 2048
+)
+
+var (
+	ErrorUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// SigningKey - private key used to sign tokens
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+}
+
+// JWK - public key in the RFC 7517 JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS - set of public keys served on /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Method - returns the jwt signing method matching the key algorithm
+func (k SigningKey) Method() jwt.SigningMethod {
+	return signingMethod(k.Algorithm)
+}
+
+// PublicKey - returns the public half of the signing key
+func (k SigningKey) PublicKey() crypto.PublicKey {
+	return k.PrivateKey.Public()
+}
+
+// JWK - returns the public key as a JWK
+func (k SigningKey) JWK() JWK {
+	jwk := publicJWK(k.PublicKey())
+	jwk.Use = "sig"
+	jwk.Kid = k.ID
+	jwk.Alg = k.Algorithm
+	return jwk
+}
+
+func signingMethod(alg string) jwt.SigningMethod {
+	switch alg {
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256
+	case AlgorithmES256:
+		return jwt.SigningMethodES256
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA
+	}
+	return nil
+}
+
+// NewSigningKey - generates a fresh private key for the given algorithm
+func NewSigningKey(alg string) (SigningKey, error) {
+	var (
+		privateKey crypto.Signer
+		err        error
+	)
+	switch alg {
+	case AlgorithmRS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, rsaKeySize)
+	case AlgorithmES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return SigningKey{}, fmt.Errorf("%w: %s", ErrorUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("could not generate %s key: %w", alg, err)
+	}
+	return newSigningKey(alg, privateKey)
+}
+
+// LoadSigningKey - reads a PEM encoded private key from disk
+func LoadSigningKey(path string, alg string) (SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("could not read signing key: %w", err)
+	}
+	key, err := ParseSigningKey(data, alg)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("signing key in %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// ParseSigningKey - decodes a PEM encoded PKCS #1, SEC 1 or PKCS #8 private key
+func ParseSigningKey(data []byte, alg string) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, errors.New("could not decode signing key pem")
+	}
+
+	var (
+		privateKey interface{}
+		err        error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("could not parse signing key: %w", err)
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok || !keyMatchesAlgorithm(signer, alg) {
+		return SigningKey{}, fmt.Errorf("%w: key can not be used for %s", ErrorUnsupportedAlgorithm, alg)
+	}
+	return newSigningKey(alg, signer)
+}
+
+// MarshalPEM - encodes the private key as PKCS #8 so it can be stored
+func (k SigningKey) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode signing key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func newSigningKey(alg string, privateKey crypto.Signer) (SigningKey, error) {
+	kid, err := thumbprint(publicJWK(privateKey.Public()))
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{
+		ID:         kid,
+		Algorithm:  alg,
+		PrivateKey: privateKey,
+	}, nil
+}
+
+func keyMatchesAlgorithm(key crypto.Signer, alg string) bool {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return alg == AlgorithmRS256
+	case *ecdsa.PrivateKey:
+		return alg == AlgorithmES256 && k.Curve == elliptic.P256()
+	case ed25519.PrivateKey:
+		return alg == AlgorithmEdDSA
+	}
+	return false
+}
+
+func publicJWK(key crypto.PublicKey) JWK {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   encodeSegment(k.N.Bytes()),
+			E:   encodeSegment(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   encodeSegment(k.X.FillBytes(make([]byte, size))),
+			Y:   encodeSegment(k.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   encodeSegment(k),
+		}
+	}
+	return JWK{}
+}
+
+// thumbprint - computes the RFC 7638 thumbprint of a public key
+func thumbprint(jwk JWK) (string, error) {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", fmt.Errorf("%w: key type %q", ErrorUnsupportedAlgorithm, jwk.Kty)
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return encodeSegment(sum[:]), nil
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// PublicKey - turns a public JWK back into a key that can verify signatures
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("%w: curve %q", ErrorUnsupportedAlgorithm, j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("ec point is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w: curve %q", ErrorUnsupportedAlgorithm, j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("%w: key type %q", ErrorUnsupportedAlgorithm, j.Kty)
+}
@@ -0,0 +1,96 @@
+package user
+
+import (
+	"context"
+	"errors"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSigningKey - stores a new key of the algorithm as PEM in a temporary file
+func writeSigningKey(t *testing.T, alg string) (SigningKey, string) {
+	t.Helper()
+	key, err := NewSigningKey(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := key.MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return key, path
+}
+
+func TestJWKSVerifiesTokens(t *testing.T) {
+	for _, alg := range []string{AlgorithmRS256, AlgorithmES256} {
+		t.Run(alg, func(t *testing.T) {
+			svc, _ := newTestService(t, func(config *Config) {
+				config.SigningAlgorithm = alg
+			})
+			token, err := svc.GenerateToken(context.Background(), User{ID: "1", Email: "jane@example.com"}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := svc.JWKS().Keys
+			if len(keys) != 1 {
+				t.Fatalf("jwks has %d keys, want 1", len(keys))
+			}
+			jwk := keys[0]
+			if jwk.Alg != alg || jwk.Use != "sig" || jwk.Kid != svc.Keys.Active().ID {
+				t.Errorf("jwk = %+v, want a %s signing key with the kid of the active key", jwk, alg)
+			}
+			publicKey, err := jwk.PublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+				if token.Header["kid"] != jwk.Kid {
+					return nil, errors.New("unexpected kid")
+				}
+				return publicKey, nil
+			}, jwt.WithValidMethods([]string{alg}))
+			if err != nil || !parsed.Valid {
+				t.Errorf("token does not verify with the published key: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseSigningKey(t *testing.T) {
+	key, path := writeSigningKey(t, AlgorithmES256)
+	loaded, err := LoadSigningKey(path, AlgorithmES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ID != key.ID {
+		t.Errorf("kid changed from %s to %s when loading the key", key.ID, loaded.ID)
+	}
+	if _, err := LoadSigningKey(path, AlgorithmRS256); !errors.Is(err, ErrorUnsupportedAlgorithm) {
+		t.Errorf("ec key loaded for RS256: got %v, want %v", err, ErrorUnsupportedAlgorithm)
+	}
+}
+
+func TestNewServiceRequiresSigningKeyOutsideDevMode(t *testing.T) {
+	config := testConfig()
+	config.DevMode = false
+	if _, err := NewService(newMemoryStore(), config); err == nil {
+		t.Error("a generated signing key was accepted outside dev mode")
+	}
+
+	key, path := writeSigningKey(t, config.SigningAlgorithm)
+	config.SigningKeyPath = path
+	svc, err := NewService(newMemoryStore(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Keys.Active().ID != key.ID {
+		t.Errorf("signing with %s, want the configured key %s", svc.Keys.Active().ID, key.ID)
+	}
+}
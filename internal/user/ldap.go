@@ -0,0 +1,224 @@
+package user
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DirectoryProvider is the provider name directory accounts are linked
+	// to users under, the subject is the DN of the account
+	DirectoryProvider = "ldap"
+
+	loginPlaceholder = "{login}"
+	dnPlaceholder    = "{dn}"
+)
+
+var (
+	ErrorInvalidCredentials   = errors.New("invalid login or password")
+	ErrorDirectoryUnavailable = errors.New("directory is unavailable")
+	ErrorDirectoryAmbiguous   = errors.New("login matches more than one directory account")
+)
+
+// LDAPConfig - settings of the LDAP or Active Directory server passwords are
+// checked against when a user is not known locally
+type LDAPConfig struct {
+	// URL of the server, ldap:// or ldaps://, LDAP sign in is off when empty
+	URL string
+	// StartTLS upgrades an ldap:// connection before binding
+	StartTLS bool
+	// BindDN and BindPassword are the service account users are searched
+	// with, the search is anonymous when BindDN is empty
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the account of a login, {login} is replaced with the
+	// escaped login, e.g. (&(objectClass=user)(sAMAccountName={login})) for AD
+	UserFilter     string
+	EmailAttribute string
+	// GroupFilter finds the groups of an account below GroupBaseDN, {dn} and
+	// {login} are replaced with the escaped DN and login. The memberOf
+	// attribute of the account is read when it is empty.
+	GroupBaseDN string
+	GroupFilter string
+	// GroupRoles maps group names or DNs to the roles their members get
+	GroupRoles map[string]string
+	Timeout    time.Duration
+}
+
+// DirectoryAccount - an account whose password the directory accepted
+type DirectoryAccount struct {
+	DN     string
+	Email  string
+	Groups []string
+}
+
+// Directory - an external user directory passwords can be checked against
+type Directory interface {
+	Authenticate(ctx context.Context, login string, password string) (DirectoryAccount, error)
+}
+
+type ldapDirectory struct {
+	config LDAPConfig
+}
+
+// NewLDAPDirectory - a directory backed by an LDAP or Active Directory server
+func NewLDAPDirectory(config LDAPConfig) Directory {
+	return &ldapDirectory{config: config}
+}
+
+// Authenticate - finds the account of the login with the service account and
+// binds as it to check the password
+func (d *ldapDirectory) Authenticate(ctx context.Context, login string, password string) (DirectoryAccount, error) {
+	// an empty password is an unauthenticated bind, which servers accept
+	if login == "" || password == "" {
+		return DirectoryAccount{}, ErrorInvalidCredentials
+	}
+	conn, err := d.connect()
+	if err != nil {
+		return DirectoryAccount{}, err
+	}
+	defer conn.Close()
+	if err := d.bindServiceAccount(conn); err != nil {
+		return DirectoryAccount{}, err
+	}
+
+	filter := strings.ReplaceAll(d.config.UserFilter, loginPlaceholder, ldap.EscapeFilter(login))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter, []string{d.config.EmailAttribute, "memberOf"}, nil,
+	))
+	if err != nil {
+		return DirectoryAccount{}, fmt.Errorf("%w: searching for %s: %s", ErrorDirectoryUnavailable, login, err)
+	}
+	switch len(result.Entries) {
+	case 0:
+		return DirectoryAccount{}, ErrorInvalidCredentials
+	case 1:
+	default:
+		return DirectoryAccount{}, ErrorDirectoryAmbiguous
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return DirectoryAccount{}, ErrorInvalidCredentials
+		}
+		return DirectoryAccount{}, fmt.Errorf("%w: binding as %s: %s", ErrorDirectoryUnavailable, entry.DN, err)
+	}
+
+	account := DirectoryAccount{
+		DN:     entry.DN,
+		Email:  entry.GetAttributeValue(d.config.EmailAttribute),
+		Groups: entry.GetAttributeValues("memberOf"),
+	}
+	if d.config.GroupFilter != "" {
+		if account.Groups, err = d.groups(conn, entry.DN, login); err != nil {
+			return DirectoryAccount{}, err
+		}
+	}
+	return account, nil
+}
+
+func (d *ldapDirectory) connect() (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: d.config.Timeout}
+	conn, err := ldap.DialURL(d.config.URL, ldap.DialWithDialer(dialer))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorDirectoryUnavailable, err)
+	}
+	conn.SetTimeout(d.config.Timeout)
+	if d.config.StartTLS {
+		target, err := url.Parse(d.config.URL)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %s", ErrorDirectoryUnavailable, err)
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: target.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: starttls: %s", ErrorDirectoryUnavailable, err)
+		}
+	}
+	return conn, nil
+}
+
+func (d *ldapDirectory) bindServiceAccount(conn *ldap.Conn) error {
+	if d.config.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+		return fmt.Errorf("%w: binding as %s: %s", ErrorDirectoryUnavailable, d.config.BindDN, err)
+	}
+	return nil
+}
+
+// groups - searches the groups the account is a member of, as the service
+// account since the user may not be allowed to read them
+func (d *ldapDirectory) groups(conn *ldap.Conn, dn string, login string) ([]string, error) {
+	if err := d.bindServiceAccount(conn); err != nil {
+		return nil, err
+	}
+	filter := strings.NewReplacer(
+		dnPlaceholder, ldap.EscapeFilter(dn),
+		loginPlaceholder, ldap.EscapeFilter(login),
+	).Replace(d.config.GroupFilter)
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, []string{"dn"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("%w: searching groups of %s: %s", ErrorDirectoryUnavailable, dn, err)
+	}
+	var groups []string
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// directoryLogin - checks the password against the directory and returns the
+// shadow user of the account, the roles of the user follow the groups of the
+// account on every sign in
+func (s *Service) directoryLogin(ctx context.Context, login string, password string) (User, error) {
+	account, err := s.Directory.Authenticate(ctx, login, password)
+	if err != nil {
+		return User{}, err
+	}
+	if account.Email == "" {
+		return User{}, fmt.Errorf("directory account %s has no %s", account.DN, s.Config.LDAP.EmailAttribute)
+	}
+	roles := groupRoles(s.Config.LDAP.GroupRoles, account.Groups)
+	return s.linkedUser(ctx, DirectoryProvider, strings.ToLower(account.DN), account.Email, roles)
+}
+
+// groupRoles - maps the groups of an account to roles, a group matches by its
+// DN or by its name, the value of the first RDN
+func groupRoles(mapping map[string]string, groups []string) []string {
+	var roles []string
+	for _, group := range groups {
+		role, ok := mapping[group]
+		if !ok {
+			role, ok = mapping[groupName(group)]
+		}
+		if ok && !contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+func groupName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
@@ -0,0 +1,384 @@
+package user
+
+import (
+	"context"
+	"errors"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	fakeBaseDN       = "dc=meathub,dc=local"
+	fakeAdminDN      = "cn=admin,dc=meathub,dc=local"
+	fakeAdminPass    = "admin"
+	fakeBuyersDN     = "cn=wholesale-buyers,ou=groups,dc=meathub,dc=local"
+	fakeAdminsDN     = "cn=wholesale-admins,ou=groups,dc=meathub,dc=local"
+	fakeUnmappedDN   = "cn=staff,ou=groups,dc=meathub,dc=local"
+	fakeAliceDN      = "uid=alice,ou=people,dc=meathub,dc=local"
+	fakeAlicePass    = "s3cret"
+	ldapBindRequest  = 0
+	ldapBindResponse = 1
+	ldapUnbind       = 2
+	ldapSearch       = 3
+	ldapSearchEntry  = 4
+	ldapSearchDone   = 5
+)
+
+// fakeAccount - a person in the fake directory
+type fakeAccount struct {
+	dn       string
+	uid      string
+	mail     string
+	password string
+}
+
+// fakeDirectory - a minimal LDAP server answering the binds and searches the
+// directory login makes. Searches only match (uid=...), (mail=...) and
+// (member=...) equality filters, which is all the configured filters need.
+type fakeDirectory struct {
+	listener net.Listener
+	accounts []fakeAccount
+	groups   map[string][]string
+
+	mu sync.Mutex
+	// binds and filters record what the server was asked, filters are
+	// decompiled so escaped values show as \2a
+	binds   []string
+	filters []string
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDirectory{
+		listener: listener,
+		accounts: []fakeAccount{
+			{dn: fakeAliceDN, uid: "alice", mail: "alice@partner.example", password: fakeAlicePass},
+		},
+		groups: map[string][]string{
+			fakeBuyersDN:   {fakeAliceDN},
+			fakeAdminsDN:   {fakeAliceDN},
+			fakeUnmappedDN: {fakeAliceDN},
+		},
+	}
+	t.Cleanup(func() { listener.Close() })
+	go d.serve()
+	return d
+}
+
+func (d *fakeDirectory) url() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+func (d *fakeDirectory) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *fakeDirectory) handle(conn net.Conn) {
+	defer conn.Close()
+	bound := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldapBindRequest:
+			dn, _ := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			d.record(&d.binds, dn)
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			if password != "" && d.password(dn) == password {
+				bound, code = dn, ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapResponse(id, ldapBindResponse, code).Bytes())
+		case ldapSearch:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				conn.Write(ldapResponse(id, ldapSearchDone, ldap.LDAPResultProtocolError).Bytes())
+				continue
+			}
+			d.record(&d.filters, filter)
+			if bound != fakeAdminDN {
+				conn.Write(ldapResponse(id, ldapSearchDone, ldap.LDAPResultInsufficientAccessRights).Bytes())
+				continue
+			}
+			for _, entry := range d.search(id, filter) {
+				conn.Write(entry.Bytes())
+			}
+			conn.Write(ldapResponse(id, ldapSearchDone, ldap.LDAPResultSuccess).Bytes())
+		case ldapUnbind:
+			return
+		}
+	}
+}
+
+func (d *fakeDirectory) record(list *[]string, value string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	*list = append(*list, value)
+}
+
+func (d *fakeDirectory) password(dn string) string {
+	if dn == fakeAdminDN {
+		return fakeAdminPass
+	}
+	for _, account := range d.accounts {
+		if account.dn == dn {
+			return account.password
+		}
+	}
+	return ""
+}
+
+// search - the entries matching the (uid=...), (mail=...) or (member=...)
+// parts of the filter
+func (d *fakeDirectory) search(id int64, filter string) []*ber.Packet {
+	var entries []*ber.Packet
+	for _, account := range d.accounts {
+		if strings.Contains(filter, "(uid="+ldap.EscapeFilter(account.uid)+")") ||
+			strings.Contains(filter, "(mail="+ldap.EscapeFilter(account.mail)+")") {
+			entries = append(entries, ldapEntry(id, account.dn, map[string]string{"mail": account.mail}))
+		}
+	}
+	for group, members := range d.groups {
+		for _, member := range members {
+			if strings.Contains(filter, "(member="+ldap.EscapeFilter(member)+")") {
+				entries = append(entries, ldapEntry(id, group, nil))
+			}
+		}
+	}
+	return entries
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	message.AppendChild(op)
+	return message
+}
+
+func ldapResponse(id int64, tag ber.Tag, code int64) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return ldapMessage(id, op)
+}
+
+func ldapEntry(id int64, dn string, attributes map[string]string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapSearchEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, value := range attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		attribute.AppendChild(values)
+		list.AppendChild(attribute)
+	}
+	op.AppendChild(list)
+	return ldapMessage(id, op)
+}
+
+// newDirectoryService - a service signing users in against the fake directory
+// with the filters of the docker-compose setup
+func newDirectoryService(t *testing.T, directory *fakeDirectory) (*Service, *memoryStore) {
+	t.Helper()
+	return newTestService(t, func(config *Config) {
+		config.LDAP = LDAPConfig{
+			URL:            directory.url(),
+			BindDN:         fakeAdminDN,
+			BindPassword:   fakeAdminPass,
+			BaseDN:         fakeBaseDN,
+			UserFilter:     "(&(objectClass=person)(|(uid={login})(mail={login})))",
+			EmailAttribute: "mail",
+			GroupBaseDN:    fakeBaseDN,
+			GroupFilter:    "(&(objectClass=groupOfNames)(member={dn}))",
+			GroupRoles: map[string]string{
+				"wholesale-buyers": "wholesale-buyer",
+				fakeAdminsDN:       "wholesale-admin",
+			},
+			Timeout: time.Second * 5,
+		}
+	})
+}
+
+func TestDirectoryLogin(t *testing.T) {
+	directory := newFakeDirectory(t)
+	svc, store := newDirectoryService(t, directory)
+	ctx := context.Background()
+
+	usr, err := svc.Login(ctx, "alice", fakeAlicePass)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if usr.Email != "alice@partner.example" {
+		t.Errorf("email = %q, want alice@partner.example", usr.Email)
+	}
+	if want := []string{"wholesale-admin", "wholesale-buyer"}; !reflect.DeepEqual(usr.Roles, want) {
+		t.Errorf("roles = %v, want %v", usr.Roles, want)
+	}
+	if identity, err := store.GetFederatedIdentity(ctx, DirectoryProvider, fakeAliceDN); err != nil || identity.UserID != usr.ID {
+		t.Errorf("identity %+v, %v, want a link to user %s", identity, err, usr.ID)
+	}
+
+	again, err := svc.Login(ctx, "alice", fakeAlicePass)
+	if err != nil {
+		t.Fatalf("second login failed: %v", err)
+	}
+	if again.ID != usr.ID || len(store.users) != 1 {
+		t.Errorf("second login returned user %s with %d users stored, want user %s only", again.ID, len(store.users), usr.ID)
+	}
+}
+
+func TestDirectoryLoginOfLocalUsers(t *testing.T) {
+	directory := newFakeDirectory(t)
+	directory.accounts = append(directory.accounts, fakeAccount{
+		dn: "uid=bob,ou=people,dc=meathub,dc=local", uid: "bob", mail: "bob@partner.example", password: "directory password",
+	})
+	svc, _ := newDirectoryService(t, directory)
+	ctx := context.Background()
+
+	if _, err := svc.PostUser(ctx, User{Email: "bob@partner.example", Password: "local password"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Login(ctx, "bob@partner.example", "directory password"); err == nil {
+		t.Error("a local user signed in with the password of a directory account")
+	}
+	directory.mu.Lock()
+	if len(directory.binds) != 0 {
+		t.Errorf("the password of a local user was checked against the directory, binds %v", directory.binds)
+	}
+	directory.mu.Unlock()
+
+	linked, err := svc.Login(ctx, "alice", fakeAlicePass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr, err := svc.Login(ctx, "alice@partner.example", fakeAlicePass)
+	if err != nil {
+		t.Fatalf("a user linked to the directory could not sign in with the email: %v", err)
+	}
+	if usr.ID != linked.ID {
+		t.Errorf("signed in as user %s, want %s", usr.ID, linked.ID)
+	}
+}
+
+func TestDirectoryLoginRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		login    string
+		password string
+	}{
+		{name: "wrong password", login: "alice", password: "wrong"},
+		{name: "empty password", login: "alice", password: ""},
+		{name: "unknown login", login: "mallory", password: fakeAlicePass},
+		{name: "filter injection", login: "*)(uid=*", password: fakeAlicePass},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory := newFakeDirectory(t)
+			svc, store := newDirectoryService(t, directory)
+
+			_, err := svc.Login(context.Background(), tt.login, tt.password)
+			if !errors.Is(err, ErrorInvalidCredentials) {
+				t.Errorf("got %v, want %v", err, ErrorInvalidCredentials)
+			}
+			if len(store.users) != 0 {
+				t.Errorf("a rejected login created %d users", len(store.users))
+			}
+		})
+	}
+}
+
+func TestDirectoryLoginEmptyPasswordNeverBinds(t *testing.T) {
+	directory := newFakeDirectory(t)
+	svc, _ := newDirectoryService(t, directory)
+
+	// an empty password would be an unauthenticated bind that servers accept
+	if _, err := svc.Login(context.Background(), "alice", ""); !errors.Is(err, ErrorInvalidCredentials) {
+		t.Fatalf("got %v, want %v", err, ErrorInvalidCredentials)
+	}
+	directory.mu.Lock()
+	defer directory.mu.Unlock()
+	if len(directory.binds) != 0 {
+		t.Errorf("the directory was asked to bind as %v", directory.binds)
+	}
+}
+
+func TestDirectoryLoginEscapesFilter(t *testing.T) {
+	directory := newFakeDirectory(t)
+	svc, _ := newDirectoryService(t, directory)
+
+	svc.Login(context.Background(), "*)(uid=*", fakeAlicePass)
+	directory.mu.Lock()
+	defer directory.mu.Unlock()
+	if len(directory.filters) == 0 {
+		t.Fatal("the directory was never searched")
+	}
+	want := `(uid=\2a\29\28uid=\2a)`
+	if filter := directory.filters[0]; !strings.Contains(filter, want) {
+		t.Errorf("filter %s does not contain the escaped login %s", filter, want)
+	}
+	for _, bind := range directory.binds {
+		if bind != fakeAdminDN {
+			t.Errorf("bound as %s after searching for an injected login", bind)
+		}
+	}
+}
+
+func TestGroupRoles(t *testing.T) {
+	mapping := map[string]string{
+		"wholesale-buyers": "wholesale-buyer",
+		fakeAdminsDN:       "wholesale-admin",
+		"buyers-eu":        "wholesale-buyer",
+	}
+	tests := []struct {
+		name   string
+		groups []string
+		want   []string
+	}{
+		{name: "by name", groups: []string{fakeBuyersDN}, want: []string{"wholesale-buyer"}},
+		{name: "by dn", groups: []string{fakeAdminsDN}, want: []string{"wholesale-admin"}},
+		{name: "unmapped groups are ignored", groups: []string{fakeUnmappedDN}, want: nil},
+		{name: "roles are sorted and unique", groups: []string{fakeBuyersDN, fakeAdminsDN, "cn=buyers-eu,ou=groups,dc=meathub,dc=local"}, want: []string{"wholesale-admin", "wholesale-buyer"}},
+		{name: "no groups", groups: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := groupRoles(mapping, tt.groups); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupRoles(%v) = %v, want %v", tt.groups, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetGroupRolesOrDefault(t *testing.T) {
+	t.Setenv("LDAP_GROUP_ROLES", "wholesale-buyers:wholesale-buyer; "+fakeAdminsDN+":wholesale-admin;invalid;")
+	want := map[string]string{
+		"wholesale-buyers": "wholesale-buyer",
+		fakeAdminsDN:       "wholesale-admin",
+	}
+	if got := getGroupRolesOrDefault("LDAP_GROUP_ROLES", nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,88 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+const (
+	MailerLog  = "log"
+	MailerSMTP = "smtp"
+)
+
+// MailConfig - how emails to users are delivered
+type MailConfig struct {
+	// Mailer is either log, which only writes the emails to the log for local
+	// development, or smtp
+	Mailer string
+	// SMTPAddr is the host:port of the relay, SMTPUsername and SMTPPassword
+	// are only sent when a username is set
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+}
+
+// Message - a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer - delivers emails to users
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewMailer - the mailer named in the config
+func NewMailer(config MailConfig) (Mailer, error) {
+	switch config.Mailer {
+	case MailerLog, "":
+		return logMailer{}, nil
+	case MailerSMTP:
+		if config.SMTPAddr == "" || config.From == "" {
+			return nil, fmt.Errorf("the smtp mailer needs SMTP_ADDR and MAIL_FROM")
+		}
+		return smtpMailer{config: config}, nil
+	default:
+		return nil, fmt.Errorf("unsupported mailer %q", config.Mailer)
+	}
+}
+
+// logMailer - writes emails to the log instead of sending them
+type logMailer struct{}
+
+func (logMailer) Send(ctx context.Context, message Message) error {
+	log.WithField("to", message.To).Infof("%s\n%s", message.Subject, message.Body)
+	return nil
+}
+
+// smtpMailer - sends emails through an SMTP relay, STARTTLS is used when the
+// relay offers it
+type smtpMailer struct {
+	config MailConfig
+}
+
+func (m smtpMailer) Send(ctx context.Context, message Message) error {
+	var auth smtp.Auth
+	if m.config.SMTPUsername != "" {
+		host, _, err := net.SplitHostPort(m.config.SMTPAddr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.config.SMTPUsername, m.config.SMTPPassword, host)
+	}
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", m.config.From)
+	fmt.Fprintf(&body, "To: %s\r\n", message.To)
+	fmt.Fprintf(&body, "Subject: %s\r\n", message.Subject)
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return smtp.SendMail(m.config.SMTPAddr, auth, m.config.From, []string{message.To}, []byte(body.String()))
+}
@@ -0,0 +1,366 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	ResponseTypeCode = "code"
+
+	CodeChallengeMethodS256 = "S256"
+
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+
+	authorizationCodeLength = 32
+	authorizationCodeTTL    = time.Minute
+)
+
+var (
+	ErrorClientNotFound        = errors.New("client not found")
+	ErrorInvalidRedirectURI    = errors.New("redirect uri is not registered for the client")
+	ErrorInvalidScope          = errors.New("scope is not allowed for the client")
+	ErrorUnsupportedResponse   = errors.New("unsupported response type")
+	ErrorInvalidCodeChallenge  = errors.New("a S256 code challenge is required")
+	ErrorInvalidGrant          = errors.New("invalid grant")
+	ErrorInvalidCodeVerifier   = errors.New("code verifier does not match the code challenge")
+	ErrorAuthorizationCodeUsed = errors.New("authorization code is unknown or has already been used")
+	ErrorUnauthorizedClient    = errors.New("client is not allowed to use this grant type")
+)
+
+// Client - an application registered to use the OAuth endpoints
+type Client struct {
+	ID           string
+	Name         string
+	RedirectURIs []string
+	Scopes       []string
+	GrantTypes   []string
+	// TokenEndpointAuthMethod is none for public clients
+	TokenEndpointAuthMethod string
+	// SecretHash is empty for public clients
+	SecretHash string
+	// RegistrationTokenHash is set for clients that registered themselves
+	RegistrationTokenHash string
+	// FirstParty clients are our own apps, users are not asked for consent
+	FirstParty bool
+	CreatedAt  time.Time
+}
+
+type ClientStore interface {
+	GetClient(ctx context.Context, id string) (Client, error)
+	CreateClient(context.Context, Client) error
+	UpdateClient(context.Context, Client) error
+	DeleteClient(ctx context.Context, id string) error
+	UpdateClientSecret(ctx context.Context, id string, secretHash string) error
+}
+
+// Confidential - reports whether the client has a secret to authenticate with
+func (c Client) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// AllowsGrant - reports whether the client may use the grant type
+func (c Client) AllowsGrant(grantType string) bool {
+	return contains(c.GrantTypes, grantType)
+}
+
+// AuthorizationCode - server side record of an issued authorization code,
+// only the hash of the code is stored
+type AuthorizationCode struct {
+	CodeHash            string
+	ClientID            string
+	UserID              string
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	// AuthTime is when the user signed in to get the code
+	AuthTime  time.Time
+	ExpiresAt time.Time
+	// RefreshFamilyID and AccessTokenJTI are the tokens the code was
+	// redeemed for, they are revoked when the code is presented again
+	RefreshFamilyID string
+	AccessTokenJTI  string
+}
+
+type AuthorizationCodeStore interface {
+	CreateAuthorizationCode(context.Context, AuthorizationCode) error
+	// ConsumeAuthorizationCode marks the code as used and returns it. Codes
+	// that were used before return ErrorAuthorizationCodeUsed along with the
+	// code so the tokens it was redeemed for can be revoked
+	ConsumeAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error)
+	// SetAuthorizationCodeTokens records the tokens a code was redeemed for
+	SetAuthorizationCodeTokens(ctx context.Context, codeHash string, familyID string, jti string) error
+	// DeleteExpiredAuthorizationCodes drops the codes that expired before the time
+	DeleteExpiredAuthorizationCodes(ctx context.Context, before time.Time) (int64, error)
+}
+
+// AuthorizationRequest - the parameters of a request to /oauth/authorize
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	// RedirectURIOmitted is set when the client left out redirect_uri and
+	// its only registered redirect uri was filled in
+	RedirectURIOmitted bool
+}
+
+// SentRedirectURI - the redirect uri as the client sent it, empty when the
+// registered one was filled in
+func (r AuthorizationRequest) SentRedirectURI() string {
+	if r.RedirectURIOmitted {
+		return ""
+	}
+	return r.RedirectURI
+}
+
+// TokenSet - tokens handed out by the token endpoint
+type TokenSet struct {
+	AccessToken  string
+	TokenType    string
+	ExpiresIn    int64
+	RefreshToken string
+	Scope        string
+	// IDToken is only issued when the openid scope was granted
+	IDToken string
+	// IssuedTokenType is only set by a token exchange
+	IssuedTokenType string
+}
+
+// ValidateAuthorizationRequest - checks the client and redirect uri of an
+// authorization request, filling in the defaults. Errors about the client
+// or the redirect uri must not be sent back to the redirect uri.
+func (s *Service) ValidateAuthorizationRequest(ctx context.Context, req AuthorizationRequest) (AuthorizationRequest, error) {
+	client, err := s.Store.GetClient(ctx, req.ClientID)
+	if err != nil {
+		return req, ErrorClientNotFound
+	}
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+		req.RedirectURIOmitted = true
+	}
+	if !contains(client.RedirectURIs, req.RedirectURI) {
+		return req, ErrorInvalidRedirectURI
+	}
+	return req, nil
+}
+
+// CheckAuthorizationRequest - checks the parameters of an authorization
+// request whose errors can be reported back to the redirect uri
+func (s *Service) CheckAuthorizationRequest(ctx context.Context, req AuthorizationRequest) (AuthorizationRequest, error) {
+	client, err := s.Store.GetClient(ctx, req.ClientID)
+	if err != nil {
+		return req, ErrorClientNotFound
+	}
+	if req.ResponseType != ResponseTypeCode {
+		return req, ErrorUnsupportedResponse
+	}
+	if !client.AllowsGrant(GrantTypeAuthorizationCode) {
+		return req, ErrorUnauthorizedClient
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != CodeChallengeMethodS256 {
+		return req, ErrorInvalidCodeChallenge
+	}
+	scope, err := allowedScope(client.Scopes, req.Scope)
+	if err != nil {
+		return req, err
+	}
+	req.Scope = scope
+	return req, nil
+}
+
+// Authorize - issues an authorization code for a request the user has
+// authenticated, the request must have been validated and checked
+func (s *Service) Authorize(ctx context.Context, req AuthorizationRequest, usr User) (string, error) {
+	code, err := randomToken(authorizationCodeLength)
+	if err != nil {
+		return "", err
+	}
+	err = s.Store.CreateAuthorizationCode(ctx, AuthorizationCode{
+		CodeHash:            hashToken(code),
+		ClientID:            req.ClientID,
+		UserID:              usr.ID,
+		RedirectURI:         req.SentRedirectURI(),
+		Scope:               req.Scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AuthTime:            time.Now(),
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not store authorization code: %w", err)
+	}
+	return code, nil
+}
+
+// ExchangeAuthorizationCode - the authorization_code grant, redeems a code
+// for tokens after checking the PKCE code verifier. The redirect uri has to
+// match when the client sent one with the authorization request. A code
+// presented again revokes the tokens it was redeemed for, RFC 6749 4.1.2.
+func (s *Service) ExchangeAuthorizationCode(ctx context.Context, clientID string, code string, redirectURI string, codeVerifier string, jkt string) (TokenSet, error) {
+	stored, err := s.Store.ConsumeAuthorizationCode(ctx, hashToken(code))
+	if errors.Is(err, ErrorAuthorizationCodeUsed) && stored.CodeHash != "" {
+		s.revokeAuthorizationCodeTokens(ctx, stored)
+	}
+	if err != nil {
+		return TokenSet{}, fmt.Errorf("%w: %s", ErrorInvalidGrant, err)
+	}
+	if stored.ClientID != clientID || (stored.RedirectURI != "" && stored.RedirectURI != redirectURI) {
+		return TokenSet{}, ErrorInvalidGrant
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return TokenSet{}, fmt.Errorf("%w: authorization code expired", ErrorInvalidGrant)
+	}
+	if !verifyCodeChallenge(stored.CodeChallenge, codeVerifier) {
+		return TokenSet{}, ErrorInvalidCodeVerifier
+	}
+
+	usr, err := s.Store.GetUser(ctx, stored.UserID)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	tokens, issued, err := s.issueTokenSet(ctx, usr, clientID, stored.Scope, jkt)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	if err := s.Store.SetAuthorizationCodeTokens(ctx, stored.CodeHash, issued.familyID, issued.jti); err != nil {
+		return TokenSet{}, fmt.Errorf("could not record the tokens of the authorization code: %w", err)
+	}
+	if hasScope(stored.Scope, ScopeOpenID) {
+		tokens.IDToken, err = s.idToken(usr, clientID, stored, tokens.AccessToken)
+		if err != nil {
+			return TokenSet{}, err
+		}
+	}
+	return tokens, nil
+}
+
+// revokeAuthorizationCodeTokens - revokes the tokens a replayed code was
+// redeemed for, the code has leaked so they may be in the wrong hands
+func (s *Service) revokeAuthorizationCodeTokens(ctx context.Context, code AuthorizationCode) {
+	log.Warnf("authorization code reuse detected, revoking the tokens issued to client %s", code.ClientID)
+	if code.RefreshFamilyID != "" {
+		if err := s.Store.RevokeRefreshTokenFamily(ctx, code.RefreshFamilyID); err != nil {
+			log.WithError(err).Error("could not revoke refresh token family")
+		}
+	}
+	if code.AccessTokenJTI != "" {
+		if err := s.RevokeTokenID(ctx, code.AccessTokenJTI); err != nil {
+			log.WithError(err).Error("could not revoke access token")
+		}
+	}
+}
+
+// RefreshTokenGrant - the refresh_token grant, rotates the refresh token
+// and issues an access token with the scope of the original grant
+func (s *Service) RefreshTokenGrant(ctx context.Context, token string, clientID string, jkt string) (TokenSet, error) {
+	stored, usr, next, err := s.rotateRefreshToken(ctx, token, clientID, jkt)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	claims, err := s.userAccessTokenClaims(usr, stored.ClientID, stored.Scope, jkt)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	accessToken, err := s.issueAccessToken(ctx, claims)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	return s.tokenSet(accessToken, claims, next, stored.Scope, jkt), nil
+}
+
+// issuedTokens - identifies the tokens of a token set so they can be revoked
+type issuedTokens struct {
+	familyID string
+	jti      string
+}
+
+// issueTokenSet - issues an access token and starts a refresh token family
+func (s *Service) issueTokenSet(ctx context.Context, usr User, clientID string, scope string, jkt string) (TokenSet, issuedTokens, error) {
+	claims, err := s.userAccessTokenClaims(usr, clientID, scope, jkt)
+	if err != nil {
+		return TokenSet{}, issuedTokens{}, err
+	}
+	accessToken, err := s.issueAccessToken(ctx, claims)
+	if err != nil {
+		return TokenSet{}, issuedTokens{}, err
+	}
+	refreshToken, familyID, err := s.startRefreshTokenFamily(ctx, usr.ID, clientID, scope, jkt)
+	if err != nil {
+		return TokenSet{}, issuedTokens{}, err
+	}
+	jti, _ := claims["jti"].(string)
+	return s.tokenSet(accessToken, claims, refreshToken, scope, jkt), issuedTokens{familyID: familyID, jti: jti}, nil
+}
+
+// tokenSet - the token response for an access token issued with the claims,
+// expires_in follows the lifetime the token was issued with for its audience
+func (s *Service) tokenSet(accessToken string, claims map[string]interface{}, refreshToken string, scope string, jkt string) TokenSet {
+	exp, _ := numericClaim(claims, "exp")
+	iat, _ := numericClaim(claims, "iat")
+	return TokenSet{
+		AccessToken:  accessToken,
+		TokenType:    TokenType(jkt),
+		ExpiresIn:    int64(exp.Sub(iat).Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}
+}
+
+// verifyCodeChallenge - RFC 7636 S256, BASE64URL(SHA256(verifier)) == challenge
+func verifyCodeChallenge(challenge string, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(codeChallenge(verifier)), []byte(challenge)) == 1
+}
+
+// codeChallenge - the S256 code challenge of a code verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return encodeSegment(sum[:])
+}
+
+// allowedScope - checks the requested scope against the scopes of the
+// client, an empty request gets every scope the client may use
+func allowedScope(allowed []string, requested string) (string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return strings.Join(allowed, " "), nil
+	}
+	for _, scope := range scopes {
+		if !contains(allowed, scope) {
+			return "", fmt.Errorf("%w: %s", ErrorInvalidScope, scope)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// hasScope - reports whether the space separated scope contains the given one
+func hasScope(scope string, want string) bool {
+	return contains(strings.Fields(scope), want)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testRedirectURI  = "https://app.test/callback"
+	testCodeVerifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// newOAuthService - a service with a first party public client allowed to
+// use the authorization code flow and a signed up user
+func newOAuthService(t *testing.T) (*Service, *memoryStore, User) {
+	t.Helper()
+	svc, store := newTestService(t, nil)
+	store.clients["web"] = Client{
+		ID:           "web",
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{"profile", "orders"},
+		GrantTypes:   []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		FirstParty:   true,
+	}
+	usr, err := store.PostUser(context.Background(), User{Email: "jane@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc, store, usr
+}
+
+// authorize - runs an authorization request for the user and returns the code
+func authorize(t *testing.T, svc *Service, usr User, redirectURI string) string {
+	t.Helper()
+	ctx := context.Background()
+	req, err := svc.ValidateAuthorizationRequest(ctx, AuthorizationRequest{
+		ResponseType:        ResponseTypeCode,
+		ClientID:            "web",
+		RedirectURI:         redirectURI,
+		CodeChallenge:       codeChallenge(testCodeVerifier),
+		CodeChallengeMethod: CodeChallengeMethodS256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.RedirectURI != testRedirectURI {
+		t.Fatalf("redirect uri = %q, want %q", req.RedirectURI, testRedirectURI)
+	}
+	if req, err = svc.CheckAuthorizationRequest(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	code, err := svc.Authorize(ctx, req, usr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestCodeChallengeS256(t *testing.T) {
+	// RFC 7636 appendix B
+	if got := codeChallenge(testCodeVerifier); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("code challenge = %s", got)
+	}
+}
+
+func TestExchangeAuthorizationCodeChecksPKCE(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		wantErr  error
+	}{
+		{name: "matching verifier", verifier: testCodeVerifier},
+		{name: "other verifier", verifier: strings.Repeat("a", 43), wantErr: ErrorInvalidCodeVerifier},
+		{name: "the challenge itself", verifier: codeChallenge(testCodeVerifier), wantErr: ErrorInvalidCodeVerifier},
+		{name: "short verifier", verifier: testCodeVerifier[:42], wantErr: ErrorInvalidCodeVerifier},
+		{name: "no verifier", verifier: "", wantErr: ErrorInvalidCodeVerifier},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _, usr := newOAuthService(t)
+			code := authorize(t, svc, usr, testRedirectURI)
+
+			tokens, err := svc.ExchangeAuthorizationCode(context.Background(), "web", code, testRedirectURI, tt.verifier, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (tokens.AccessToken == "" || tokens.RefreshToken == "") {
+				t.Errorf("tokens = %+v, want an access and a refresh token", tokens)
+			}
+		})
+	}
+}
+
+func TestExchangeAuthorizationCodeRedirectURI(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorizeWith string
+		exchangeWith  string
+		wantErr       error
+	}{
+		{name: "sent and repeated", authorizeWith: testRedirectURI, exchangeWith: testRedirectURI},
+		{name: "sent and left out", authorizeWith: testRedirectURI, exchangeWith: "", wantErr: ErrorInvalidGrant},
+		{name: "sent and changed", authorizeWith: testRedirectURI, exchangeWith: "https://evil.test/callback", wantErr: ErrorInvalidGrant},
+		{name: "left out both times", authorizeWith: "", exchangeWith: ""},
+		{name: "left out and then sent", authorizeWith: "", exchangeWith: testRedirectURI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _, usr := newOAuthService(t)
+			code := authorize(t, svc, usr, tt.authorizeWith)
+
+			_, err := svc.ExchangeAuthorizationCode(context.Background(), "web", code, tt.exchangeWith, testCodeVerifier, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplayedAuthorizationCodeRevokesTokens(t *testing.T) {
+	svc, _, usr := newOAuthService(t)
+	ctx := context.Background()
+	code := authorize(t, svc, usr, testRedirectURI)
+	tokens, err := svc.ExchangeAuthorizationCode(ctx, "web", code, testRedirectURI, testCodeVerifier, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = svc.ExchangeAuthorizationCode(ctx, "web", code, testRedirectURI, testCodeVerifier, "")
+	if !errors.Is(err, ErrorInvalidGrant) {
+		t.Fatalf("replayed code: got %v, want %v", err, ErrorInvalidGrant)
+	}
+	if _, err := svc.ValidateToken(ctx, tokens.AccessToken); !errors.Is(err, ErrorTokenRevoked) {
+		t.Errorf("access token of the replayed code: got %v, want %v", err, ErrorTokenRevoked)
+	}
+	if _, err := svc.RefreshTokenGrant(ctx, tokens.RefreshToken, "web", ""); !errors.Is(err, ErrorInvalidRefreshToken) {
+		t.Errorf("refresh token of the replayed code: got %v, want %v", err, ErrorInvalidRefreshToken)
+	}
+}
@@ -0,0 +1,129 @@
+package user
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"time"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+
+	// idTokenType is the typ header of ID tokens, they are always JWTs
+	idTokenType = "JWT"
+)
+
+var (
+	ErrorInsufficientScope = errors.New("token was not issued with the openid scope")
+)
+
+// ProviderMetadata - OpenID Connect discovery document served on
+// /.well-known/openid-configuration
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	RegistrationEndpoint              string   `json:"registration_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	DPoPSigningAlgValuesSupported     []string `json:"dpop_signing_alg_values_supported"`
+}
+
+// Discovery - describes the endpoints and features of this provider
+func (s *Service) Discovery() ProviderMetadata {
+	return ProviderMetadata{
+		Issuer:                            s.Config.Issuer,
+		AuthorizationEndpoint:             s.externalURL("/oauth/authorize"),
+		TokenEndpoint:                     s.externalURL("/oauth/token"),
+		UserInfoEndpoint:                  s.externalURL("/userinfo"),
+		JWKSURI:                           s.externalURL("/.well-known/jwks.json"),
+		IntrospectionEndpoint:             s.externalURL("/oauth/introspect"),
+		DeviceAuthorizationEndpoint:       s.externalURL("/oauth/device_authorization"),
+		RegistrationEndpoint:              s.externalURL("/oauth/register"),
+		ScopesSupported:                   []string{ScopeOpenID, ScopeProfile, ScopeEmail},
+		ResponseTypesSupported:            []string{ResponseTypeCode},
+		GrantTypesSupported:               []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken, GrantTypeClientCredentials, GrantTypeDeviceCode, GrantTypeTokenExchange},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{s.Keys.Active().Algorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{CodeChallengeMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "email", "email_verified"},
+		DPoPSigningAlgValuesSupported:     dpopProofAlgorithms,
+	}
+}
+
+// UserInfo - the claims about the user behind an access token that was
+// issued with the openid scope
+func (s *Service) UserInfo(ctx context.Context, claims map[string]interface{}) (map[string]interface{}, error) {
+	scope, _ := claims["scope"].(string)
+	if IsClientToken(claims) || !hasScope(scope, ScopeOpenID) {
+		return nil, ErrorInsufficientScope
+	}
+	sub, _ := claims["sub"].(string)
+	usr, err := s.Store.GetUser(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+	return userClaims(usr, scope), nil
+}
+
+// idToken - signs the ID token returned next to the access token of an
+// authorization code grant. ID tokens are JWTs whatever the access token
+// format is, as OpenID Connect clients expect.
+func (s *Service) idToken(usr User, clientID string, code AuthorizationCode, accessToken string) (string, error) {
+	now := time.Now()
+	claims := userClaims(usr, code.Scope)
+	claims["iss"] = s.Config.Issuer
+	claims["aud"] = clientID
+	claims["azp"] = clientID
+	claims["exp"] = now.Add(s.Config.IDTokenTTL).Unix()
+	claims["iat"] = now.Unix()
+	claims["auth_time"] = code.AuthTime.Unix()
+	claims["token_use"] = TokenUseID
+	if code.Nonce != "" {
+		claims["nonce"] = code.Nonce
+	}
+	claims["at_hash"] = leftHalfHash(s.Keys.Active().Algorithm, accessToken)
+
+	format := &jwtFormat{keys: s.Keys}
+	return format.Sign(claims, idTokenType)
+}
+
+// userClaims - the standard claims about the user released for the scope
+func userClaims(usr User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": usr.ID}
+	if hasScope(scope, ScopeEmail) {
+		claims["email"] = usr.Email
+		claims["email_verified"] = usr.EmailVerified
+	}
+	return claims
+}
+
+// leftHalfHash - the at_hash of a token, the left half of its hash with the
+// hash function of the signing algorithm, base64url encoded
+func leftHalfHash(alg string, token string) string {
+	var h hash.Hash
+	if alg == AlgorithmEdDSA {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(token))
+	sum := h.Sum(nil)
+	return encodeSegment(sum[:len(sum)/2])
+}
@@ -0,0 +1,88 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// AccessTokenModeSigned issues self-contained tokens in the configured format
+	AccessTokenModeSigned = "signed"
+	// AccessTokenModeOpaque issues random reference tokens whose claims stay
+	// in the database, revoking one takes effect immediately
+	AccessTokenModeOpaque = "opaque"
+
+	opaqueTokenLength = 32
+)
+
+var (
+	ErrorAccessTokenNotFound = errors.New("access token not found")
+)
+
+// AccessToken - server side record of an opaque access token, only the hash
+// of the token is stored
+type AccessToken struct {
+	TokenHash string
+	JTI       string
+	Subject   string
+	Claims    map[string]interface{}
+	ExpiresAt time.Time
+}
+
+type AccessTokenStore interface {
+	CreateAccessToken(context.Context, AccessToken) error
+	GetAccessToken(ctx context.Context, tokenHash string) (AccessToken, error)
+	DeleteAccessToken(ctx context.Context, tokenHash string) error
+	// DeleteUserAccessTokens drops the tokens issued to the user
+	DeleteUserAccessTokens(ctx context.Context, userID string) error
+	DeleteExpiredAccessTokens(ctx context.Context) (int64, error)
+}
+
+// issueAccessToken - turns access token claims into a token in the configured mode
+func (s *Service) issueAccessToken(ctx context.Context, claims map[string]interface{}) (string, error) {
+	if s.Config.AccessTokenMode != AccessTokenModeOpaque {
+		return s.signToken(claims, accessTokenType)
+	}
+
+	token, err := randomToken(opaqueTokenLength)
+	if err != nil {
+		return "", err
+	}
+	jti, _ := claims["jti"].(string)
+	sub, _ := claims["sub"].(string)
+	exp, _ := numericClaim(claims, "exp")
+	err = s.Store.CreateAccessToken(ctx, AccessToken{
+		TokenHash: hashToken(token),
+		JTI:       jti,
+		Subject:   sub,
+		Claims:    claims,
+		ExpiresAt: exp,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not store access token: %w", err)
+	}
+	return token, nil
+}
+
+// LookupAccessToken - resolves an opaque access token to its claims
+func (s *Service) LookupAccessToken(ctx context.Context, token string) (map[string]interface{}, error) {
+	stored, err := s.Store.GetAccessToken(ctx, hashToken(token))
+	if err != nil {
+		return nil, ErrorAccessTokenNotFound
+	}
+	if err := s.Config.validateClaims(stored.Claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return stored.Claims, nil
+}
+
+// resolveToken - signed tokens are verified locally, anything else is looked
+// up as an opaque token
+func (s *Service) resolveToken(ctx context.Context, token string) (map[string]interface{}, error) {
+	if _, ok := s.Format.Peek(token); ok {
+		return s.parseToken(token)
+	}
+	return s.LookupAccessToken(ctx, token)
+}
@@ -0,0 +1,153 @@
+package user
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	pasetoHeader = "v4.public."
+)
+
+// pasetoFormat - PASETO v4.public tokens, Ed25519 signatures over the
+// pre-authentication encoding of the header, payload and footer. The kid
+// of the signing key travels in the footer.
+type pasetoFormat struct {
+	keys *Keyring
+}
+
+type pasetoFooter struct {
+	Kid string `json:"kid"`
+}
+
+// pasetoTimeClaims are NumericDates in our claims but RFC 3339 strings in PASETO
+var pasetoTimeClaims = []string{"exp", "nbf", "iat"}
+
+func (f *pasetoFormat) Sign(claims map[string]interface{}, typ string) (string, error) {
+	key := f.keys.Active()
+	privateKey, ok := key.PrivateKey.(ed25519.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("%w: paseto v4.public tokens need %s keys", ErrorUnsupportedAlgorithm, AlgorithmEdDSA)
+	}
+
+	payload := map[string]interface{}{}
+	for name, value := range claims {
+		payload[name] = value
+	}
+	for _, name := range pasetoTimeClaims {
+		if t, ok := numericClaim(claims, name); ok {
+			payload[name] = t.UTC().Format(time.RFC3339)
+		}
+	}
+
+	message, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	footer, err := json.Marshal(pasetoFooter{Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	signature := ed25519.Sign(privateKey, pae([]byte(pasetoHeader), message, footer, nil))
+	body := append(message, signature...)
+	return pasetoHeader + base64.RawURLEncoding.EncodeToString(body) + "." + base64.RawURLEncoding.EncodeToString(footer), nil
+}
+
+func (f *pasetoFormat) Verify(token string) (map[string]interface{}, error) {
+	message, signature, footer, err := splitPaseto(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var kid pasetoFooter
+	if err := json.Unmarshal(footer, &kid); err != nil || kid.Kid == "" {
+		return nil, ErrorUnknownKey
+	}
+	key, ok := f.keys.Key(kid.Kid)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrorUnknownKey, kid.Kid)
+	}
+	publicKey, ok := key.PublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrorInvalidToken
+	}
+	if !ed25519.Verify(publicKey, pae([]byte(pasetoHeader), message, footer, nil), signature) {
+		return nil, ErrorInvalidToken
+	}
+
+	return decodePasetoClaims(message)
+}
+
+func (f *pasetoFormat) Peek(token string) (map[string]interface{}, bool) {
+	message, _, _, err := splitPaseto(token)
+	if err != nil {
+		return nil, false
+	}
+	claims, err := decodePasetoClaims(message)
+	if err != nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+func splitPaseto(token string) (message []byte, signature []byte, footer []byte, err error) {
+	if !strings.HasPrefix(token, pasetoHeader) {
+		return nil, nil, nil, ErrorInvalidToken
+	}
+	parts := strings.Split(strings.TrimPrefix(token, pasetoHeader), ".")
+	if len(parts) > 2 {
+		return nil, nil, nil, ErrorInvalidToken
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(body) < ed25519.SignatureSize {
+		return nil, nil, nil, ErrorInvalidToken
+	}
+	if len(parts) == 2 {
+		if footer, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+			return nil, nil, nil, ErrorInvalidToken
+		}
+	}
+	split := len(body) - ed25519.SignatureSize
+	return body[:split], body[split:], footer, nil
+}
+
+func decodePasetoClaims(message []byte) (map[string]interface{}, error) {
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(message, &claims); err != nil {
+		return nil, ErrorInvalidToken
+	}
+	for _, name := range pasetoTimeClaims {
+		value, ok := claims[name].(string)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, ErrorInvalidToken
+		}
+		claims[name] = float64(t.Unix())
+	}
+	return claims, nil
+}
+
+// pae - PASETO pre-authentication encoding
+func pae(pieces ...[]byte) []byte {
+	out := le64(uint64(len(pieces)))
+	for _, piece := range pieces {
+		out = append(out, le64(uint64(len(piece)))...)
+		out = append(out, piece...)
+	}
+	return out
+}
+
+func le64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, n&^(1<<63))
+	return b
+}
@@ -0,0 +1,103 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newPASETOService(t *testing.T) *Service {
+	t.Helper()
+	svc, _ := newTestService(t, func(config *Config) {
+		config.SigningAlgorithm = AlgorithmEdDSA
+		config.TokenFormat = TokenFormatPASETO
+	})
+	return svc
+}
+
+func TestPAE(t *testing.T) {
+	// examples of the pre-authentication encoding section of the PASETO spec
+	tests := []struct {
+		pieces [][]byte
+		want   string
+	}{
+		{pieces: nil, want: "\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{pieces: [][]byte{{}}, want: "\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+		{pieces: [][]byte{[]byte("test")}, want: "\x01\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00\x00\x00\x00\x00\x00test"},
+	}
+	for _, tt := range tests {
+		if got := pae(tt.pieces...); !bytes.Equal(got, []byte(tt.want)) {
+			t.Errorf("pae(%q) = %q, want %q", tt.pieces, got, tt.want)
+		}
+	}
+}
+
+func TestPASETOTokens(t *testing.T) {
+	svc := newPASETOService(t)
+	ctx := context.Background()
+	token, err := svc.GenerateToken(ctx, User{ID: "1", Email: "jane@example.com"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "v4.public.") {
+		t.Fatalf("token %s is not a v4.public paseto", token)
+	}
+	claims, err := svc.ValidateToken(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "1" {
+		t.Errorf("sub = %v, want 1", claims["sub"])
+	}
+	exp, ok := numericClaim(claims, "exp")
+	if !ok || exp.Before(time.Now()) || exp.After(time.Now().Add(svc.Config.AccessTokenTTL+time.Second)) {
+		t.Errorf("exp = %v, want the access token ttl from now", claims["exp"])
+	}
+}
+
+func TestPASETOTokenRejected(t *testing.T) {
+	svc := newPASETOService(t)
+	token, err := svc.GenerateToken(context.Background(), User{ID: "1", Email: "jane@example.com"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, footer, _ := strings.Cut(strings.TrimPrefix(token, "v4.public."), ".")
+	other := newPASETOService(t)
+	foreign, err := other.GenerateToken(context.Background(), User{ID: "1", Email: "jane@example.com"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, foreignFooter, _ := strings.Cut(strings.TrimPrefix(foreign, "v4.public."), ".")
+	flipped := []byte(body)
+	flipped[10] ^= 'A' ^ 'B'
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "changed payload", token: "v4.public." + string(flipped) + "." + footer, wantErr: ErrorInvalidToken},
+		{name: "footer of another key", token: "v4.public." + body + "." + foreignFooter, wantErr: ErrorUnknownKey},
+		{name: "no footer", token: "v4.public." + body, wantErr: ErrorUnknownKey},
+		{name: "signed by another service", token: foreign, wantErr: ErrorUnknownKey},
+		{name: "local paseto", token: "v4.local." + body + "." + footer, wantErr: ErrorInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.parseToken(tt.token); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPASETORequiresEdDSA(t *testing.T) {
+	config := testConfig()
+	config.TokenFormat = TokenFormatPASETO
+	if _, err := NewService(newMemoryStore(), config); !errors.Is(err, ErrorUnsupportedAlgorithm) {
+		t.Errorf("paseto with %s keys: got %v, want %v", config.SigningAlgorithm, err, ErrorUnsupportedAlgorithm)
+	}
+}
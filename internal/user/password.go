@@ -0,0 +1,233 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+const (
+	PasswordHasherArgon2id = "argon2id"
+
+	argon2idSaltLength = 16
+	argon2idKeyLength  = 32
+	// minPepperLength is the shortest pepper accepted, in bytes
+	minPepperLength = 32
+)
+
+var (
+	ErrorUnknownPasswordHash = errors.New("password hash format is not supported")
+	ErrorUnknownPepper       = errors.New("password hash was made with a pepper that is not configured")
+)
+
+// PasswordHasher - hashes passwords into self describing strings so the
+// parameters can change without breaking the hashes already stored
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash
+	Verify(password string, encoded string) (bool, error)
+	// NeedsRehash reports whether the hash was made by another algorithm or
+	// with other parameters than the ones currently configured
+	NeedsRehash(encoded string) bool
+}
+
+// Pepper - secrets kept out of the database that passwords are run through
+// with HMAC-SHA256 before they are hashed, so a dump of the users table is not
+// enough to guess passwords offline. Keys maps each version to its secret and
+// new hashes use Version, an empty Version means no pepper.
+type Pepper struct {
+	Version string
+	Keys    map[string][]byte
+}
+
+// apply - the hasher input for the password under the pepper version
+func (p Pepper) apply(version string, password string) ([]byte, error) {
+	if version == "" {
+		return []byte(password), nil
+	}
+	key, ok := p.Keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: version %s", ErrorUnknownPepper, version)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return mac.Sum(nil), nil
+}
+
+// Argon2idHasher - RFC 9106 Argon2id, hashes are stored in the PHC string
+// format $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>. Peppered hashes carry
+// the pepper version in the keyid parameter, m=65536,t=3,p=4,keyid=<version>.
+type Argon2idHasher struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	Pepper      Pepper
+}
+
+// argon2idParams - the parameters recorded in a PHC string
+type argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	// PepperVersion is decoded from keyid
+	PepperVersion string
+}
+
+// NewPasswordHasher - the hasher named in the config, argon2id is the only
+// one new hashes are made with
+func NewPasswordHasher(config Config) (PasswordHasher, error) {
+	switch config.PasswordHasher {
+	case PasswordHasherArgon2id, "":
+		if config.Argon2Iterations == 0 || config.Argon2Parallelism == 0 {
+			return nil, fmt.Errorf("argon2id needs at least one iteration and one lane")
+		}
+		pepper, err := newPepper(config)
+		if err != nil {
+			return nil, err
+		}
+		return Argon2idHasher{
+			Memory:      config.Argon2Memory,
+			Iterations:  config.Argon2Iterations,
+			Parallelism: config.Argon2Parallelism,
+			Pepper:      pepper,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported password hasher %q", config.PasswordHasher)
+	}
+}
+
+// newPepper - decodes the configured peppers, older versions are kept so the
+// hashes made with them can be verified and rehashed on login
+func newPepper(config Config) (Pepper, error) {
+	pepper := Pepper{Version: config.PasswordPepperVersion, Keys: map[string][]byte{}}
+	for version, secret := range config.PasswordPeppers {
+		key, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return Pepper{}, fmt.Errorf("pepper %s is not base64 encoded: %w", version, err)
+		}
+		if len(key) < minPepperLength {
+			return Pepper{}, fmt.Errorf("pepper %s must be at least %d bytes", version, minPepperLength)
+		}
+		pepper.Keys[version] = key
+	}
+	if _, ok := pepper.Keys[pepper.Version]; pepper.Version != "" && !ok {
+		return Pepper{}, fmt.Errorf("current pepper version %s is not configured", pepper.Version)
+	}
+	return pepper, nil
+}
+
+func (a Argon2idHasher) Hash(password string) (string, error) {
+	input, err := a.Pepper.apply(a.Pepper.Version, password)
+	if err != nil {
+		return "", err
+	}
+	salt := make([]byte, argon2idSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(input, salt, a.Iterations, a.Memory, a.Parallelism, argon2idKeyLength)
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", a.Memory, a.Iterations, a.Parallelism)
+	if a.Pepper.Version != "" {
+		params += ",keyid=" + base64.RawStdEncoding.EncodeToString([]byte(a.Pepper.Version))
+	}
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s",
+		argon2.Version, params,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2idHasher) Verify(password string, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	input, err := a.Pepper.apply(params.PepperVersion, password)
+	if err != nil {
+		return false, err
+	}
+	computed := argon2.IDKey(input, salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+func (a Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	current := argon2idParams{
+		Memory:        a.Memory,
+		Iterations:    a.Iterations,
+		Parallelism:   a.Parallelism,
+		PepperVersion: a.Pepper.Version,
+	}
+	return params != current || len(salt) != argon2idSaltLength || len(key) != argon2idKeyLength
+}
+
+// decodeArgon2id - splits a PHC string into its parameters, salt and hash
+func decodeArgon2id(encoded string) (argon2idParams, []byte, []byte, error) {
+	var params argon2idParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != PasswordHasherArgon2id {
+		return params, nil, nil, ErrorUnknownPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: argon2 version %s", ErrorUnknownPasswordHash, parts[2])
+	}
+	costs, keyID, _ := strings.Cut(parts[3], ",keyid=")
+	if _, err := fmt.Sscanf(costs, "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %s", ErrorUnknownPasswordHash, err)
+	}
+	if keyID != "" {
+		version, err := base64.RawStdEncoding.DecodeString(keyID)
+		if err != nil || len(version) == 0 {
+			return params, nil, nil, fmt.Errorf("%w: invalid keyid", ErrorUnknownPasswordHash)
+		}
+		params.PepperVersion = string(version)
+	}
+	if params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, fmt.Errorf("%w: invalid parameters %s", ErrorUnknownPasswordHash, parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: %s", ErrorUnknownPasswordHash, err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("%w: invalid hash", ErrorUnknownPasswordHash)
+	}
+	return params, salt, key, nil
+}
+
+// isLegacyHash - hashes written before argon2id are bcrypt hashes of the
+// password followed by the salt kept in its own column
+func isLegacyHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2")
+}
+
+// verifyPassword - checks the password against the stored hash and reports
+// whether the hash should be replaced by one made with the current hasher
+func (s *Service) verifyPassword(password string, salt string, encoded string) (bool, bool) {
+	if isLegacyHash(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password+salt))
+		return err == nil, true
+	}
+	ok, err := s.Hasher.Verify(password, encoded)
+	if err != nil && errors.Is(err, ErrorUnknownPepper) {
+		log.WithError(err).Error("could not verify password")
+	}
+	if err != nil || !ok {
+		return false, false
+	}
+	return true, s.Hasher.NeedsRehash(encoded)
+}
@@ -0,0 +1,225 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func testHasher(t *testing.T, configure func(*Config)) PasswordHasher {
+	t.Helper()
+	config := testConfig()
+	if configure != nil {
+		configure(&config)
+	}
+	hasher, err := NewPasswordHasher(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := testHasher(t, nil)
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("hash %s is not an argon2id PHC string with the configured parameters", hash)
+	}
+	if again, _ := hasher.Hash("correct horse"); again == hash {
+		t.Error("two hashes of the same password are equal, the salt is not random")
+	}
+	if ok, err := hasher.Verify("correct horse", hash); !ok || err != nil {
+		t.Errorf("verify of the right password: %v, %v", ok, err)
+	}
+	if ok, _ := hasher.Verify("wrong horse", hash); ok {
+		t.Error("a wrong password verified")
+	}
+	if hasher.NeedsRehash(hash) {
+		t.Error("a hash made with the current parameters needs a rehash")
+	}
+
+	stronger := testHasher(t, func(config *Config) { config.Argon2Iterations = 2 })
+	if ok, err := stronger.Verify("correct horse", hash); !ok || err != nil {
+		t.Errorf("a hash made with other parameters does not verify: %v, %v", ok, err)
+	}
+	if !stronger.NeedsRehash(hash) {
+		t.Error("a hash made with fewer iterations does not need a rehash")
+	}
+}
+
+func TestDecodeArgon2idRejectsMalformedHashes(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"$2a$10$abcdefghijklmnopqrstuu",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+	} {
+		if _, _, _, err := decodeArgon2id(encoded); !errors.Is(err, ErrorUnknownPasswordHash) {
+			t.Errorf("decode %q: got %v, want %v", encoded, err, ErrorUnknownPasswordHash)
+		}
+	}
+}
+
+func TestLoginRehashesPasswords(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"+"pinch of salt"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak, err := testHasher(t, func(config *Config) { config.Argon2Memory = 512 }).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		hash string
+		salt string
+	}{
+		{name: "legacy bcrypt with salt", hash: string(legacy), salt: "pinch of salt"},
+		{name: "argon2id with old parameters", hash: weak},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, store := newTestService(t, nil)
+			ctx := context.Background()
+			usr, err := store.PostUser(ctx, User{Email: "jane@example.com", Password: tt.hash})
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.salts[usr.ID] = tt.salt
+
+			if _, err := svc.Login(ctx, "jane@example.com", "wrong horse"); err == nil {
+				t.Fatal("a wrong password signed in")
+			}
+			if store.users[usr.ID].Password != tt.hash {
+				t.Fatal("a failed login replaced the hash")
+			}
+			if _, err := svc.Login(ctx, "jane@example.com", "correct horse"); err != nil {
+				t.Fatal(err)
+			}
+			rehashed := store.users[usr.ID].Password
+			if rehashed == tt.hash || svc.Hasher.NeedsRehash(rehashed) {
+				t.Fatalf("hash %s was not replaced by one of the current hasher", rehashed)
+			}
+			if _, ok := store.salts[usr.ID]; ok {
+				t.Error("the legacy salt was kept")
+			}
+			if _, err := svc.Login(ctx, "jane@example.com", "correct horse"); err != nil {
+				t.Errorf("login with the rehashed password: %v", err)
+			}
+		})
+	}
+}
+
+func TestUserJSONOmitsPassword(t *testing.T) {
+	data, err := json.Marshal(User{ID: "1", Email: "jane@example.com", Password: "$argon2id$v=19$secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "argon2id") || strings.Contains(string(data), "Password") {
+		t.Errorf("user serialized as %s", data)
+	}
+}
+
+var (
+	testPepperV1 = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("1", minPepperLength)))
+	testPepperV2 = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("2", minPepperLength)))
+)
+
+// withPeppers - configures the peppers with version as the current one
+func withPeppers(version string, peppers map[string]string) func(*Config) {
+	return func(config *Config) {
+		config.PasswordPepperVersion = version
+		config.PasswordPeppers = peppers
+	}
+}
+
+func TestPepperedHashes(t *testing.T) {
+	v1 := testHasher(t, withPeppers("1", map[string]string{"1": testPepperV1}))
+	hash, err := v1.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(hash, ",keyid="+base64.RawStdEncoding.EncodeToString([]byte("1"))+"$") {
+		t.Errorf("hash %s does not record the pepper version", hash)
+	}
+	if ok, err := v1.Verify("correct horse", hash); !ok || err != nil {
+		t.Fatalf("verify with the pepper: %v, %v", ok, err)
+	}
+
+	// the pepper is part of the hash input, the hash alone is not enough
+	unpeppered := testHasher(t, nil)
+	if _, err := unpeppered.Verify("correct horse", hash); !errors.Is(err, ErrorUnknownPepper) {
+		t.Errorf("verify without the pepper: got %v, want %v", err, ErrorUnknownPepper)
+	}
+	otherSecret := testHasher(t, withPeppers("1", map[string]string{"1": testPepperV2}))
+	if ok, _ := otherSecret.Verify("correct horse", hash); ok {
+		t.Error("the hash verified with another pepper secret")
+	}
+
+	v2 := testHasher(t, withPeppers("2", map[string]string{"1": testPepperV1, "2": testPepperV2}))
+	if ok, err := v2.Verify("correct horse", hash); !ok || err != nil {
+		t.Errorf("verify of a hash made with a previous pepper: %v, %v", ok, err)
+	}
+	if !v2.NeedsRehash(hash) {
+		t.Error("a hash made with a previous pepper does not need a rehash")
+	}
+	if v1.NeedsRehash(hash) {
+		t.Error("a hash made with the current pepper needs a rehash")
+	}
+}
+
+func TestLoginRehashesWithCurrentPepper(t *testing.T) {
+	old := testHasher(t, withPeppers("1", map[string]string{"1": testPepperV1}))
+	hash, err := old.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, store := newTestService(t, withPeppers("2", map[string]string{"1": testPepperV1, "2": testPepperV2}))
+	ctx := context.Background()
+	usr, err := store.PostUser(ctx, User{Email: "jane@example.com", Password: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.Login(ctx, "jane@example.com", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	rehashed := store.users[usr.ID].Password
+	if !strings.Contains(rehashed, ",keyid="+base64.RawStdEncoding.EncodeToString([]byte("2"))+"$") {
+		t.Errorf("hash %s was not rehashed with pepper version 2", rehashed)
+	}
+}
+
+func TestNewPepper(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		peppers map[string]string
+		wantErr bool
+	}{
+		{name: "no pepper", version: "", peppers: nil},
+		{name: "current and previous", version: "2", peppers: map[string]string{"1": testPepperV1, "2": testPepperV2}},
+		{name: "previous only", version: "", peppers: map[string]string{"1": testPepperV1}},
+		{name: "current not configured", version: "2", peppers: map[string]string{"1": testPepperV1}, wantErr: true},
+		{name: "too short", version: "1", peppers: map[string]string{"1": base64.StdEncoding.EncodeToString([]byte("short"))}, wantErr: true},
+		{name: "not base64", version: "1", peppers: map[string]string{"1": "not base64!"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			withPeppers(tt.version, tt.peppers)(&config)
+			if _, err := newPepper(config); (err != nil) != tt.wantErr {
+				t.Errorf("got %v, want an error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
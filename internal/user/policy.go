@@ -0,0 +1,223 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Password rule codes, the frontend maps them to its own messages
+const (
+	PasswordTooShort         = "too_short"
+	PasswordTooLong          = "too_long"
+	PasswordMissingLowercase = "missing_lowercase"
+	PasswordMissingUppercase = "missing_uppercase"
+	PasswordMissingDigit     = "missing_digit"
+	PasswordMissingSymbol    = "missing_symbol"
+	PasswordContainsEmail    = "contains_email"
+	PasswordDenied           = "denied"
+
+	CharacterClassLower  = "lower"
+	CharacterClassUpper  = "upper"
+	CharacterClassDigit  = "digit"
+	CharacterClassSymbol = "symbol"
+
+	// minEmailPartLength keeps short local parts such as "jo" from matching
+	// half of all passwords
+	minEmailPartLength = 3
+)
+
+var (
+	ErrorWeakPassword      = errors.New("password does not meet the password policy")
+	ErrorIncorrectPassword = errors.New("current password is incorrect")
+)
+
+// defaultDeniedPasswords - a few of the most common passwords, a longer list
+// can be loaded from PASSWORD_DENY_LIST_PATH
+var defaultDeniedPasswords = []string{
+	"password", "password1", "password123", "passw0rd", "12345678", "123456789",
+	"1234567890", "qwerty123", "qwertyuiop", "11111111", "iloveyou", "letmein1",
+	"welcome1", "admin123", "abc12345", "changeme", "meathub1", "meathub123",
+}
+
+// PasswordViolation - one broken rule, Limit is the configured bound of the
+// length rules
+type PasswordViolation struct {
+	Code  string `json:"code"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// PasswordPolicyError - every rule the password broke, it matches
+// ErrorWeakPassword with errors.Is
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	codes := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		codes = append(codes, violation.Code)
+	}
+	return fmt.Sprintf("%s: %s", ErrorWeakPassword, strings.Join(codes, ", "))
+}
+
+func (e *PasswordPolicyError) Is(target error) bool {
+	return target == ErrorWeakPassword
+}
+
+// PasswordPolicy - the rules passwords are checked against when they are set,
+// lengths count characters rather than bytes
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// RequiredClasses lists the character classes a password needs, any of
+	// lower, upper, digit and symbol
+	RequiredClasses []string
+	// DisallowEmail rejects passwords containing the email or its local part
+	DisallowEmail bool
+	// Denied holds lower cased passwords that are never accepted
+	Denied map[string]struct{}
+}
+
+// NewPasswordPolicy - the policy described by the config, the deny list file
+// has one password per line
+func NewPasswordPolicy(config Config) (PasswordPolicy, error) {
+	policy := PasswordPolicy{
+		MinLength:       config.PasswordMinLength,
+		MaxLength:       config.PasswordMaxLength,
+		RequiredClasses: config.PasswordRequiredClasses,
+		DisallowEmail:   config.PasswordDisallowEmail,
+		Denied:          map[string]struct{}{},
+	}
+	for _, class := range policy.RequiredClasses {
+		if _, ok := characterClassViolations[class]; !ok {
+			return PasswordPolicy{}, fmt.Errorf("unknown password character class %q", class)
+		}
+	}
+	if policy.MaxLength > 0 && policy.MaxLength < policy.MinLength {
+		return PasswordPolicy{}, fmt.Errorf("password max length %d is below the min length %d", policy.MaxLength, policy.MinLength)
+	}
+	for _, password := range defaultDeniedPasswords {
+		policy.Denied[password] = struct{}{}
+	}
+	if config.PasswordDenyListPath == "" {
+		return policy, nil
+	}
+	file, err := os.Open(config.PasswordDenyListPath)
+	if err != nil {
+		return PasswordPolicy{}, fmt.Errorf("could not open password deny list: %w", err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			policy.Denied[strings.ToLower(password)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return PasswordPolicy{}, fmt.Errorf("could not read password deny list: %w", err)
+	}
+	return policy, nil
+}
+
+var characterClassViolations = map[string]string{
+	CharacterClassLower:  PasswordMissingLowercase,
+	CharacterClassUpper:  PasswordMissingUppercase,
+	CharacterClassDigit:  PasswordMissingDigit,
+	CharacterClassSymbol: PasswordMissingSymbol,
+}
+
+// Check - returns a *PasswordPolicyError listing every rule the password of
+// the user with the given email breaks, nil when it is acceptable
+func (p PasswordPolicy) Check(password string, email string) error {
+	var violations []PasswordViolation
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength || length == 0 {
+		violations = append(violations, PasswordViolation{Code: PasswordTooShort, Limit: p.MinLength})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, PasswordViolation{Code: PasswordTooLong, Limit: p.MaxLength})
+	}
+
+	present := characterClasses(password)
+	for _, class := range p.RequiredClasses {
+		if !present[class] {
+			violations = append(violations, PasswordViolation{Code: characterClassViolations[class]})
+		}
+	}
+
+	lower := strings.ToLower(password)
+	if p.DisallowEmail && containsEmail(lower, strings.ToLower(email)) {
+		violations = append(violations, PasswordViolation{Code: PasswordContainsEmail})
+	}
+	if _, denied := p.Denied[lower]; denied {
+		violations = append(violations, PasswordViolation{Code: PasswordDenied})
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// characterClasses - the classes found in the password, anything that is not
+// a letter or a digit counts as a symbol
+func characterClasses(password string) map[string]bool {
+	present := map[string]bool{}
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			present[CharacterClassLower] = true
+		case unicode.IsUpper(r):
+			present[CharacterClassUpper] = true
+		case unicode.IsDigit(r):
+			present[CharacterClassDigit] = true
+		case !unicode.IsLetter(r):
+			present[CharacterClassSymbol] = true
+		}
+	}
+	return present
+}
+
+// containsEmail - reports whether the lower cased password contains the email
+// or the part of it before the @
+func containsEmail(password string, email string) bool {
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return len(local) >= minEmailPartLength && strings.Contains(password, local)
+}
+
+// ChangePassword - replaces the password of the user after checking the
+// current one, the new password has to pass the password policy and must not
+// be a breached one
+func (s *Service) ChangePassword(ctx context.Context, userID string, currentPassword string, newPassword string) error {
+	usr, err := s.Store.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	usr, salt, err := s.Store.GetUserAndSaltByEmail(ctx, usr.Email)
+	if err != nil {
+		return err
+	}
+	if ok, _ := s.verifyPassword(currentPassword, salt, usr.Password); !ok {
+		return ErrorIncorrectPassword
+	}
+	if err := s.checkPassword(newPassword, usr.Email); err != nil {
+		return err
+	}
+	hash, err := s.Hasher.Hash(newPassword)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %w", err)
+	}
+	return s.Store.UpdateUserPassword(ctx, usr.ID, hash)
+}
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func violationCodes(err error) []string {
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return nil
+	}
+	var codes []string
+	for _, violation := range policyErr.Violations {
+		codes = append(codes, violation.Code)
+	}
+	return codes
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{
+		MinLength:       8,
+		MaxLength:       16,
+		RequiredClasses: []string{CharacterClassLower, CharacterClassUpper, CharacterClassDigit},
+		DisallowEmail:   true,
+		Denied:          map[string]struct{}{"password1a": {}},
+	}
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{name: "acceptable", password: "Brisket42", want: nil},
+		{name: "lengths count characters", password: "Ünïcödé1", want: nil},
+		{name: "empty", password: "", want: []string{PasswordTooShort, PasswordMissingLowercase, PasswordMissingUppercase, PasswordMissingDigit}},
+		{name: "too short", password: "Abc12", want: []string{PasswordTooShort}},
+		{name: "too long", password: "Brisket42Brisket42", want: []string{PasswordTooLong}},
+		{name: "missing classes", password: "brisketbrisket", want: []string{PasswordMissingUppercase, PasswordMissingDigit}},
+		{name: "contains the email", password: "Jane.doe@Example.com1", want: []string{PasswordTooLong, PasswordContainsEmail}},
+		{name: "contains the local part", password: "XJane.Doe9", want: []string{PasswordContainsEmail}},
+		{name: "denied in any case", password: "PASSWORD1a", want: []string{PasswordDenied}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.password, "jane.doe@example.com")
+			if got := violationCodes(err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("violations = %v, want %v", got, tt.want)
+			}
+			if tt.want != nil && !errors.Is(err, ErrorWeakPassword) {
+				t.Errorf("%v does not match ErrorWeakPassword", err)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyShortLocalPart(t *testing.T) {
+	policy := PasswordPolicy{DisallowEmail: true}
+	if err := policy.Check("jostling", "jo@example.com"); err != nil {
+		t.Errorf("a local part shorter than %d characters was matched: %v", minEmailPartLength, err)
+	}
+}
+
+func TestNewPasswordPolicy(t *testing.T) {
+	denyList := filepath.Join(t.TempDir(), "deny.txt")
+	if err := os.WriteFile(denyList, []byte("Hunter2Hunter2\n\n  sausages  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config := testConfig()
+	config.PasswordMinLength = 8
+	config.PasswordDenyListPath = denyList
+	policy, err := NewPasswordPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"hunter2hunter2", "SAUSAGES", "password123"} {
+		if got := violationCodes(policy.Check(password, "")); !reflect.DeepEqual(got, []string{PasswordDenied}) {
+			t.Errorf("%s: violations = %v, want denied", password, got)
+		}
+	}
+
+	for name, change := range map[string]func(*Config){
+		"unknown class":     func(c *Config) { c.PasswordRequiredClasses = []string{"emoji"} },
+		"max below min":     func(c *Config) { c.PasswordMaxLength = 4 },
+		"missing deny list": func(c *Config) { c.PasswordDenyListPath = filepath.Join(t.TempDir(), "missing.txt") },
+	} {
+		config := testConfig()
+		config.PasswordMinLength = 8
+		change(&config)
+		if _, err := NewPasswordPolicy(config); err == nil {
+			t.Errorf("%s: the config was accepted", name)
+		}
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	svc, store := newTestService(t, func(config *Config) {
+		config.PasswordMinLength = 8
+	})
+	ctx := context.Background()
+	usr, err := svc.PostUser(ctx, User{Email: "jane@example.com", Password: "first brisket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.ChangePassword(ctx, usr.ID, "wrong brisket", "second brisket"); !errors.Is(err, ErrorIncorrectPassword) {
+		t.Errorf("wrong current password: got %v, want %v", err, ErrorIncorrectPassword)
+	}
+	if err := svc.ChangePassword(ctx, usr.ID, "first brisket", "short"); !errors.Is(err, ErrorWeakPassword) {
+		t.Errorf("weak new password: got %v, want %v", err, ErrorWeakPassword)
+	}
+	if err := svc.ChangePassword(ctx, usr.ID, "first brisket", "second brisket"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Login(ctx, "jane@example.com", "first brisket"); err == nil {
+		t.Error("the old password still signs in")
+	}
+	if _, err := svc.Login(ctx, "jane@example.com", "second brisket"); err != nil {
+		t.Errorf("the new password does not sign in: %v", err)
+	}
+	if store.users[usr.ID].Password == "second brisket" {
+		t.Error("the new password was stored in plain text")
+	}
+}
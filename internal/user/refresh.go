@@ -0,0 +1,152 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	refreshTokenLength = 32
+)
+
+var (
+	ErrorInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrorRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// RefreshToken - server side record of an opaque refresh token. Only the
+// hash of the token is stored, every token belongs to a family that starts
+// at login and is rotated on each use.
+type RefreshToken struct {
+	ID        string
+	UserID    string
+	FamilyID  string
+	TokenHash string
+	// ClientID is the OAuth client the family was issued to, it is empty
+	// for tokens issued by /auth/login
+	ClientID string
+	Scope    string
+	// JKT is the thumbprint of the DPoP key the family is bound to
+	JKT       string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(context.Context, RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error)
+	// MarkRefreshTokenUsed returns false when the token was already used
+	MarkRefreshTokenUsed(ctx context.Context, id string) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeClientRefreshTokens(ctx context.Context, userID string, clientID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID string) error
+	DeleteExpiredRefreshTokens(ctx context.Context) (int64, error)
+}
+
+// GenerateRefreshToken - issues a refresh token that starts a new family,
+// the family is bound to the DPoP key thumbprint when one is given
+func (s *Service) GenerateRefreshToken(ctx context.Context, user User, jkt string) (string, error) {
+	token, _, err := s.startRefreshTokenFamily(ctx, user.ID, "", "", jkt)
+	return token, err
+}
+
+// startRefreshTokenFamily - issues the first refresh token of a new family
+// and returns it along with the id of the family
+func (s *Service) startRefreshTokenFamily(ctx context.Context, userID string, clientID string, scope string, jkt string) (string, string, error) {
+	familyID, err := randomToken(refreshTokenLength)
+	if err != nil {
+		return "", "", err
+	}
+	token, err := s.issueRefreshToken(ctx, RefreshToken{
+		UserID:   userID,
+		FamilyID: familyID,
+		ClientID: clientID,
+		Scope:    scope,
+		JKT:      jkt,
+	})
+	return token, familyID, err
+}
+
+// rotateRefreshToken - exchanges a refresh token for the user it belongs to
+// and the next token of its family. Presenting a token that was already used
+// revokes the whole family, as it means the token has leaked. A family can
+// only be rotated by the client it was issued to and, when bound to a DPoP
+// key, with a proof signed by that key.
+func (s *Service) rotateRefreshToken(ctx context.Context, token string, clientID string, jkt string) (RefreshToken, User, string, error) {
+	if use, ok := s.tokenUseOf(token); ok {
+		return RefreshToken{}, User{}, "", &TokenTypeError{Expected: TokenUseRefresh, Actual: use}
+	}
+	stored, err := s.Store.GetRefreshToken(ctx, hashToken(token))
+	if err != nil {
+		return RefreshToken{}, User{}, "", ErrorInvalidRefreshToken
+	}
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) || stored.ClientID != clientID {
+		return RefreshToken{}, User{}, "", ErrorInvalidRefreshToken
+	}
+	if stored.JKT != "" && stored.JKT != jkt {
+		return RefreshToken{}, User{}, "", ErrorDPoPBindingMismatch
+	}
+
+	fresh, err := s.Store.MarkRefreshTokenUsed(ctx, stored.ID)
+	if err != nil {
+		return RefreshToken{}, User{}, "", fmt.Errorf("could not use refresh token: %w", err)
+	}
+	if stored.UsedAt != nil || !fresh {
+		log.Warnf("refresh token reuse detected, revoking family %s", stored.FamilyID)
+		if err := s.Store.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+			return RefreshToken{}, User{}, "", fmt.Errorf("could not revoke refresh token family: %w", err)
+		}
+		return RefreshToken{}, User{}, "", ErrorRefreshTokenReused
+	}
+
+	usr, err := s.Store.GetUser(ctx, stored.UserID)
+	if err != nil {
+		return RefreshToken{}, User{}, "", err
+	}
+	next, err := s.issueRefreshToken(ctx, RefreshToken{
+		UserID:   usr.ID,
+		FamilyID: stored.FamilyID,
+		ClientID: stored.ClientID,
+		Scope:    stored.Scope,
+		JKT:      stored.JKT,
+	})
+	if err != nil {
+		return RefreshToken{}, User{}, "", err
+	}
+	return stored, usr, next, nil
+}
+
+// issueRefreshToken - creates the next token of the family described by the record
+func (s *Service) issueRefreshToken(ctx context.Context, record RefreshToken) (string, error) {
+	token, err := randomToken(refreshTokenLength)
+	if err != nil {
+		return "", err
+	}
+	record.TokenHash = hashToken(token)
+	record.ExpiresAt = time.Now().Add(s.Config.RefreshTokenTTL)
+	if err := s.Store.CreateRefreshToken(ctx, record); err != nil {
+		return "", fmt.Errorf("could not store refresh token: %w", err)
+	}
+	return token, nil
+}
+
+func randomToken(length int) (string, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encodeSegment(b), nil
+}
+
+// hashToken - opaque tokens are only ever stored as their sha256 hash
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,87 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	svc, store := newTestService(t, nil)
+	ctx := context.Background()
+	usr, err := store.PostUser(ctx, User{Email: "jane@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := svc.GenerateRefreshToken(ctx, usr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := svc.RefreshTokenGrant(ctx, first, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.RefreshToken == "" || rotated.RefreshToken == first {
+		t.Fatalf("refresh token was not rotated, got %q", rotated.RefreshToken)
+	}
+	claims, err := svc.ValidateToken(ctx, rotated.AccessToken)
+	if err != nil || claims["sub"] != usr.ID {
+		t.Fatalf("access token of the refresh: claims %v, %v", claims, err)
+	}
+	next, err := svc.RefreshTokenGrant(ctx, rotated.RefreshToken, "", "")
+	if err != nil {
+		t.Fatalf("the rotated refresh token does not work: %v", err)
+	}
+
+	// the first token was already rotated, using it again means it leaked
+	if _, err := svc.RefreshTokenGrant(ctx, first, "", ""); !errors.Is(err, ErrorRefreshTokenReused) {
+		t.Fatalf("reused refresh token: got %v, want %v", err, ErrorRefreshTokenReused)
+	}
+	if _, err := svc.RefreshTokenGrant(ctx, next.RefreshToken, "", ""); !errors.Is(err, ErrorInvalidRefreshToken) {
+		t.Errorf("latest token of a family with a reused token: got %v, want %v", err, ErrorInvalidRefreshToken)
+	}
+}
+
+func TestRefreshTokenRejected(t *testing.T) {
+	svc, store := newTestService(t, nil)
+	ctx := context.Background()
+	usr, err := store.PostUser(ctx, User{Email: "jane@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, err := svc.GenerateToken(ctx, usr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newRefreshToken := func() string {
+		token, err := svc.GenerateRefreshToken(ctx, usr, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	expired := newRefreshToken()
+	stored := store.refreshTokens[hashToken(expired)]
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	store.refreshTokens[hashToken(expired)] = stored
+
+	tests := []struct {
+		name     string
+		token    string
+		clientID string
+	}{
+		{name: "unknown", token: "not-a-refresh-token"},
+		{name: "expired", token: expired},
+		{name: "issued to another client", token: newRefreshToken(), clientID: "web"},
+		{name: "access token", token: accessToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := svc.RefreshTokenGrant(ctx, tt.token, tt.clientID, ""); err == nil {
+				t.Error("the refresh was accepted")
+			}
+		})
+	}
+}
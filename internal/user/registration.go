@@ -0,0 +1,213 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	AuthMethodNone              = "none"
+	AuthMethodClientSecretBasic = "client_secret_basic"
+	AuthMethodClientSecretPost  = "client_secret_post"
+
+	clientIDLength          = 16
+	registrationTokenLength = 32
+)
+
+var (
+	ErrorInvalidClientMetadata = errors.New("invalid client metadata")
+	ErrorInvalidRedirectURIs   = errors.New("invalid redirect uris")
+	ErrorInvalidRegistration   = errors.New("invalid registration access token")
+)
+
+// defaultClientScopes - scopes of a registered client that did not ask for any
+var defaultClientScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// ClientMetadata - RFC 7591 client metadata a client registers with
+type ClientMetadata struct {
+	ClientName              string
+	RedirectURIs            []string
+	GrantTypes              []string
+	TokenEndpointAuthMethod string
+	Scopes                  []string
+}
+
+// ClientRegistration - a registered client with the credentials that are
+// only handed out when they are issued
+type ClientRegistration struct {
+	Client       Client
+	ClientSecret string
+	// RegistrationAccessToken lets the client read, update and delete its
+	// own registration
+	RegistrationAccessToken string
+}
+
+// RegisterClient - creates a client from its metadata, confidential clients
+// get a secret and every client gets a registration access token
+func (s *Service) RegisterClient(ctx context.Context, metadata ClientMetadata) (ClientRegistration, error) {
+	client, err := s.clientFromMetadata(metadata)
+	if err != nil {
+		return ClientRegistration{}, err
+	}
+	client.ID, err = randomToken(clientIDLength)
+	if err != nil {
+		return ClientRegistration{}, err
+	}
+	client.CreatedAt = time.Now()
+
+	registration := ClientRegistration{}
+	registration.RegistrationAccessToken, err = randomToken(registrationTokenLength)
+	if err != nil {
+		return ClientRegistration{}, err
+	}
+	client.RegistrationTokenHash = hashToken(registration.RegistrationAccessToken)
+	if client.TokenEndpointAuthMethod != AuthMethodNone {
+		registration.ClientSecret, err = randomToken(clientSecretLength)
+		if err != nil {
+			return ClientRegistration{}, err
+		}
+		client.SecretHash = hashToken(registration.ClientSecret)
+	}
+
+	if err := s.Store.CreateClient(ctx, client); err != nil {
+		return ClientRegistration{}, fmt.Errorf("could not store client: %w", err)
+	}
+	registration.Client = client
+	return registration, nil
+}
+
+// AuthenticateRegistration - checks the registration access token a client
+// manages its registration with
+func (s *Service) AuthenticateRegistration(ctx context.Context, clientID string, token string) (Client, error) {
+	client, err := s.Store.GetClient(ctx, clientID)
+	if err != nil || client.RegistrationTokenHash == "" {
+		return Client{}, ErrorInvalidRegistration
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(client.RegistrationTokenHash)) != 1 {
+		return Client{}, ErrorInvalidRegistration
+	}
+	return client, nil
+}
+
+// UpdateClientRegistration - replaces the metadata of a registered client.
+// A client that becomes confidential gets a secret, one that becomes public
+// loses it.
+func (s *Service) UpdateClientRegistration(ctx context.Context, client Client, metadata ClientMetadata) (ClientRegistration, error) {
+	updated, err := s.clientFromMetadata(metadata)
+	if err != nil {
+		return ClientRegistration{}, err
+	}
+	updated.ID = client.ID
+	updated.CreatedAt = client.CreatedAt
+	updated.RegistrationTokenHash = client.RegistrationTokenHash
+	updated.SecretHash = client.SecretHash
+
+	registration := ClientRegistration{}
+	switch {
+	case updated.TokenEndpointAuthMethod == AuthMethodNone:
+		updated.SecretHash = ""
+	case updated.SecretHash == "":
+		registration.ClientSecret, err = randomToken(clientSecretLength)
+		if err != nil {
+			return ClientRegistration{}, err
+		}
+		updated.SecretHash = hashToken(registration.ClientSecret)
+	}
+
+	if err := s.Store.UpdateClient(ctx, updated); err != nil {
+		return ClientRegistration{}, fmt.Errorf("could not update client: %w", err)
+	}
+	registration.Client = updated
+	return registration, nil
+}
+
+// DeleteClientRegistration - removes a registered client, its refresh tokens
+// stop working as the client can no longer authenticate
+func (s *Service) DeleteClientRegistration(ctx context.Context, client Client) error {
+	return s.Store.DeleteClient(ctx, client.ID)
+}
+
+// clientFromMetadata - validates the metadata and fills in the RFC 7591
+// defaults, clients may only register the configured registrable scopes
+func (s *Service) clientFromMetadata(metadata ClientMetadata) (Client, error) {
+	client := Client{
+		Name:                    metadata.ClientName,
+		RedirectURIs:            metadata.RedirectURIs,
+		GrantTypes:              metadata.GrantTypes,
+		TokenEndpointAuthMethod: metadata.TokenEndpointAuthMethod,
+		Scopes:                  metadata.Scopes,
+	}
+	if len(client.GrantTypes) == 0 {
+		client.GrantTypes = []string{GrantTypeAuthorizationCode}
+	}
+	if client.TokenEndpointAuthMethod == "" {
+		client.TokenEndpointAuthMethod = AuthMethodClientSecretBasic
+	}
+	if len(client.Scopes) == 0 {
+		client.Scopes = defaultClientScopes
+	}
+
+	switch client.TokenEndpointAuthMethod {
+	case AuthMethodNone, AuthMethodClientSecretBasic, AuthMethodClientSecretPost:
+	default:
+		return Client{}, fmt.Errorf("%w: unsupported token_endpoint_auth_method %q", ErrorInvalidClientMetadata, client.TokenEndpointAuthMethod)
+	}
+	for _, scope := range client.Scopes {
+		if !contains(s.Config.RegistrableScopes, scope) {
+			return Client{}, fmt.Errorf("%w: scope %q can not be registered", ErrorInvalidClientMetadata, scope)
+		}
+	}
+	for _, grantType := range client.GrantTypes {
+		switch grantType {
+		case GrantTypeAuthorizationCode, GrantTypeRefreshToken, GrantTypeDeviceCode:
+		case GrantTypeClientCredentials, GrantTypeTokenExchange:
+			if client.TokenEndpointAuthMethod == AuthMethodNone {
+				return Client{}, fmt.Errorf("%w: %s needs a confidential client", ErrorInvalidClientMetadata, grantType)
+			}
+		default:
+			return Client{}, fmt.Errorf("%w: unsupported grant type %q", ErrorInvalidClientMetadata, grantType)
+		}
+	}
+
+	if client.AllowsGrant(GrantTypeAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		return Client{}, fmt.Errorf("%w: at least one is required", ErrorInvalidRedirectURIs)
+	}
+	for _, uri := range client.RedirectURIs {
+		parsed, err := url.Parse(uri)
+		if err != nil || parsed.Scheme == "" || parsed.Fragment != "" {
+			return Client{}, fmt.Errorf("%w: %q must be an absolute uri without a fragment", ErrorInvalidRedirectURIs, uri)
+		}
+		if !allowedRedirectScheme(parsed) {
+			return Client{}, fmt.Errorf("%w: %q must use https, http on a loopback host or a private-use scheme such as com.example.app", ErrorInvalidRedirectURIs, uri)
+		}
+	}
+	return client, nil
+}
+
+// allowedRedirectScheme - RFC 8252 redirect uris, native apps may use http on
+// a loopback host and private-use schemes in reverse domain form. Schemes
+// without a dot such as javascript, data and file are refused.
+func allowedRedirectScheme(uri *url.URL) bool {
+	switch scheme := strings.ToLower(uri.Scheme); scheme {
+	case "https":
+		return uri.Host != ""
+	case "http":
+		return isLoopbackHost(uri.Hostname())
+	default:
+		return strings.Contains(scheme, ".")
+	}
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,172 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	passwordResetTokenLength = 32
+	// passwordResetWorkers send the queued reset emails, requests arriving
+	// while passwordResetQueueSize of them wait are dropped
+	passwordResetWorkers   = 4
+	passwordResetQueueSize = 100
+)
+
+var (
+	ErrorInvalidResetToken = errors.New("password reset token is invalid or expired")
+)
+
+// PasswordReset - a reset token sent to the user, only its hash is stored
+type PasswordReset struct {
+	TokenHash string
+	UserID    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type PasswordResetStore interface {
+	CreatePasswordReset(context.Context, PasswordReset) error
+	GetPasswordReset(ctx context.Context, tokenHash string) (PasswordReset, error)
+	// PasswordResetRequestedSince tells whether a reset token was created for
+	// the user after the time
+	PasswordResetRequestedSince(ctx context.Context, userID string, since time.Time) (bool, error)
+	// ResetUserPassword uses up the token, sets the password hash and revokes
+	// every token of the user issued before revokedBefore in one transaction.
+	// It returns false, changing nothing, when the token was already used.
+	ResetUserPassword(ctx context.Context, tokenHash string, userID string, passwordHash string, revokedBefore time.Time) (bool, error)
+	DeleteUserPasswordResets(ctx context.Context, userID string) error
+	DeleteExpiredPasswordResets(ctx context.Context) (int64, error)
+}
+
+// ForgotPassword - emails a reset link to the user with the email. All the
+// work happens in the background, so neither the answer nor the response time
+// tells the caller whether the email is registered.
+func (s *Service) ForgotPassword(email string) {
+	select {
+	case s.passwordResets <- email:
+	default:
+		log.Warn("password reset queue is full, dropping a request")
+	}
+}
+
+// RunPasswordResets - sends the reset emails ForgotPassword queued until the
+// context is done
+func (s *Service) RunPasswordResets(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < passwordResetWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case email := <-s.passwordResets:
+					if err := s.sendPasswordReset(ctx, email); err != nil {
+						log.WithError(err).Error("could not send a password reset email")
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// sendPasswordReset - stores a new reset token for the user with the email and
+// mails the link, unknown emails and users who got a link less than
+// PasswordResetCooldown ago are ignored
+func (s *Service) sendPasswordReset(ctx context.Context, email string) error {
+	usr, err := s.Store.GetUserByEmail(ctx, email)
+	if err != nil || usr.ID == "" {
+		return nil
+	}
+	recent, err := s.Store.PasswordResetRequestedSince(ctx, usr.ID, time.Now().Add(-s.Config.PasswordResetCooldown))
+	if err != nil {
+		return fmt.Errorf("could not look up previous reset tokens: %w", err)
+	}
+	if recent {
+		return nil
+	}
+	token, err := randomToken(passwordResetTokenLength)
+	if err != nil {
+		return err
+	}
+	// only the latest link works
+	if err := s.Store.DeleteUserPasswordResets(ctx, usr.ID); err != nil {
+		return fmt.Errorf("could not drop previous reset tokens: %w", err)
+	}
+	now := time.Now()
+	err = s.Store.CreatePasswordReset(ctx, PasswordReset{
+		TokenHash: hashToken(token),
+		UserID:    usr.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.Config.PasswordResetTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("could not store reset token: %w", err)
+	}
+	link := *s.passwordResetURL
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	err = s.Mailer.Send(ctx, Message{
+		To:      usr.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your account.\n\n"+
+			"Open %s to pick a new one, the link works once and expires in %s.\n\n"+
+			"If it was not you, you can ignore this email.\n", link.String(), s.Config.PasswordResetTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("could not mail user %s: %w", usr.ID, err)
+	}
+	return nil
+}
+
+// newPasswordResetURL - the page reset links point to, it has to be absolute
+func newPasswordResetURL(config Config) (*url.URL, error) {
+	link, err := url.Parse(config.PasswordResetURL)
+	if err != nil || link.Scheme == "" || link.Host == "" {
+		return nil, fmt.Errorf("PASSWORD_RESET_URL must be an absolute url, got %q", config.PasswordResetURL)
+	}
+	return link, nil
+}
+
+// ResetPassword - sets the password of the user the token was sent to. The
+// token can only be used once and every token issued to the user before the
+// reset is revoked, so every session has to sign in again.
+func (s *Service) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	tokenHash := hashToken(token)
+	reset, err := s.Store.GetPasswordReset(ctx, tokenHash)
+	if err != nil || time.Now().After(reset.ExpiresAt) {
+		return ErrorInvalidResetToken
+	}
+	usr, err := s.Store.GetUser(ctx, reset.UserID)
+	if err != nil {
+		return err
+	}
+	// a rejected password leaves the token usable for another try
+	if err := s.checkPassword(newPassword, usr.Email); err != nil {
+		return err
+	}
+	hash, err := s.Hasher.Hash(newPassword)
+	if err != nil {
+		return fmt.Errorf("error hashing password: %w", err)
+	}
+	// iat only has second precision, the cutoff is rounded up so tokens
+	// issued earlier in the same second are revoked as well
+	consumed, err := s.Store.ResetUserPassword(ctx, tokenHash, usr.ID, hash, time.Now().Truncate(time.Second).Add(time.Second))
+	if err != nil {
+		return fmt.Errorf("could not reset password: %w", err)
+	}
+	if !consumed {
+		return ErrorInvalidResetToken
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMailer - keeps the messages instead of sending them
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func (m *recordingMailer) sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// newResetService - a service mailing reset links to a recording mailer and
+// a user signed up with the password "first brisket"
+func newResetService(t *testing.T) (*Service, *memoryStore, *recordingMailer, User) {
+	t.Helper()
+	svc, store := newTestService(t, func(config *Config) {
+		config.PasswordMinLength = 8
+		config.PasswordResetTTL = time.Minute * 30
+		config.PasswordResetCooldown = time.Minute * 5
+	})
+	mailer := &recordingMailer{}
+	svc.Mailer = mailer
+	usr, err := svc.PostUser(context.Background(), User{Email: "jane@example.com", Password: "first brisket"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc, store, mailer, usr
+}
+
+// resetToken - the token of the reset link in the message
+func resetToken(t *testing.T, message Message) string {
+	t.Helper()
+	for _, field := range strings.Fields(message.Body) {
+		if link, err := url.Parse(field); err == nil && strings.HasPrefix(field, "http://app.test/reset-password") {
+			return link.Query().Get("token")
+		}
+	}
+	t.Fatalf("no reset link in %q", message.Body)
+	return ""
+}
+
+// requestReset - sends a reset link to the user and returns its token
+func requestReset(t *testing.T, svc *Service, mailer *recordingMailer, email string) string {
+	t.Helper()
+	if err := svc.sendPasswordReset(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+	sent := mailer.sent()
+	if len(sent) == 0 || sent[len(sent)-1].To != email {
+		t.Fatalf("no reset link was mailed to %s", email)
+	}
+	return resetToken(t, sent[len(sent)-1])
+}
+
+func TestResetPasswordRevokesTokens(t *testing.T) {
+	svc, _, mailer, usr := newResetService(t)
+	ctx := context.Background()
+	accessToken, err := svc.GenerateToken(ctx, usr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshToken, err := svc.GenerateRefreshToken(ctx, usr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := requestReset(t, svc, mailer, usr.Email)
+
+	// the tokens were most likely issued in the same second as the reset,
+	// iat alone can not tell them apart from later ones
+	if err := svc.ResetPassword(ctx, token, "second brisket"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ValidateToken(ctx, accessToken); !errors.Is(err, ErrorTokenRevoked) {
+		t.Errorf("access token issued before the reset: got %v, want %v", err, ErrorTokenRevoked)
+	}
+	if _, err := svc.RefreshTokenGrant(ctx, refreshToken, "", ""); !errors.Is(err, ErrorInvalidRefreshToken) {
+		t.Errorf("refresh token issued before the reset: got %v, want %v", err, ErrorInvalidRefreshToken)
+	}
+	if _, err := svc.Login(ctx, usr.Email, "first brisket"); err == nil {
+		t.Error("the old password still signs in")
+	}
+	if _, err := svc.Login(ctx, usr.Email, "second brisket"); err != nil {
+		t.Errorf("the new password does not sign in: %v", err)
+	}
+	if err := svc.ResetPassword(ctx, token, "third brisket"); !errors.Is(err, ErrorInvalidResetToken) {
+		t.Errorf("second use of the reset token: got %v, want %v", err, ErrorInvalidResetToken)
+	}
+}
+
+func TestResetPasswordRejected(t *testing.T) {
+	svc, store, mailer, usr := newResetService(t)
+	ctx := context.Background()
+	token := requestReset(t, svc, mailer, usr.Email)
+
+	if err := svc.ResetPassword(ctx, "not-a-reset-token", "second brisket"); !errors.Is(err, ErrorInvalidResetToken) {
+		t.Errorf("unknown token: got %v, want %v", err, ErrorInvalidResetToken)
+	}
+	if err := svc.ResetPassword(ctx, token, "short"); !errors.Is(err, ErrorWeakPassword) {
+		t.Fatalf("weak password: got %v, want %v", err, ErrorWeakPassword)
+	}
+	reset := store.resets[hashToken(token)]
+	reset.ExpiresAt = time.Now().Add(-time.Second)
+	store.resets[hashToken(token)] = reset
+	if err := svc.ResetPassword(ctx, token, "second brisket"); !errors.Is(err, ErrorInvalidResetToken) {
+		t.Errorf("expired token: got %v, want %v", err, ErrorInvalidResetToken)
+	}
+}
+
+func TestSendPasswordResetCooldown(t *testing.T) {
+	svc, store, mailer, usr := newResetService(t)
+	ctx := context.Background()
+	first := requestReset(t, svc, mailer, usr.Email)
+
+	if err := svc.sendPasswordReset(ctx, usr.Email); err != nil {
+		t.Fatal(err)
+	}
+	if sent := mailer.sent(); len(sent) != 1 {
+		t.Fatalf("mailed %d links within the cooldown, want 1", len(sent))
+	}
+
+	reset := store.resets[hashToken(first)]
+	reset.CreatedAt = time.Now().Add(-svc.Config.PasswordResetCooldown)
+	store.resets[hashToken(first)] = reset
+	second := requestReset(t, svc, mailer, usr.Email)
+	if second == first {
+		t.Fatal("the same link was mailed twice")
+	}
+	if err := svc.ResetPassword(ctx, first, "second brisket"); !errors.Is(err, ErrorInvalidResetToken) {
+		t.Errorf("link replaced by a newer one: got %v, want %v", err, ErrorInvalidResetToken)
+	}
+
+	if err := svc.sendPasswordReset(ctx, "nobody@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if sent := mailer.sent(); len(sent) != 2 {
+		t.Errorf("mailed %d links, want none for an unknown email", len(sent)-2)
+	}
+}
+
+func TestForgotPasswordQueue(t *testing.T) {
+	svc, _, mailer, usr := newResetService(t)
+
+	// with no worker running the queue fills up and requests are dropped
+	// instead of blocking the handler
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < passwordResetQueueSize+10; i++ {
+			svc.ForgotPassword(usr.Email)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("ForgotPassword blocked on a full queue")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		svc.RunPasswordResets(ctx)
+		close(stopped)
+	}()
+	deadline := time.Now().Add(time.Second * 5)
+	for len(svc.passwordResets) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	cancel()
+	<-stopped
+	// workers racing on the first request may each mail a link, the cooldown
+	// stops the rest
+	if sent := mailer.sent(); len(sent) == 0 || len(sent) > passwordResetWorkers {
+		t.Errorf("mailed %d links for one user, want between 1 and %d", len(sent), passwordResetWorkers)
+	}
+}
@@ -0,0 +1,179 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	jtiLength = 16
+)
+
+var (
+	ErrorTokenRevoked = errors.New("token has been revoked")
+)
+
+// RevocationStore - denylist of token ids that must no longer be accepted.
+// Entries only need to live until the token would have expired anyway.
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredRevokedTokens(ctx context.Context) (int64, error)
+	// RevokeUserTokens rejects every token of the user issued before the time
+	RevokeUserTokens(ctx context.Context, userID string, before time.Time) error
+	// UserTokensRevokedBefore returns the zero time when it was never set
+	UserTokensRevokedBefore(ctx context.Context, userID string) (time.Time, error)
+}
+
+// RevokeToken - puts a valid token on the denylist until it expires
+func (s *Service) RevokeToken(ctx context.Context, token string) error {
+	claims, err := s.ValidateToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.revokeValidatedToken(ctx, token, claims)
+}
+
+func (s *Service) revokeValidatedToken(ctx context.Context, token string, claims map[string]interface{}) error {
+	jti, _ := claims["jti"].(string)
+	exp, _ := numericClaim(claims, "exp")
+	// the token is accepted until exp plus the clock skew
+	if err := s.Store.RevokeToken(ctx, jti, exp.Add(s.Config.ClockSkew)); err != nil {
+		return err
+	}
+	if _, signed := s.Format.Peek(token); !signed {
+		return s.Store.DeleteAccessToken(ctx, hashToken(token))
+	}
+	return nil
+}
+
+// RevokeTokenID - puts a token id on the denylist for as long as an access
+// token issued right now would live
+func (s *Service) RevokeTokenID(ctx context.Context, jti string) error {
+	if jti == "" {
+		return ErrorInvalidToken
+	}
+	return s.Store.RevokeToken(ctx, jti, time.Now().Add(s.longestAccessTokenTTL()))
+}
+
+// Logout - revokes the access token and, when given, the refresh token
+// family. Refresh tokens of anyone but the subject of the access token are
+// left alone.
+func (s *Service) Logout(ctx context.Context, accessToken string, refreshToken string) error {
+	claims, err := s.ValidateToken(ctx, accessToken)
+	if err != nil {
+		return err
+	}
+	if err := s.revokeValidatedToken(ctx, accessToken, claims); err != nil {
+		return err
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	stored, err := s.Store.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil
+	}
+	if sub, _ := claims["sub"].(string); stored.UserID != sub {
+		log.Warnf("user %s tried to log out a refresh token of user %s", sub, stored.UserID)
+		return nil
+	}
+	if err := s.Store.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		return fmt.Errorf("could not revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+// RunTokenCleanup - drops denylist entries, opaque access tokens, refresh
+// tokens, DPoP proofs and the short lived sign in state that have expired
+// every interval. Used authorization codes are kept for as long as the tokens
+// they were redeemed for live so a replay still revokes them.
+func (s *Service) RunTokenCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if deleted, err := s.Store.DeleteExpiredRevokedTokens(ctx); err != nil {
+				log.WithError(err).Error("could not clean up revoked tokens")
+			} else if deleted > 0 {
+				log.Infof("removed %d expired revoked tokens", deleted)
+			}
+			if deleted, err := s.Store.DeleteExpiredAccessTokens(ctx); err != nil {
+				log.WithError(err).Error("could not clean up access tokens")
+			} else if deleted > 0 {
+				log.Infof("removed %d expired access tokens", deleted)
+			}
+			if deleted, err := s.Store.DeleteExpiredRefreshTokens(ctx); err != nil {
+				log.WithError(err).Error("could not clean up refresh tokens")
+			} else if deleted > 0 {
+				log.Infof("removed %d expired refresh tokens", deleted)
+			}
+			if _, err := s.Store.DeleteExpiredAuthorizationCodes(ctx, time.Now().Add(-s.Config.RefreshTokenTTL)); err != nil {
+				log.WithError(err).Error("could not clean up authorization codes")
+			}
+			if _, err := s.Store.DeleteExpiredDPoPProofs(ctx); err != nil {
+				log.WithError(err).Error("could not clean up dpop proofs")
+			}
+			if _, err := s.Store.DeleteExpiredDeviceCodes(ctx); err != nil {
+				log.WithError(err).Error("could not clean up device codes")
+			}
+			if _, err := s.Store.DeleteExpiredFederatedLogins(ctx); err != nil {
+				log.WithError(err).Error("could not clean up federated logins")
+			}
+			if _, err := s.Store.DeleteExpiredConsentChallenges(ctx); err != nil {
+				log.WithError(err).Error("could not clean up consent challenges")
+			}
+			if _, err := s.Store.DeleteExpiredPasswordResets(ctx); err != nil {
+				log.WithError(err).Error("could not clean up password reset tokens")
+			}
+		}
+	}
+}
+
+func (s *Service) checkRevoked(ctx context.Context, claims map[string]interface{}) error {
+	jti, ok := claims["jti"].(string)
+	if !ok || jti == "" {
+		return ErrorInvalidToken
+	}
+	revoked, err := s.Store.IsTokenRevoked(ctx, jti)
+	if err != nil {
+		return fmt.Errorf("could not check token revocation: %w", err)
+	}
+	if revoked {
+		return ErrorTokenRevoked
+	}
+	if IsClientToken(claims) {
+		return nil
+	}
+	// signed tokens can not be listed, a password reset rejects the ones
+	// issued before it by their iat instead
+	sub, _ := claims["sub"].(string)
+	iat, ok := numericClaim(claims, "iat")
+	if sub == "" || !ok {
+		return nil
+	}
+	before, err := s.Store.UserTokensRevokedBefore(ctx, sub)
+	if err != nil {
+		return fmt.Errorf("could not check token revocation: %w", err)
+	}
+	if iat.Before(before) {
+		return ErrorTokenRevoked
+	}
+	return nil
+}
+
+func (s *Service) longestAccessTokenTTL() time.Duration {
+	longest := s.Config.AccessTokenTTL
+	for _, ttl := range s.Config.AudienceTTLs {
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest + s.Config.ClockSkew
+}
@@ -0,0 +1,53 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLogoutOnlyRevokesOwnRefreshTokens(t *testing.T) {
+	svc, store := newTestService(t, nil)
+	ctx := context.Background()
+	jane, err := store.PostUser(ctx, User{Email: "jane@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, err := store.PostUser(ctx, User{Email: "mallory@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	janeRefresh, err := svc.GenerateRefreshToken(ctx, jane, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	malloryAccess, err := svc.GenerateToken(ctx, mallory, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := svc.Logout(ctx, malloryAccess, janeRefresh); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ValidateToken(ctx, malloryAccess); !errors.Is(err, ErrorTokenRevoked) {
+		t.Errorf("access token after logout: got %v, want %v", err, ErrorTokenRevoked)
+	}
+	if _, err := svc.RefreshTokenGrant(ctx, janeRefresh, "", ""); err != nil {
+		t.Errorf("the refresh token of another user was revoked: %v", err)
+	}
+
+	janeAccess, err := svc.GenerateToken(ctx, jane, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	janeRefresh, err = svc.GenerateRefreshToken(ctx, jane, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Logout(ctx, janeAccess, janeRefresh); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.RefreshTokenGrant(ctx, janeRefresh, "", ""); !errors.Is(err, ErrorInvalidRefreshToken) {
+		t.Errorf("own refresh token after logout: got %v, want %v", err, ErrorInvalidRefreshToken)
+	}
+}
@@ -0,0 +1,368 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/crewjam/saml"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	samlLoginTTL         = time.Minute * 10
+	samlRelayStateLength = 32
+	// samlMetadataTTL is how long the metadata of an IdP is cached, it is
+	// fetched again after that to pick up rolled over certificates
+	samlMetadataTTL         = time.Hour * 24
+	samlCertificateLifetime = time.Hour * 24 * 365 * 10
+
+	AttributeGroups = "groups"
+)
+
+var (
+	ErrorInvalidSAMLResponse = errors.New("invalid saml response")
+)
+
+// defaultSAMLAttributes - the assertion attributes user attributes are looked
+// for in unless the provider maps them, matched by name or friendly name
+var defaultSAMLAttributes = map[string][]string{
+	AttributeEmail: {
+		"email",
+		"mail",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	},
+	AttributeGroups: {
+		"groups",
+		"memberOf",
+		"http://schemas.microsoft.com/ws/2008/06/identity/claims/groups",
+	},
+}
+
+// SAMLProvider - a SAML 2.0 identity provider users can sign in with
+type SAMLProvider struct {
+	Name string
+	// MetadataURL is where the IdP publishes its metadata
+	MetadataURL string
+	// NameIDFormat asked for, it has to stay the same across sign ins
+	// since the name id is what the user is linked by
+	NameIDFormat string
+	// AttributeMapping maps user attributes to the assertion attribute they
+	// are read from, e.g. email to urn:oid:1.2.840.113549.1.9.1
+	AttributeMapping map[string]string
+	// AllowedDomains limits sign in to emails of these domains, empty allows any
+	AllowedDomains []string
+	// GroupRoles maps the groups of the user to the roles they get
+	GroupRoles map[string]string
+}
+
+// samlProvider - the service provider end of a SAML identity provider, the
+// IdP metadata is fetched when first needed and cached
+type samlProvider struct {
+	config      SAMLProvider
+	client      *http.Client
+	key         *rsa.PrivateKey
+	certificate *x509.Certificate
+	metadataURL url.URL
+	acsURL      url.URL
+
+	mu        sync.Mutex
+	idp       *saml.EntityDescriptor
+	fetchedAt time.Time
+}
+
+func (s *Service) newSAMLProviders(keyPair tls.Certificate) map[string]*samlProvider {
+	client := &http.Client{Timeout: time.Second * 10}
+	providers := map[string]*samlProvider{}
+	for _, provider := range s.Config.SAMLProviders {
+		base := "/auth/saml/" + url.PathEscape(provider.Name)
+		metadataURL, _ := url.Parse(s.externalURL(base + "/metadata"))
+		acsURL, _ := url.Parse(s.externalURL(base + "/acs"))
+		providers[provider.Name] = &samlProvider{
+			config:      provider,
+			client:      client,
+			key:         keyPair.PrivateKey.(*rsa.PrivateKey),
+			certificate: keyPair.Leaf,
+			metadataURL: *metadataURL,
+			acsURL:      *acsURL,
+		}
+	}
+	return providers
+}
+
+// serviceProvider - our side of the sign in, the entity id is the URL of our
+// metadata for the provider
+func (p *samlProvider) serviceProvider(idp *saml.EntityDescriptor) *saml.ServiceProvider {
+	return &saml.ServiceProvider{
+		EntityID:          p.metadataURL.String(),
+		Key:               p.key,
+		Certificate:       p.certificate,
+		HTTPClient:        p.client,
+		MetadataURL:       p.metadataURL,
+		AcsURL:            p.acsURL,
+		IDPMetadata:       idp,
+		AuthnNameIDFormat: saml.NameIDFormat(p.config.NameIDFormat),
+	}
+}
+
+// identityProvider - returns the metadata of the IdP
+func (p *samlProvider) identityProvider(ctx context.Context) (*saml.EntityDescriptor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.idp != nil && time.Since(p.fetchedAt) < samlMetadataTTL {
+		return p.idp, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.MetadataURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorUpstreamRequest, err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorUpstreamRequest, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, upstreamResponseLimit))
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: metadata of %s answered %d", ErrorUpstreamRequest, p.config.Name, resp.StatusCode)
+	}
+	idp, err := parseSAMLMetadata(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata of %s: %s", ErrorUpstreamRequest, p.config.Name, err)
+	}
+	p.idp = idp
+	p.fetchedAt = time.Now()
+	return idp, nil
+}
+
+// parseSAMLMetadata - reads an EntityDescriptor, or the first one of an
+// EntitiesDescriptor as some IdPs wrap theirs in one
+func parseSAMLMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err == nil {
+		return &entity, nil
+	}
+	var entities saml.EntitiesDescriptor
+	if err := xml.Unmarshal(data, &entities); err != nil {
+		return nil, err
+	}
+	if len(entities.EntityDescriptors) == 0 {
+		return nil, errors.New("no entity descriptor")
+	}
+	return &entities.EntityDescriptors[0], nil
+}
+
+// SAMLProviders - names of the SAML identity providers users can sign in with
+func (s *Service) SAMLProviders() []string {
+	var names []string
+	for _, provider := range s.Config.SAMLProviders {
+		names = append(names, provider.Name)
+	}
+	return names
+}
+
+// SAMLMetadata - the metadata of our service provider for the IdP, it is
+// what the IdP administrator registers us with
+func (s *Service) SAMLMetadata(provider string) ([]byte, error) {
+	p, ok := s.samlProviders[provider]
+	if !ok {
+		return nil, ErrorUnknownProvider
+	}
+	metadata, err := xml.MarshalIndent(p.serviceProvider(nil).Metadata(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), metadata...), nil
+}
+
+// StartSAMLLogin - returns the URL that sends the user to sign in at the IdP
+// with the HTTP-Redirect binding. The relay state sent along is single use
+// and remembers the request ID the response has to answer, along with the
+// authorization request to resume, which is empty when the user signs in
+// directly.
+func (s *Service) StartSAMLLogin(ctx context.Context, provider string, req AuthorizationRequest) (string, error) {
+	p, ok := s.samlProviders[provider]
+	if !ok {
+		return "", ErrorUnknownProvider
+	}
+	idp, err := p.identityProvider(ctx)
+	if err != nil {
+		return "", err
+	}
+	sp := p.serviceProvider(idp)
+	location := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if location == "" {
+		return "", fmt.Errorf("%w: %s has no HTTP-Redirect sign in endpoint", ErrorUpstreamRequest, provider)
+	}
+	authnRequest, err := sp.MakeAuthenticationRequest(location, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", err
+	}
+
+	relayState, err := randomToken(samlRelayStateLength)
+	if err != nil {
+		return "", err
+	}
+	err = s.Store.CreateFederatedLogin(ctx, FederatedLogin{
+		StateHash: hashToken(relayState),
+		Provider:  samlIdentityProvider(provider),
+		Nonce:     authnRequest.ID,
+		Request:   req,
+		ExpiresAt: time.Now().Add(samlLoginTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not store sign in state: %w", err)
+	}
+
+	target, err := authnRequest.Redirect(relayState, sp)
+	if err != nil {
+		return "", err
+	}
+	return target.String(), nil
+}
+
+// CompleteSAMLLogin - handles the response the IdP posted to the ACS, the
+// assertion has to be signed by the IdP, answer our request and be meant for
+// us. Returns the local user, who is created on their first sign in, and the
+// authorization request the sign in started from.
+func (s *Service) CompleteSAMLLogin(ctx context.Context, provider string, samlResponse string, relayState string) (User, AuthorizationRequest, error) {
+	login, err := s.Store.ConsumeFederatedLogin(ctx, hashToken(relayState))
+	if err != nil || login.Provider != samlIdentityProvider(provider) || time.Now().After(login.ExpiresAt) {
+		return User{}, AuthorizationRequest{}, ErrorFederatedLoginState
+	}
+	p, ok := s.samlProviders[provider]
+	if !ok {
+		return User{}, AuthorizationRequest{}, ErrorUnknownProvider
+	}
+	idp, err := p.identityProvider(ctx)
+	if err != nil {
+		return User{}, AuthorizationRequest{}, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return User{}, AuthorizationRequest{}, fmt.Errorf("%w: %s", ErrorInvalidSAMLResponse, err)
+	}
+	assertion, err := p.serviceProvider(idp).ParseXMLResponse(decoded, []string{login.Nonce})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		return User{}, AuthorizationRequest{}, fmt.Errorf("%w: %s", ErrorInvalidSAMLResponse, err)
+	}
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return User{}, AuthorizationRequest{}, fmt.Errorf("%w: no name id", ErrorInvalidSAMLResponse)
+	}
+
+	email, groups := samlAttributes(p.config, assertion)
+	if email == "" {
+		return User{}, AuthorizationRequest{}, fmt.Errorf("%w: no email in the assertion", ErrorInvalidSAMLResponse)
+	}
+	if !emailDomainAllowed(p.config.AllowedDomains, email) {
+		return User{}, AuthorizationRequest{}, ErrorEmailDomainNotAllowed
+	}
+	roles := groupRoles(p.config.GroupRoles, groups)
+	usr, err := s.linkedUser(ctx, samlIdentityProvider(provider), assertion.Subject.NameID.Value, email, roles)
+	if err != nil {
+		return User{}, AuthorizationRequest{}, err
+	}
+	return usr, login.Request, nil
+}
+
+// samlIdentityProvider - the provider SAML accounts are linked under, kept
+// apart from OpenID Connect providers of the same name
+func samlIdentityProvider(provider string) string {
+	return "saml:" + provider
+}
+
+// samlAttributes - reads the email and groups from the assertion following the
+// attribute mapping of the provider. The name id is used as email when the
+// IdP sends it in the email format and no email attribute.
+func samlAttributes(provider SAMLProvider, assertion *saml.Assertion) (string, []string) {
+	values := func(attribute string) []string {
+		names := defaultSAMLAttributes[attribute]
+		if name, ok := provider.AttributeMapping[attribute]; ok {
+			names = []string{name}
+		}
+		var found []string
+		for _, statement := range assertion.AttributeStatements {
+			for _, attr := range statement.Attributes {
+				if !contains(names, attr.Name) && !contains(names, attr.FriendlyName) {
+					continue
+				}
+				for _, value := range attr.Values {
+					found = append(found, strings.TrimSpace(value.Value))
+				}
+			}
+		}
+		return found
+	}
+
+	var email string
+	if emails := values(AttributeEmail); len(emails) > 0 {
+		email = emails[0]
+	} else if assertion.Subject.NameID.Format == string(saml.EmailAddressNameIDFormat) {
+		email = strings.TrimSpace(assertion.Subject.NameID.Value)
+	}
+	return email, values(AttributeGroups)
+}
+
+// newSAMLKeyPair - loads the key and certificate the service provider signs
+// requests and decrypts assertions with, a self signed one is generated when
+// no paths are configured. IdPs that pin it need the paths set since a
+// generated certificate changes on every start.
+func newSAMLKeyPair(config Config) (tls.Certificate, error) {
+	if config.SAMLKeyPath != "" || config.SAMLCertificatePath != "" {
+		keyPair, err := tls.LoadX509KeyPair(config.SAMLCertificatePath, config.SAMLKeyPath)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("could not load saml key pair: %w", err)
+		}
+		if _, ok := keyPair.PrivateKey.(*rsa.PrivateKey); !ok {
+			return tls.Certificate{}, fmt.Errorf("%w: saml key must be an rsa key", ErrorUnsupportedAlgorithm)
+		}
+		keyPair.Leaf, err = x509.ParseCertificate(keyPair.Certificate[0])
+		return keyPair, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, rsaKeySize)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("could not generate saml key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var host string
+	if issuer, err := url.Parse(config.Issuer); err == nil {
+		host = issuer.Hostname()
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(samlCertificateLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("could not create saml certificate: %w", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: certificate}, nil
+}
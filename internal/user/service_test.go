@@ -0,0 +1,376 @@
+package user
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore - keeps users, federated identities and sign in state in
+// memory, the methods of Store the tests do not need panic
+type memoryStore struct {
+	Store
+
+	mu            sync.Mutex
+	users         map[string]User
+	salts         map[string]string
+	identities    map[string]FederatedIdentity
+	logins        map[string]FederatedLogin
+	revoked       map[string]time.Time
+	revokedBefore map[string]time.Time
+	clients       map[string]Client
+	codes         map[string]AuthorizationCode
+	usedCodes     map[string]bool
+	refreshTokens map[string]RefreshToken
+	dpopProofs    map[string]time.Time
+	resets        map[string]PasswordReset
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:         map[string]User{},
+		salts:         map[string]string{},
+		identities:    map[string]FederatedIdentity{},
+		logins:        map[string]FederatedLogin{},
+		revoked:       map[string]time.Time{},
+		revokedBefore: map[string]time.Time{},
+		clients:       map[string]Client{},
+		codes:         map[string]AuthorizationCode{},
+		usedCodes:     map[string]bool{},
+		refreshTokens: map[string]RefreshToken{},
+		dpopProofs:    map[string]time.Time{},
+		resets:        map[string]PasswordReset{},
+	}
+}
+
+func (m *memoryStore) GetUser(ctx context.Context, id string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usr, ok := m.users[id]
+	if !ok {
+		return User{}, ErrorUserNotFound
+	}
+	return usr, nil
+}
+
+func (m *memoryStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, usr := range m.users {
+		if usr.Email == email {
+			return usr, nil
+		}
+	}
+	return User{}, ErrorUserNotFound
+}
+
+func (m *memoryStore) GetUserAndSaltByEmail(ctx context.Context, email string) (User, string, error) {
+	usr, err := m.GetUserByEmail(ctx, email)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return usr, m.salts[usr.ID], err
+}
+
+func (m *memoryStore) PostUser(ctx context.Context, usr User) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usr.ID = strconv.Itoa(len(m.users) + 1)
+	m.users[usr.ID] = usr
+	return usr, nil
+}
+
+func (m *memoryStore) UpdateUserPassword(ctx context.Context, id string, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usr := m.users[id]
+	usr.Password = passwordHash
+	m.users[id] = usr
+	delete(m.salts, id)
+	return nil
+}
+
+func (m *memoryStore) UpdateUserRoles(ctx context.Context, id string, roles []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usr := m.users[id]
+	usr.Roles = roles
+	m.users[id] = usr
+	return nil
+}
+
+func (m *memoryStore) GetFederatedIdentity(ctx context.Context, provider string, subject string) (FederatedIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	identity, ok := m.identities[provider+" "+subject]
+	if !ok {
+		return FederatedIdentity{}, ErrorUserNotFound
+	}
+	return identity, nil
+}
+
+func (m *memoryStore) HasFederatedIdentity(ctx context.Context, userID string, provider string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, identity := range m.identities {
+		if identity.UserID == userID && identity.Provider == provider {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryStore) CreateFederatedIdentity(ctx context.Context, identity FederatedIdentity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.identities[identity.Provider+" "+identity.Subject] = identity
+	return nil
+}
+
+func (m *memoryStore) CreateFederatedLogin(ctx context.Context, login FederatedLogin) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logins[login.StateHash] = login
+	return nil
+}
+
+func (m *memoryStore) ConsumeFederatedLogin(ctx context.Context, stateHash string) (FederatedLogin, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	login, ok := m.logins[stateHash]
+	if !ok {
+		return FederatedLogin{}, ErrorFederatedLoginState
+	}
+	delete(m.logins, stateHash)
+	return login, nil
+}
+
+func (m *memoryStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *memoryStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.revoked[jti]
+	return ok, nil
+}
+
+func (m *memoryStore) RevokeUserTokens(ctx context.Context, userID string, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revokedBefore[userID] = before
+	return nil
+}
+
+func (m *memoryStore) UserTokensRevokedBefore(ctx context.Context, userID string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revokedBefore[userID], nil
+}
+
+func (m *memoryStore) GetClient(ctx context.Context, id string) (Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client, ok := m.clients[id]
+	if !ok {
+		return Client{}, ErrorClientNotFound
+	}
+	return client, nil
+}
+
+func (m *memoryStore) CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code.CodeHash] = code
+	return nil
+}
+
+func (m *memoryStore) ConsumeAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[codeHash]
+	if !ok {
+		return AuthorizationCode{}, ErrorAuthorizationCodeUsed
+	}
+	if m.usedCodes[codeHash] {
+		return code, ErrorAuthorizationCodeUsed
+	}
+	m.usedCodes[codeHash] = true
+	return code, nil
+}
+
+func (m *memoryStore) SetAuthorizationCodeTokens(ctx context.Context, codeHash string, familyID string, jti string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := m.codes[codeHash]
+	code.RefreshFamilyID, code.AccessTokenJTI = familyID, jti
+	m.codes[codeHash] = code
+	return nil
+}
+
+func (m *memoryStore) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token.ID = strconv.Itoa(len(m.refreshTokens) + 1)
+	m.refreshTokens[token.TokenHash] = token
+	return nil
+}
+
+func (m *memoryStore) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.refreshTokens[tokenHash]
+	if !ok {
+		return RefreshToken{}, ErrorInvalidRefreshToken
+	}
+	return token, nil
+}
+
+func (m *memoryStore) MarkRefreshTokenUsed(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, token := range m.refreshTokens {
+		if token.ID != id {
+			continue
+		}
+		if token.UsedAt != nil {
+			return false, nil
+		}
+		now := time.Now()
+		token.UsedAt = &now
+		m.refreshTokens[hash] = token
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *memoryStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for hash, token := range m.refreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			m.refreshTokens[hash] = token
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) RecordDPoPProof(ctx context.Context, id string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, seen := m.dpopProofs[id]; seen {
+		return false, nil
+	}
+	m.dpopProofs[id] = expiresAt
+	return true, nil
+}
+
+func (m *memoryStore) CreatePasswordReset(ctx context.Context, reset PasswordReset) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resets[reset.TokenHash] = reset
+	return nil
+}
+
+func (m *memoryStore) GetPasswordReset(ctx context.Context, tokenHash string) (PasswordReset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reset, ok := m.resets[tokenHash]
+	if !ok {
+		return PasswordReset{}, ErrorInvalidResetToken
+	}
+	return reset, nil
+}
+
+func (m *memoryStore) PasswordResetRequestedSince(ctx context.Context, userID string, since time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, reset := range m.resets {
+		if reset.UserID == userID && reset.CreatedAt.After(since) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryStore) ResetUserPassword(ctx context.Context, tokenHash string, userID string, passwordHash string, revokedBefore time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reset, ok := m.resets[tokenHash]
+	if !ok || reset.UserID != userID || !time.Now().Before(reset.ExpiresAt) {
+		return false, nil
+	}
+	usr := m.users[userID]
+	usr.Password = passwordHash
+	m.users[userID] = usr
+	delete(m.salts, userID)
+	m.revokedBefore[userID] = revokedBefore
+	m.deleteUserPasswordResets(userID)
+	now := time.Now()
+	for hash, token := range m.refreshTokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			m.refreshTokens[hash] = token
+		}
+	}
+	return true, nil
+}
+
+func (m *memoryStore) DeleteUserPasswordResets(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteUserPasswordResets(userID)
+	return nil
+}
+
+func (m *memoryStore) deleteUserPasswordResets(userID string) {
+	for hash, reset := range m.resets {
+		if reset.UserID == userID {
+			delete(m.resets, hash)
+		}
+	}
+}
+
+// testConfig - a dev mode config with cheap password hashing
+func testConfig() Config {
+	return Config{
+		DevMode:           true,
+		SigningAlgorithm:  AlgorithmES256,
+		TokenFormat:       TokenFormatJWT,
+		AccessTokenMode:   AccessTokenModeSigned,
+		KeyGracePeriod:    time.Hour * 2,
+		Issuer:            "http://auth.test",
+		Audiences:         []string{"meathub"},
+		AccessTokenTTL:    time.Hour,
+		RefreshTokenTTL:   time.Hour * 24,
+		IDTokenTTL:        time.Hour,
+		ClockSkew:         time.Second * 30,
+		Argon2Memory:      1024,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+		PasswordResetURL:  "http://app.test/reset-password",
+	}
+}
+
+// newTestService - a service on a memory store with the test config,
+// configure adjusts the config before the service is built
+func newTestService(t *testing.T, configure func(*Config)) (*Service, *memoryStore) {
+	t.Helper()
+	config := testConfig()
+	if configure != nil {
+		configure(&config)
+	}
+	store := newMemoryStore()
+	svc, err := NewService(store, config)
+	if err != nil {
+		t.Fatalf("could not create service: %v", err)
+	}
+	return svc, store
+}
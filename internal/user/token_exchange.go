@@ -0,0 +1,156 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	TokenTypeURIAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var (
+	ErrorInvalidSubjectToken  = errors.New("invalid subject token")
+	ErrorInvalidActorToken    = errors.New("invalid actor token")
+	ErrorUnsupportedTokenType = errors.New("unsupported token type")
+	ErrorInvalidTarget        = errors.New("audience is not accepted by this service")
+)
+
+// TokenExchangeRequest - the parameters of an RFC 8693 token exchange
+type TokenExchangeRequest struct {
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	ActorTokenType     string
+	Audience           string
+	Scope              string
+	RequestedTokenType string
+}
+
+// TokenExchangeGrant - the token-exchange grant, trades a user's access token
+// for one with another audience and the same or narrower scopes. With an
+// actor token the exchange is delegation and the new token names the actor
+// in its act claim, without one the client impersonates the subject.
+func (s *Service) TokenExchangeGrant(ctx context.Context, client Client, req TokenExchangeRequest, jkt string) (TokenSet, error) {
+	if !client.Confidential() || !client.AllowsGrant(GrantTypeTokenExchange) {
+		return TokenSet{}, ErrorUnauthorizedClient
+	}
+	if req.SubjectTokenType != TokenTypeURIAccessToken {
+		return TokenSet{}, fmt.Errorf("%w: subject_token_type %q", ErrorUnsupportedTokenType, req.SubjectTokenType)
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeURIAccessToken {
+		return TokenSet{}, fmt.Errorf("%w: requested_token_type %q", ErrorUnsupportedTokenType, req.RequestedTokenType)
+	}
+	audience := req.Audience
+	if audience == "" {
+		audience = s.Config.DefaultAudience()
+	}
+	if !s.Config.AllowsAudience(audience) {
+		return TokenSet{}, fmt.Errorf("%w: %s", ErrorInvalidTarget, audience)
+	}
+
+	subject, err := s.exchangeableToken(ctx, req.SubjectToken)
+	if err != nil {
+		return TokenSet{}, fmt.Errorf("%w: %s", ErrorInvalidSubjectToken, err)
+	}
+	if IsClientToken(subject) {
+		return TokenSet{}, fmt.Errorf("%w: client credentials tokens can not be exchanged", ErrorInvalidSubjectToken)
+	}
+	if !exchangeableBy(subject, client) {
+		return TokenSet{}, fmt.Errorf("%w: token was issued to another client", ErrorInvalidSubjectToken)
+	}
+	// tokens without a scope claim were issued by /auth/login and carry no
+	// scopes to pass on
+	subjectScope, _ := subject["scope"].(string)
+	scope, err := allowedScope(strings.Fields(subjectScope), req.Scope)
+	if err != nil {
+		return TokenSet{}, err
+	}
+
+	sub, _ := subject["sub"].(string)
+	claims, err := s.accessTokenClaims(sub, audience)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	if email, ok := subject["email"]; ok {
+		claims["email"] = email
+	}
+	claims["client_id"] = client.ID
+	if scope != "" {
+		claims["scope"] = scope
+	}
+	if jkt != "" {
+		claims["cnf"] = map[string]interface{}{"jkt": jkt}
+	}
+	// the exchanged token must not outlive the token it was exchanged for
+	if subjectExp, ok := numericClaim(subject, "exp"); ok && subjectExp.Unix() < claims["exp"].(int64) {
+		claims["exp"] = subjectExp.Unix()
+	}
+
+	if req.ActorToken != "" {
+		act, err := s.actorClaim(ctx, client, req)
+		if err != nil {
+			return TokenSet{}, err
+		}
+		claims["act"] = act
+	}
+
+	accessToken, err := s.issueAccessToken(ctx, claims)
+	if err != nil {
+		return TokenSet{}, err
+	}
+	return TokenSet{
+		AccessToken:     accessToken,
+		TokenType:       TokenType(jkt),
+		ExpiresIn:       claims["exp"].(int64) - time.Now().Unix(),
+		Scope:           scope,
+		IssuedTokenType: TokenTypeURIAccessToken,
+	}, nil
+}
+
+// actorClaim - builds the act claim naming the party the token is delegated
+// to, an actor that was itself acting for someone keeps that chain nested
+func (s *Service) actorClaim(ctx context.Context, client Client, req TokenExchangeRequest) (map[string]interface{}, error) {
+	if req.ActorTokenType != TokenTypeURIAccessToken {
+		return nil, fmt.Errorf("%w: actor_token_type %q", ErrorUnsupportedTokenType, req.ActorTokenType)
+	}
+	actor, err := s.exchangeableToken(ctx, req.ActorToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidActorToken, err)
+	}
+	if clientID, _ := actor["client_id"].(string); clientID != client.ID {
+		return nil, fmt.Errorf("%w: actor token was issued to another client", ErrorInvalidActorToken)
+	}
+	act := map[string]interface{}{"sub": actor["sub"], "client_id": client.ID}
+	if previous, ok := actor["act"]; ok {
+		act["act"] = previous
+	}
+	return act, nil
+}
+
+// exchangeableBy - reports whether the client may exchange the subject token,
+// which it may when the token was issued to it or is addressed to it. Any
+// other token the client got hold of stays with its own audience.
+func exchangeableBy(subject map[string]interface{}, client Client) bool {
+	clientID, _ := subject["client_id"].(string)
+	return clientID == client.ID || contains(audienceClaim(subject), client.ID)
+}
+
+// exchangeableToken - validates a token presented for exchange. The client
+// holds the token but not the key of a DPoP bound token, so those can not be
+// exchanged.
+func (s *Service) exchangeableToken(ctx context.Context, token string) (map[string]interface{}, error) {
+	claims, err := s.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if ConfirmationThumbprint(claims) != "" {
+		return nil, ErrorDPoPProofRequired
+	}
+	return claims, nil
+}
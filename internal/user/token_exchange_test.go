@@ -0,0 +1,131 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// exchangeClient - a confidential client allowed to exchange tokens
+func exchangeClient(id string) Client {
+	return Client{
+		ID:         id,
+		Scopes:     []string{"catalog:read", "orders:write"},
+		GrantTypes: []string{GrantTypeTokenExchange, GrantTypeClientCredentials},
+		SecretHash: hashToken("secret"),
+	}
+}
+
+// clientToken - an access token issued to the user through the client
+func clientToken(t *testing.T, svc *Service, usr User, clientID string, scope string) string {
+	t.Helper()
+	claims, err := svc.userAccessTokenClaims(usr, clientID, scope, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := svc.issueAccessToken(context.Background(), claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTokenExchangeGrant(t *testing.T) {
+	svc, _ := newTestService(t, func(config *Config) {
+		config.Audiences = []string{"meathub", "payments"}
+	})
+	ctx := context.Background()
+	usr := User{ID: "7", Email: "jane@example.com"}
+	client := exchangeClient("meathub")
+	loginToken, err := svc.GenerateToken(ctx, usr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceToken, err := svc.ClientCredentialsGrant(ctx, client, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		client    Client
+		subject   string
+		scope     string
+		wantScope string
+		wantErr   error
+	}{
+		{
+			name:    "login token addressed to the client",
+			client:  client,
+			subject: loginToken,
+		},
+		{
+			name:    "login token gains no scopes",
+			client:  client,
+			subject: loginToken,
+			scope:   "catalog:read",
+			wantErr: ErrorInvalidScope,
+		},
+		{
+			name:      "narrower scope of a token issued to the client",
+			client:    client,
+			subject:   clientToken(t, svc, usr, "meathub", "catalog:read orders:write"),
+			scope:     "catalog:read",
+			wantScope: "catalog:read",
+		},
+		{
+			name:    "broader scope of a token issued to the client",
+			client:  client,
+			subject: clientToken(t, svc, usr, "meathub", "catalog:read"),
+			scope:   "orders:write",
+			wantErr: ErrorInvalidScope,
+		},
+		{
+			name:    "login token addressed to another client",
+			client:  exchangeClient("intruder"),
+			subject: loginToken,
+			wantErr: ErrorInvalidSubjectToken,
+		},
+		{
+			name:    "token issued to another client",
+			client:  exchangeClient("intruder"),
+			subject: clientToken(t, svc, usr, "meathub", "catalog:read"),
+			wantErr: ErrorInvalidSubjectToken,
+		},
+		{
+			name:    "client credentials token",
+			client:  client,
+			subject: serviceToken.AccessToken,
+			wantErr: ErrorInvalidSubjectToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := svc.TokenExchangeGrant(ctx, tt.client, TokenExchangeRequest{
+				SubjectToken:     tt.subject,
+				SubjectTokenType: TokenTypeURIAccessToken,
+				Audience:         "payments",
+				Scope:            tt.scope,
+			}, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if tokens.Scope != tt.wantScope {
+				t.Errorf("scope = %q, want %q", tokens.Scope, tt.wantScope)
+			}
+			claims, err := svc.ValidateToken(ctx, tokens.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims["sub"] != usr.ID || claims["client_id"] != tt.client.ID || claims["aud"] != "payments" {
+				t.Errorf("claims = %v, want the user as subject for payments through %s", claims, tt.client.ID)
+			}
+			if IsClientToken(claims) {
+				t.Error("the exchanged token is typed as a client token")
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	TokenUseAccess  = "access"
+	TokenUseRefresh = "refresh"
+	TokenUseID      = "id"
+
+	// accessTokenType is the RFC 9068 media type of JWT access tokens
+	accessTokenType = "at+jwt"
+)
+
+var (
+	ErrorWrongTokenType = errors.New("wrong token type")
+)
+
+// TokenTypeError - returned when a token is presented where another kind of
+// token is expected, it matches ErrorWrongTokenType with errors.Is
+type TokenTypeError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *TokenTypeError) Error() string {
+	return fmt.Sprintf("wrong token type: expected %s token, got %s", e.Expected, e.Actual)
+}
+
+func (e *TokenTypeError) Is(target error) bool {
+	return target == ErrorWrongTokenType
+}
+
+// checkTokenUse - makes sure the claims belong to the expected kind of token
+func checkTokenUse(claims map[string]interface{}, expected string) error {
+	actual, _ := claims["token_use"].(string)
+	if actual != expected {
+		if actual == "" {
+			actual = "untyped"
+		}
+		return &TokenTypeError{Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+// tokenUseOf - reads the token_use claim of a signed token without verifying
+// it, it is only used to give a better error when a signed token shows up
+// where an opaque one is expected
+func (s *Service) tokenUseOf(token string) (string, bool) {
+	claims, ok := s.Format.Peek(token)
+	if !ok {
+		return "", false
+	}
+	use, _ := claims["token_use"].(string)
+	if use == "" {
+		use = "untyped"
+	}
+	return use, true
+}
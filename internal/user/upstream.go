@@ -0,0 +1,207 @@
+package user
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// upstreamKeysMinRefresh limits how often an unknown kid makes us fetch
+	// the keys of a provider again
+	upstreamKeysMinRefresh = time.Minute
+	upstreamResponseLimit  = 1 << 20
+)
+
+var (
+	ErrorUpstreamRequest = errors.New("request to upstream provider failed")
+	ErrorInvalidIDToken  = errors.New("invalid id token")
+)
+
+// upstreamAlgorithms - algorithms upstream providers may sign ID tokens with
+var upstreamAlgorithms = []string{"RS256", "PS256", "ES256", "ES384", "EdDSA"}
+
+// upstreamMetadata - the part of an OpenID Connect discovery document we use
+type upstreamMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// upstream - an OpenID Connect provider users can sign in with. The
+// discovery document and keys are fetched when first needed and cached.
+type upstream struct {
+	config FederatedProvider
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *upstreamMetadata
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+func newUpstream(config FederatedProvider, client *http.Client) *upstream {
+	return &upstream{config: config, client: client}
+}
+
+// discover - returns the discovery document of the provider
+func (u *upstream) discover(ctx context.Context) (upstreamMetadata, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.metadata != nil {
+		return *u.metadata, nil
+	}
+
+	var metadata upstreamMetadata
+	wellKnown := strings.TrimSuffix(u.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := u.getJSON(ctx, wellKnown, &metadata); err != nil {
+		return upstreamMetadata{}, err
+	}
+	if metadata.Issuer != u.config.Issuer {
+		return upstreamMetadata{}, fmt.Errorf("%w: discovery document is for issuer %q", ErrorUpstreamRequest, metadata.Issuer)
+	}
+	u.metadata = &metadata
+	return metadata, nil
+}
+
+// key - looks up a signing key of the provider, fetching the keys again when
+// the kid is unknown as the provider may have rotated them
+func (u *upstream) key(ctx context.Context, jwksURI string, kid string) (crypto.PublicKey, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if key, ok := u.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(u.keysFetchedAt) < upstreamKeysMinRefresh {
+		return nil, fmt.Errorf("%w: %s", ErrorUnknownKey, kid)
+	}
+
+	var jwks JWKS
+	if err := u.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	u.keys = keys
+	u.keysFetchedAt = time.Now()
+
+	if key, ok := u.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrorUnknownKey, kid)
+}
+
+// exchangeCode - redeems an authorization code at the token endpoint of the
+// provider and returns the ID token
+func (u *upstream) exchangeCode(ctx context.Context, tokenEndpoint string, code string, redirectURI string, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {GrantTypeAuthorizationCode},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(u.config.ClientID), url.QueryEscape(u.config.ClientSecret))
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := u.doJSON(req, &tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("%w: token response has no id_token", ErrorUpstreamRequest)
+	}
+	return tokens.IDToken, nil
+}
+
+// verifyIDToken - checks the signature, issuer, audience, lifetime and nonce
+// of an ID token issued by the provider and returns its claims
+func (u *upstream) verifyIDToken(ctx context.Context, metadata upstreamMetadata, idToken string, nonce string, clockSkew time.Duration) (map[string]interface{}, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(upstreamAlgorithms), jwt.WithoutClaimsValidation())
+	token, err := parser.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return u.key(ctx, metadata.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorInvalidIDToken, err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrorInvalidIDToken
+	}
+
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); iss != metadata.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrorInvalidIDToken, iss)
+	}
+	if !contains(audienceClaim(claims), u.config.ClientID) {
+		return nil, fmt.Errorf("%w: not issued for client %s", ErrorInvalidIDToken, u.config.ClientID)
+	}
+	exp, ok := numericClaim(claims, "exp")
+	if !ok || now.After(exp.Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: expired", ErrorInvalidIDToken)
+	}
+	if iat, ok := numericClaim(claims, "iat"); !ok || iat.After(now.Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: issued in the future", ErrorInvalidIDToken)
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrorInvalidIDToken)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("%w: sub is missing", ErrorInvalidIDToken)
+	}
+	return claims, nil
+}
+
+func (u *upstream) getJSON(ctx context.Context, uri string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return u.doJSON(req, v)
+}
+
+func (u *upstream) doJSON(req *http.Request, v interface{}) error {
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrorUpstreamRequest, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, upstreamResponseLimit))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrorUpstreamRequest, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d: %s", ErrorUpstreamRequest, req.URL.Redacted(), resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %s", ErrorUpstreamRequest, err)
+	}
+	return nil
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"golang.org/x/crypto/bcrypt"
+	log "github.com/sirupsen/logrus"
+	"net/url"
 )
 
 var (
-	ErrorUserExists = errors.New("error creating user")
+	ErrorUserExists   = errors.New("error creating user")
+	ErrorUserNotFound = errors.New("user not found")
 )
 
 // User godoc
@@ -16,29 +18,157 @@ var (
 // @Param   username     string     "Username to use for login"
 // @Param   password     string     "User's password"
 type User struct {
-	ID       string
-	Email    string
-	Password string
+	ID    string
+	Email string
+	// Password is the hash of the password, it is never serialized
+	Password      string `json:"-"`
+	EmailVerified bool
+	// Roles come from the directory groups of users who sign in with LDAP
+	Roles []string
 }
 
 type UserStore interface {
+	// GetUserAndSaltByEmail returns ErrorUserNotFound when no user has the email
 	GetUserAndSaltByEmail(context.Context, string) (User, string, error)
 	GetUser(context.Context, string) (User, error)
 	GetUserByEmail(context.Context, string) (User, error)
 	PostUser(context.Context, User) (User, error)
 	UpdateUser(context.Context, User) (User, error)
+	// UpdateUserPassword stores a new password hash and drops the legacy salt
+	UpdateUserPassword(ctx context.Context, id string, passwordHash string) error
+	UpdateUserRoles(ctx context.Context, id string, roles []string) error
 	DeleteUser(context.Context, string) error
 	Ping(ctx context.Context) error
 }
+
+// Store - everything the service needs persisted
+type Store interface {
+	UserStore
+	RefreshTokenStore
+	RevocationStore
+	AccessTokenStore
+	DPoPReplayStore
+	ClientStore
+	AuthorizationCodeStore
+	DeviceCodeStore
+	FederationStore
+	ConsentStore
+	PasswordResetStore
+	SigningKeyStore
+}
+
 type Service struct {
-	Store UserStore
+	Store  Store
+	Config Config
+	Keys   *Keyring
+	Format TokenFormat
+	// Directory checks passwords of users not known locally, nil when LDAP
+	// sign in is off
+	Directory Directory
+	// Hasher makes the password hashes of new and rehashed passwords
+	Hasher PasswordHasher
+	// PasswordPolicy is checked whenever a user picks a password
+	PasswordPolicy PasswordPolicy
+	// Breaches rejects passwords found in breach corpora, nil when no
+	// corpus is configured
+	Breaches BreachCorpus
+	// Mailer delivers password reset links
+	Mailer Mailer
+
+	upstreams        map[string]*upstream
+	samlProviders    map[string]*samlProvider
+	passwordResetURL *url.URL
+	passwordResets   chan string
+}
+
+func NewService(store Store, config Config) (*Service, error) {
+	signingKey, err := newConfiguredSigningKey(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.AccessTokenMode != AccessTokenModeSigned && config.AccessTokenMode != AccessTokenModeOpaque {
+		return nil, fmt.Errorf("unsupported access token mode %q", config.AccessTokenMode)
+	}
+	if longest := config.longestSignedTokenTTL() + config.ClockSkew; config.KeyGracePeriod < longest {
+		return nil, fmt.Errorf("JWT_KEY_GRACE_PERIOD %s must be at least the longest token lifetime plus the clock skew, %s", config.KeyGracePeriod, longest)
+	}
+	encryption, err := newKeyEncryption(config)
+	if err != nil {
+		return nil, err
+	}
+	if encryption == nil && config.KeyRotationInterval > 0 {
+		return nil, fmt.Errorf("JWT_KEY_ENCRYPTION_KEY is required to rotate signing keys every JWT_KEY_ROTATION_INTERVAL")
+	}
+	keys := NewKeyring(signingKey, config.KeyGracePeriod, store, encryption)
+	format, err := NewTokenFormat(config.TokenFormat, keys)
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := NewPasswordHasher(config)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := NewPasswordPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	var breaches BreachCorpus
+	if config.PasswordBreachCorpusPath != "" {
+		if breaches, err = NewBreachCorpus(config.PasswordBreachCorpusPath); err != nil {
+			return nil, err
+		}
+	}
+	mailer, err := NewMailer(config.Mail)
+	if err != nil {
+		return nil, err
+	}
+	resetURL, err := newPasswordResetURL(config)
+	if err != nil {
+		return nil, err
+	}
+	var directory Directory
+	if config.LDAP.URL != "" {
+		directory = NewLDAPDirectory(config.LDAP)
+	}
+	s := &Service{
+		Store:          store,
+		Config:         config,
+		Keys:           keys,
+		Format:         format,
+		Directory:      directory,
+		Hasher:         hasher,
+		PasswordPolicy: policy,
+		Breaches:       breaches,
+		Mailer:         mailer,
+
+		upstreams:        newUpstreams(config.FederatedProviders),
+		passwordResetURL: resetURL,
+		passwordResets:   make(chan string, passwordResetQueueSize),
+	}
+	if len(config.SAMLProviders) > 0 {
+		keyPair, err := newSAMLKeyPair(config)
+		if err != nil {
+			return nil, err
+		}
+		s.samlProviders = s.newSAMLProviders(keyPair)
+	}
+	return s, nil
 }
 
-func NewService(store UserStore) *Service {
-	return &Service{
-		Store: store,
+// newConfiguredSigningKey - loads the configured signing key, a key generated
+// on start up is only accepted in dev mode as tokens signed with it stop
+// verifying on restart and are not accepted by other replicas
+func newConfiguredSigningKey(config Config) (SigningKey, error) {
+	if config.SigningKeyPath != "" {
+		return LoadSigningKey(config.SigningKeyPath, config.SigningAlgorithm)
+	}
+	if !config.DevMode {
+		return SigningKey{}, fmt.Errorf("JWT_PRIVATE_KEY_PATH is required, set DEV_MODE=true to sign with a key generated on start up")
 	}
+	log.Warn("JWT_PRIVATE_KEY_PATH is not set, signing with a key generated on start up")
+	return NewSigningKey(config.SigningAlgorithm)
 }
+
 func (s *Service) GetUser(ctx context.Context, id string) (User, error) {
 	return s.Store.GetUser(ctx, id)
 }
@@ -46,7 +176,22 @@ func (s *Service) GetUser(ctx context.Context, id string) (User, error) {
 func (s *Service) GetUserByEmail(ctx context.Context, email string) (User, error) {
 	return s.Store.GetUserByEmail(ctx, email)
 }
+
+// PostUser - registers a user whose password passes the password policy
 func (s *Service) PostUser(ctx context.Context, usr User) (User, error) {
+	if err := s.checkPassword(usr.Password, usr.Email); err != nil {
+		return User{}, err
+	}
+	return s.createUser(ctx, usr)
+}
+
+// createUser - stores the user with a hash of its password
+func (s *Service) createUser(ctx context.Context, usr User) (User, error) {
+	hash, err := s.Hasher.Hash(usr.Password)
+	if err != nil {
+		return User{}, fmt.Errorf("error hashing password: %w", err)
+	}
+	usr.Password = hash
 	u, err := s.Store.PostUser(ctx, usr)
 	if err != nil && errors.Is(err, ErrorUserExists) {
 		return User{}, ErrorUserExists
@@ -59,7 +204,16 @@ func (s *Service) PostUser(ctx context.Context, usr User) (User, error) {
 	return u, nil
 }
 
+// UpdateUser - updates the email of the user, passwords only change through
+// ChangePassword and ResetPassword. An email another user has is refused.
 func (s *Service) UpdateUser(ctx context.Context, user User) (User, error) {
+	existing, err := s.Store.GetUserByEmail(ctx, user.Email)
+	if err != nil && !errors.Is(err, ErrorUserNotFound) {
+		return User{}, fmt.Errorf("could not look up user %s: %w", user.Email, err)
+	}
+	if err == nil && existing.ID != user.ID {
+		return User{}, ErrorUserExists
+	}
 	return s.Store.UpdateUser(ctx, user)
 }
 
@@ -70,24 +224,47 @@ func (s *Service) DeleteUser(ctx context.Context, id string) error {
 func (s *Service) ReadyCheck(ctx context.Context) error {
 	return s.Store.Ping(ctx)
 }
+
+// Login - checks the password of a local user. When LDAP sign in is on,
+// users who are not known locally and local users linked to a directory
+// account are checked against the directory, the password of any other local
+// user is never sent to it. Hashes made by an older algorithm or with other
+// parameters are replaced once the password is known to match.
 func (s *Service) Login(ctx context.Context, email string, password string) (User, error) {
 	user, salt, err := s.Store.GetUserAndSaltByEmail(ctx, email)
-
+	if errors.Is(err, ErrorUserNotFound) && s.Directory != nil {
+		return s.directoryLogin(ctx, email, password)
+	}
 	if err != nil {
 		return User{}, err
 	}
-	if !validatePassword(password, salt, user.Password) {
-		return User{}, fmt.Errorf("invalid password for user %s", email)
+	if ok, rehash := s.verifyPassword(password, salt, user.Password); ok {
+		if rehash {
+			s.rehashPassword(ctx, user.ID, password)
+		}
+		return user, nil
+	}
+	// users linked to a directory account have no usable local password
+	if s.Directory != nil {
+		linked, err := s.Store.HasFederatedIdentity(ctx, user.ID, DirectoryProvider)
+		if err != nil {
+			return User{}, fmt.Errorf("could not look up the directory account of user %s: %w", user.ID, err)
+		}
+		if linked {
+			return s.directoryLogin(ctx, email, password)
+		}
 	}
-	return user, nil
+	return User{}, fmt.Errorf("invalid password for user %s", email)
 }
-func validatePassword(cleanPassword, salt, encryptedPassword string) bool {
-	saltedPassword := cleanPassword + salt
 
-	err := bcrypt.CompareHashAndPassword([]byte(encryptedPassword), []byte(saltedPassword))
+// rehashPassword - stores a hash of the password made with the current
+// hasher, a failure only means the next login tries again
+func (s *Service) rehashPassword(ctx context.Context, id string, password string) {
+	hash, err := s.Hasher.Hash(password)
+	if err == nil {
+		err = s.Store.UpdateUserPassword(ctx, id, hash)
+	}
 	if err != nil {
-		return false
+		log.WithError(err).Errorf("could not rehash the password of user %s", id)
 	}
-
-	return true
 }
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS access_tokens
+(
+    token_hash VARCHAR(64) PRIMARY KEY,
+    jti        VARCHAR(64) NOT NULL,
+    subject    VARCHAR(200) NOT NULL,
+    claims     JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS access_tokens_expires_at_idx ON access_tokens (expires_at);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS dpop_proofs;
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS jkt;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS scope;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS client_id;
+
+DROP TABLE IF EXISTS authorization_codes;
+DROP TABLE IF EXISTS clients;
\ No newline at end of file
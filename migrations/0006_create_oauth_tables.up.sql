@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS clients
+(
+    id            VARCHAR(64) PRIMARY KEY,
+    name          VARCHAR(200) NOT NULL,
+    redirect_uris TEXT         NOT NULL DEFAULT '',
+    scopes        TEXT         NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS authorization_codes
+(
+    code_hash             VARCHAR(64) PRIMARY KEY,
+    client_id             VARCHAR(64)  NOT NULL,
+    user_id               INTEGER      NOT NULL,
+    redirect_uri          TEXT         NOT NULL,
+    scope                 TEXT         NOT NULL DEFAULT '',
+    code_challenge        VARCHAR(128) NOT NULL,
+    code_challenge_method VARCHAR(10)  NOT NULL,
+    created_at            TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    expires_at            TIMESTAMPTZ  NOT NULL,
+    used_at               TIMESTAMPTZ
+);
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS client_id VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+
+INSERT INTO clients (id, name, redirect_uris, scopes)
+VALUES ('meathub-web', 'Meathub web', 'http://localhost:3000/callback', 'profile email orders'),
+       ('meathub-mobile', 'Meathub mobile', 'com.meathub.app:/callback', 'profile email orders')
+ON CONFLICT (id) DO NOTHING;
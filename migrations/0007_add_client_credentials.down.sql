@@ -0,0 +1,4 @@
+DELETE FROM clients WHERE id IN ('meathub-orders', 'meathub-catalog');
+
+ALTER TABLE clients DROP COLUMN IF EXISTS grant_types;
+ALTER TABLE clients DROP COLUMN IF EXISTS secret_hash;
\ No newline at end of file
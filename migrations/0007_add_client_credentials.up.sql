@@ -0,0 +1,8 @@
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS secret_hash VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS grant_types TEXT NOT NULL DEFAULT 'authorization_code refresh_token';
+
+-- service clients get a secret through POST /auth/clients/{id}/secret
+INSERT INTO clients (id, name, scopes, grant_types)
+VALUES ('meathub-orders', 'Meathub orders service', 'catalog:read users:read', 'client_credentials'),
+       ('meathub-catalog', 'Meathub catalog service', 'orders:read', 'client_credentials')
+ON CONFLICT (id) DO NOTHING;
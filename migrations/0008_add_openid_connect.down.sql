@@ -0,0 +1,7 @@
+UPDATE clients SET scopes = TRIM(REPLACE(' ' || scopes || ' ', ' openid ', ' '))
+WHERE id IN ('meathub-web', 'meathub-mobile');
+
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS auth_time;
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS nonce;
+
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT '';
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+UPDATE clients SET scopes = 'openid ' || scopes
+WHERE id IN ('meathub-web', 'meathub-mobile') AND ' ' || scopes || ' ' NOT LIKE '% openid %';
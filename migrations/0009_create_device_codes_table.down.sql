@@ -0,0 +1,3 @@
+DELETE FROM clients WHERE id = 'meathub-pos';
+
+DROP TABLE IF EXISTS device_codes;
\ No newline at end of file
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS device_codes
+(
+    device_code_hash VARCHAR(64) PRIMARY KEY,
+    user_code        VARCHAR(16) NOT NULL UNIQUE,
+    client_id        VARCHAR(64) NOT NULL,
+    scope            TEXT        NOT NULL DEFAULT '',
+    user_id          VARCHAR(64) NOT NULL DEFAULT '',
+    status           VARCHAR(16) NOT NULL DEFAULT 'pending',
+    poll_interval    INTEGER     NOT NULL,
+    last_polled_at   TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at       TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS device_codes_expires_at_idx ON device_codes (expires_at);
+
+INSERT INTO clients (id, name, scopes, grant_types)
+VALUES ('meathub-pos', 'Meathub POS terminals and label printers', 'openid profile orders',
+        'urn:ietf:params:oauth:grant-type:device_code refresh_token')
+ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,2 @@
+UPDATE clients SET grant_types = TRIM(REPLACE(grant_types, 'urn:ietf:params:oauth:grant-type:token-exchange', ''))
+WHERE id = 'meathub-orders';
\ No newline at end of file
@@ -0,0 +1,2 @@
+UPDATE clients SET grant_types = grant_types || ' urn:ietf:params:oauth:grant-type:token-exchange'
+WHERE id = 'meathub-orders' AND grant_types NOT LIKE '%token-exchange%';
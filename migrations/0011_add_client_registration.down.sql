@@ -0,0 +1,2 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS registration_token_hash;
+ALTER TABLE clients DROP COLUMN IF EXISTS token_endpoint_auth_method;
\ No newline at end of file
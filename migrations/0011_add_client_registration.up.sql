@@ -0,0 +1,5 @@
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS token_endpoint_auth_method VARCHAR(32) NOT NULL DEFAULT 'none';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS registration_token_hash VARCHAR(64) NOT NULL DEFAULT '';
+
+UPDATE clients SET token_endpoint_auth_method = 'client_secret_basic'
+WHERE id IN ('meathub-orders', 'meathub-catalog');
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS federated_identities
+(
+    provider   VARCHAR(64)  NOT NULL,
+    subject    VARCHAR(255) NOT NULL,
+    user_id    VARCHAR(64)  NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE TABLE IF NOT EXISTS federated_logins
+(
+    state_hash            VARCHAR(64)  PRIMARY KEY,
+    provider              VARCHAR(64)  NOT NULL,
+    nonce                 VARCHAR(64)  NOT NULL,
+    code_verifier         VARCHAR(128) NOT NULL,
+    authorization_request JSONB        NOT NULL,
+    created_at            TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    expires_at            TIMESTAMPTZ  NOT NULL
+);
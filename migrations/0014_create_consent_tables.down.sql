@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS consent_challenges;
+DROP TABLE IF EXISTS consents;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS first_party;
\ No newline at end of file
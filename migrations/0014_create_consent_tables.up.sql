@@ -0,0 +1,24 @@
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS first_party BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- our own apps and services never ask users for consent
+UPDATE clients SET first_party = TRUE
+WHERE id IN ('meathub-web', 'meathub-mobile', 'meathub-pos', 'meathub-orders', 'meathub-catalog');
+
+CREATE TABLE IF NOT EXISTS consents
+(
+    user_id    VARCHAR(64) NOT NULL,
+    client_id  VARCHAR(64) NOT NULL,
+    scopes     TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, client_id)
+);
+
+CREATE TABLE IF NOT EXISTS consent_challenges
+(
+    challenge_hash        VARCHAR(64) PRIMARY KEY,
+    user_id               VARCHAR(64) NOT NULL,
+    authorization_request JSONB       NOT NULL,
+    created_at            TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at            TIMESTAMPTZ NOT NULL
+);
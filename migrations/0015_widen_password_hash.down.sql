@@ -0,0 +1,2 @@
+-- password stays TEXT, argon2id hashes do not fit the old VARCHAR(100)
+ALTER TABLE users ALTER COLUMN salt DROP DEFAULT;
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS access_tokens_subject_idx;
+DROP TABLE IF EXISTS password_resets;
\ No newline at end of file
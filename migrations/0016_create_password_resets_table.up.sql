@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS password_resets
+(
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id    VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON password_resets (user_id);
+
+-- a reset revokes the opaque access tokens of the user
+CREATE INDEX IF NOT EXISTS access_tokens_subject_idx ON access_tokens (subject);
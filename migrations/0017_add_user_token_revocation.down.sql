@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN IF EXISTS tokens_revoked_before;
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS signing_keys
+(
+    id            VARCHAR(64) PRIMARY KEY,
+    algorithm     VARCHAR(16) NOT NULL,
+    -- the PEM encoded private key sealed with JWT_KEY_ENCRYPTION_KEY
+    encrypted_key BYTEA       NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS signing_keys_algorithm_created_at_idx ON signing_keys (algorithm, created_at);
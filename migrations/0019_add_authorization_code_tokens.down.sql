@@ -0,0 +1,2 @@
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS access_token_jti;
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS refresh_family_id;
\ No newline at end of file
@@ -0,0 +1,3 @@
+-- the tokens a code was redeemed for, revoked when the code is presented again
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS refresh_family_id VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS access_token_jti VARCHAR(64) NOT NULL DEFAULT '';